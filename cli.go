@@ -0,0 +1,746 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/export"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+	"github.com/rednexx46/esp32-backend-api/internal/migrate"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/provision"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/tenant"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/term"
+)
+
+const usage = `Usage: esp32-backend-api <command> [arguments]
+
+Commands:
+  serve                               Start the API server (default)
+  migrate up|status                   Apply or list database migrations
+  seed                                Create the admin user from ADMIN_USERNAME/ADMIN_PASSWORD
+  user create -username U [-role R]   Create a user, reading the password from stdin (see user create -h)
+  user passwd -username U             Change a user's password, reading it from stdin, and end their sessions
+  user groups -username U -groups G   Set the device groups a user may see ("*" for all)
+  user reset-mfa -username U          Remove a user's two-factor authentication
+  user tenant -username U -tenant T   Move a user to an organization (empty for platform) and end their sessions
+  user list                           List users
+  org create -id ID -name N           Create an organization (see org create -h)
+  org prefixes -id ID -prefixes P     Set the MQTT topic prefixes of an organization
+  org list                            List organizations
+  device tenant -device D -tenant T   Move a device to an organization (empty for none)
+  provision claim -factory-id F       Create a one-time claim code for a new device (see provision claim -h)
+  provision list                      List provisioning claims
+  export -collection sensors|kpis     Export data as CSV or NDJSON (see export -h)
+  token issue -username U             Issue a JWT, e.g. for a service account (see token issue -h)
+  token revoke -username U -session S Revoke a token from token issue before it expires
+  keys generate -dir D [-alg A]       Write a new token signing key to D (see keys generate -h)
+`
+
+// runCommand dispatches the command line to a subcommand and returns the exit code.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		serve()
+		return 0
+	}
+
+	var err error
+	switch args[0] {
+	case "serve":
+		serve()
+		return 0
+	case "migrate":
+		db.InitDB()
+		err = runMigrate(args[1:])
+	case "seed":
+		db.InitDB()
+		db.SeedAdminUser()
+	case "user":
+		db.InitDB()
+		err = runUser(args[1:])
+	case "export":
+		db.InitDB()
+		err = runExport(args[1:])
+	case "token":
+		db.InitDB()
+		err = runToken(args[1:])
+	case "org":
+		db.InitDB()
+		err = runOrg(args[1:])
+	case "device":
+		db.InitDB()
+		err = runDevice(args[1:])
+	case "provision":
+		db.InitDB()
+		err = runProvision(args[1:])
+	case "keys":
+		err = runKeys(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runMigrate(args []string) error {
+	ctx := context.Background()
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		_, err := migrate.Up(ctx)
+		return err
+	case "status":
+		statuses, err := migrate.GetStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read status: %w", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate action %q, expected up or status", action)
+}
+
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected user create, passwd, groups, reset-mfa, tenant or list")
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+		username := fs.String("username", "", "Username")
+		role := fs.String("role", "user", "Role, e.g. admin or user")
+		groups := fs.String("groups", "", "Comma-separated device groups")
+		tenant := fs.String("tenant", "", "Organization of the user (default: none, a platform user)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+		if err := checkTenant(ctx, *tenant); err != nil {
+			return err
+		}
+		if err := rbac.LoadRoles(); err != nil {
+			return err
+		}
+		if !rbac.IsRole(*role) {
+			return fmt.Errorf("-role must be one of %s", strings.Join(rbac.Roles(), ", "))
+		}
+		if _, err := db.FindUserByUsername(ctx, *username); err == nil {
+			return fmt.Errorf("user %q already exists", *username)
+		} else if !errors.Is(err, db.ErrUserNotFound) {
+			return err
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := utils.LoadPasswordPolicy().Check(*username, password); err != nil {
+			return err
+		}
+		hashed, err := utils.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		user := models.User{
+			Username:     *username,
+			Password:     hashed,
+			Role:         *role,
+			CreatedAt:    time.Now().UTC(),
+			TenantID:     *tenant,
+			DeviceGroups: splitList(*groups),
+		}
+		if err := db.CreateUser(user); err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.UserCreate, TenantID: user.TenantID, TargetType: "user", TargetID: user.Username,
+			Changes: audit.Diff(nil, user)})
+		return nil
+
+	case "passwd":
+		fs := flag.NewFlagSet("user passwd", flag.ContinueOnError)
+		username := fs.String("username", "", "Username")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		if err := utils.LoadPasswordPolicy().Check(*username, password); err != nil {
+			return err
+		}
+		hashed, err := utils.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if err := db.UpdateUserPassword(ctx, *username, hashed); err != nil {
+			return err
+		}
+		revoked, err := db.RevokeOtherSessions(ctx, *username, "", time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.UserPasswordChange, TargetType: "user", TargetID: *username,
+			Details: map[string]any{"revoked_sessions": revoked}})
+		fmt.Printf("Password updated, %d sessions revoked.\n", revoked)
+		return nil
+
+	case "groups":
+		fs := flag.NewFlagSet("user groups", flag.ContinueOnError)
+		username := fs.String("username", "", "Username")
+		groups := fs.String("groups", "", "Comma-separated device groups, \"*\" for all, empty for none")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+		before, err := db.FindUserByUsername(ctx, *username)
+		if err != nil {
+			return err
+		}
+		if err := db.SetUserDeviceGroups(ctx, *username, splitList(*groups)); err != nil {
+			return err
+		}
+		after := *before
+		after.DeviceGroups = splitList(*groups)
+		cliAudit(ctx, models.AuditEvent{Action: audit.UserGroupsChange, TargetType: "user", TargetID: *username,
+			Changes: audit.Diff(before, after)})
+		return nil
+
+	case "reset-mfa":
+		fs := flag.NewFlagSet("user reset-mfa", flag.ContinueOnError)
+		username := fs.String("username", "", "Username")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+		if err := db.DisableTOTP(ctx, *username); err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.MFAReset, TargetType: "user", TargetID: *username})
+		fmt.Println("Two-factor authentication removed.")
+		return nil
+
+	case "tenant":
+		fs := flag.NewFlagSet("user tenant", flag.ContinueOnError)
+		username := fs.String("username", "", "Username")
+		tenant := fs.String("tenant", "", "Organization, empty to make the user a platform user")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+		if err := checkTenant(ctx, *tenant); err != nil {
+			return err
+		}
+		before, err := db.FindUserByUsername(ctx, *username)
+		if err != nil {
+			return err
+		}
+		if err := db.SetUserTenant(ctx, *username, *tenant); err != nil {
+			return err
+		}
+		// Tokens carry the tenant, so sessions issued for the old one must end
+		revoked, err := db.RevokeOtherSessions(ctx, *username, "", time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		after := *before
+		after.TenantID = *tenant
+		cliAudit(ctx, models.AuditEvent{Action: audit.UserTenantChange, TenantID: *tenant, TargetType: "user",
+			TargetID: *username, Changes: audit.Diff(before, after), Details: map[string]any{"revoked_sessions": revoked}})
+		fmt.Printf("Tenant updated, %d sessions revoked.\n", revoked)
+		return nil
+
+	case "list":
+		users, err := db.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USERNAME\tROLE\tTENANT\tGROUPS\tMFA\tCREATED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", u.Username, u.Role, u.TenantID, strings.Join(u.DeviceGroups, ","), u.TOTPEnabled, u.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown user action %q, expected create, passwd, groups, reset-mfa, tenant or list", args[0])
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	collection := fs.String("collection", "sensors", "Collection to export: sensors or kpis")
+	format := fs.String("format", export.FormatCSV, "Output format: csv or ndjson")
+	from := fs.String("from", "", "Start of the time range (RFC3339)")
+	to := fs.String("to", "", "End of the time range (RFC3339)")
+	deviceID := fs.String("device-id", "", "Only export this device")
+	tenant := fs.String("tenant", "", "Only export this organization's devices")
+	out := fs.String("out", "", "Output file (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var coll *mongo.Collection
+	var columns []string
+	decrypt := false
+	switch *collection {
+	case "sensors":
+		coll, columns, decrypt = db.SensorsCollection(), export.SensorColumns, true
+	case "kpis":
+		coll, columns = db.KPIsCollection(), export.KPIColumns
+	default:
+		return errors.New("-collection must be sensors or kpis")
+	}
+	if *format != export.FormatCSV && *format != export.FormatNDJSON {
+		return export.ErrInvalidFormat
+	}
+
+	filter, err := export.Filter(*deviceID, *from, *to)
+	if err != nil {
+		return err
+	}
+	if *tenant != "" {
+		ids, err := db.DeviceIDsInTenant(context.Background(), *tenant)
+		if err != nil {
+			return err
+		}
+		filter = rbac.Scope{DeviceIDs: ids, TenantID: *tenant}.Apply(filter)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	ctx := context.Background()
+	cursor, err := export.Find(ctx, coll, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	bw := bufio.NewWriter(w)
+	if err := export.Write(ctx, bw, cursor, *format, columns, decrypt); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func runToken(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected token issue or revoke")
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "issue":
+		fs := flag.NewFlagSet("token issue", flag.ContinueOnError)
+		username := fs.String("username", "", "Subject of the token")
+		role := fs.String("role", "", "Role claim (default: the user's role)")
+		tenant := fs.String("tenant", "", "Tenant claim (default: the user's tenant)")
+		ttl := fs.Duration("ttl", 24*time.Hour, "Token lifetime, e.g. 720h")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" {
+			return errors.New("-username is required")
+		}
+		if *ttl <= 0 {
+			return errors.New("-ttl must be positive")
+		}
+
+		// Service accounts do not need a users entry, but then the role must be explicit.
+		// Without a users entry or -tenant, the token is not limited to a tenant.
+		user, err := db.FindUserByUsername(ctx, *username)
+		if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+			return fmt.Errorf("look up %q: %w", *username, err)
+		}
+		if *role == "" {
+			if user == nil {
+				return fmt.Errorf("-role is required when %q is not a user", *username)
+			}
+			*role = user.Role
+		}
+		if *tenant == "" && user != nil {
+			*tenant = user.TenantID
+		}
+		if err := checkTenant(ctx, *tenant); err != nil {
+			return err
+		}
+
+		// The token belongs to a session like a login, so it can be revoked before it expires
+		now := time.Now().UTC()
+		session := models.Session{
+			ID:        utils.NewID(),
+			Username:  *username,
+			Method:    "cli",
+			CreatedAt: now,
+			ExpiresAt: now.Add(*ttl),
+		}
+		if err := db.CreateSession(ctx, session); err != nil {
+			return err
+		}
+		token, err := utils.GenerateSessionToken(*username, *role, *tenant, session.ID, *ttl)
+		if err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.TokenIssue, TenantID: *tenant, TargetType: "user",
+			TargetID: *username, Details: map[string]any{"role": *role, "ttl": ttl.String(), "session_id": session.ID}})
+		fmt.Fprintf(os.Stderr, "Session %s, revoke with: token revoke -username %s -session %s\n", session.ID, *username, session.ID)
+		fmt.Println(token)
+		return nil
+
+	case "revoke":
+		fs := flag.NewFlagSet("token revoke", flag.ContinueOnError)
+		username := fs.String("username", "", "Subject of the token")
+		sessionID := fs.String("session", "", "Session ID printed by token issue")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *username == "" || *sessionID == "" {
+			return errors.New("-username and -session are required")
+		}
+		if err := db.RevokeSession(ctx, *username, *sessionID, time.Now().UTC()); err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.SessionRevoke, TargetType: "session", TargetID: *sessionID,
+			Details: map[string]any{"username": *username}})
+		fmt.Println("Session revoked.")
+		return nil
+	}
+	return fmt.Errorf("unknown token action %q, expected issue or revoke", args[0])
+}
+
+func runKeys(args []string) error {
+	if len(args) == 0 || args[0] != "generate" {
+		return errors.New("expected keys generate")
+	}
+
+	fs := flag.NewFlagSet("keys generate", flag.ContinueOnError)
+	dir := fs.String("dir", os.Getenv("JWT_KEYS_DIR"), "Key directory (default: JWT_KEYS_DIR)")
+	alg := fs.String("alg", "EdDSA", "Algorithm: EdDSA or RS256")
+	kid := fs.String("kid", time.Now().UTC().Format("20060102-150405"), "Key ID, also the file name")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("-dir is required")
+	}
+	if *kid == "" || strings.ContainsAny(*kid, "/\\") {
+		return errors.New("-kid must be a plain file name")
+	}
+
+	key, err := jwtkeys.Generate(*alg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(*dir, *kid+".pem")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s key %s. It signs new tokens after a restart unless JWT_SIGNING_KID names another key.\n", *alg, path)
+	return nil
+}
+
+// cliAudit records an action taken with the CLI, attributed to the operating system user.
+func cliAudit(ctx context.Context, event models.AuditEvent) {
+	event.ActorType = audit.ActorCLI
+	event.Actor = "unknown"
+	if u, err := user.Current(); err == nil {
+		event.Actor = u.Username
+	}
+	audit.Record(ctx, event)
+}
+
+func runOrg(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected org create, prefixes or list")
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("org create", flag.ContinueOnError)
+		id := fs.String("id", "", "Organization ID, a lowercase slug stored as tenant_id")
+		name := fs.String("name", "", "Display name")
+		prefixes := fs.String("prefixes", "", "Comma-separated MQTT topic prefixes, e.g. sites/acme/")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *id == "" || *name == "" {
+			return errors.New("-id and -name are required")
+		}
+		if !tenant.ValidID(*id) {
+			return errors.New("-id must be lowercase letters, digits and dashes")
+		}
+		if err := checkTopicPrefixes(ctx, *id, splitList(*prefixes)); err != nil {
+			return err
+		}
+		org := models.Organization{ID: *id, Name: *name, TopicPrefixes: splitList(*prefixes), CreatedAt: time.Now().UTC()}
+		if err := db.CreateOrganization(ctx, org); err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.OrganizationCreate, TenantID: org.ID, TargetType: "organization",
+			TargetID: org.ID, Changes: audit.Diff(nil, org)})
+		return nil
+
+	case "prefixes":
+		fs := flag.NewFlagSet("org prefixes", flag.ContinueOnError)
+		id := fs.String("id", "", "Organization ID")
+		prefixes := fs.String("prefixes", "", "Comma-separated MQTT topic prefixes, empty for none")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		before, err := db.GetOrganization(ctx, *id)
+		if err != nil {
+			return err
+		}
+		if err := checkTopicPrefixes(ctx, *id, splitList(*prefixes)); err != nil {
+			return err
+		}
+		if err := db.SetOrganizationTopicPrefixes(ctx, *id, splitList(*prefixes)); err != nil {
+			return err
+		}
+		after := *before
+		after.TopicPrefixes = splitList(*prefixes)
+		cliAudit(ctx, models.AuditEvent{Action: audit.OrganizationUpdate, TenantID: *id, TargetType: "organization",
+			TargetID: *id, Changes: audit.Diff(before, after)})
+		fmt.Printf("Topic prefixes updated; running servers pick them up within %s.\n", tenant.RefreshInterval)
+		return nil
+
+	case "list":
+		orgs, err := db.ListOrganizations(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tTOPIC PREFIXES\tCREATED")
+		for _, o := range orgs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.ID, o.Name, strings.Join(o.TopicPrefixes, ","), o.CreatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown org action %q, expected create, prefixes or list", args[0])
+}
+
+func runDevice(args []string) error {
+	if len(args) == 0 || args[0] != "tenant" {
+		return errors.New("expected device tenant")
+	}
+	ctx := context.Background()
+
+	fs := flag.NewFlagSet("device tenant", flag.ContinueOnError)
+	deviceID := fs.String("device", "", "Device ID")
+	tenantID := fs.String("tenant", "", "Organization, empty to remove the device from its tenant")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *deviceID == "" {
+		return errors.New("-device is required")
+	}
+	if err := checkTenant(ctx, *tenantID); err != nil {
+		return err
+	}
+	if err := db.SetDeviceTenant(ctx, *deviceID, *tenantID); err != nil {
+		return err
+	}
+	cliAudit(ctx, models.AuditEvent{Action: audit.DeviceTenantChange, TenantID: *tenantID, TargetType: "device",
+		TargetID: *deviceID})
+	return nil
+}
+
+func runProvision(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected provision claim or list")
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "claim":
+		fs := flag.NewFlagSet("provision claim", flag.ContinueOnError)
+		factoryID := fs.String("factory-id", "", "Factory ID the device presents")
+		deviceID := fs.String("device", "", "Device ID to register it as (default: the factory ID)")
+		tenantID := fs.String("tenant", "", "Organization the device joins")
+		groups := fs.String("groups", "", "Comma-separated device groups")
+		ttl := fs.Duration("ttl", 0, "How long the code stays valid (default: PROVISIONING_CLAIM_TTL_HOURS)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *factoryID == "" {
+			return errors.New("-factory-id is required")
+		}
+		if *deviceID == "" {
+			*deviceID = *factoryID
+		}
+		if !provision.ValidDeviceID(*deviceID) {
+			return errors.New("the device ID must not contain spaces or the characters / + # $")
+		}
+		if err := checkTenant(ctx, *tenantID); err != nil {
+			return err
+		}
+		if *ttl <= 0 {
+			cfg, _ := provision.LoadConfig()
+			*ttl = cfg.ClaimTTL
+		}
+
+		now := time.Now().UTC()
+		code, hash := provision.GenerateClaimCode()
+		claim := models.ProvisioningClaim{
+			FactoryID: *factoryID,
+			CodeHash:  hash,
+			DeviceID:  *deviceID,
+			TenantID:  *tenantID,
+			Groups:    splitList(*groups),
+			CreatedBy: "cli",
+			CreatedAt: now,
+			ExpiresAt: now.Add(*ttl),
+		}
+		if err := db.CreateClaim(ctx, &claim); err != nil {
+			return err
+		}
+		cliAudit(ctx, models.AuditEvent{Action: audit.ClaimCreate, TenantID: claim.TenantID, TargetType: "device",
+			TargetID: claim.DeviceID, Changes: audit.Diff(nil, claim)})
+		fmt.Println(code)
+		return nil
+
+	case "list":
+		claims, err := db.ListClaims(ctx, bson.M{})
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "FACTORY ID\tDEVICE\tTENANT\tEXPIRES\tCLAIMED")
+		for _, c := range claims {
+			claimed := "-"
+			if c.ClaimedAt != nil {
+				claimed = c.ClaimedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.FactoryID, c.DeviceID, c.TenantID, c.ExpiresAt.Format(time.RFC3339), claimed)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown provision action %q, expected claim or list", args[0])
+}
+
+// checkTenant returns an error unless tenantID is empty or names an organization.
+func checkTenant(ctx context.Context, tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	if _, err := db.GetOrganization(ctx, tenantID); err != nil {
+		return fmt.Errorf("tenant %q: %w", tenantID, err)
+	}
+	return nil
+}
+
+// checkTopicPrefixes returns an error if another organization already uses one of the
+// prefixes.
+func checkTopicPrefixes(ctx context.Context, orgID string, prefixes []string) error {
+	orgs, err := db.ListOrganizations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		if !tenant.ValidPrefix(prefix) {
+			return fmt.Errorf("topic prefix %q must end in / and contain no wildcards", prefix)
+		}
+		if owner := tenant.PrefixOwner(orgs, prefix, orgID); owner != "" {
+			return fmt.Errorf("topic prefix %q overlaps a prefix of %s", prefix, owner)
+		}
+	}
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readPassword reads a password without echoing it when stdin is a terminal, or from
+// the first line of stdin when it is piped in by scripts. Callers check it against the
+// password policy.
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,130 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+var logger = logging.For("audit")
+
+// Audited actions.
+const (
+	Login                = "auth.login"
+	MFALogin             = "auth.mfa"
+	OIDCLogin            = "auth.oidc_login"
+	Logout               = "auth.logout"
+	SessionRevoke        = "session.revoke"
+	UserCreate           = "user.create"
+	UserPasswordChange   = "user.password_change"
+	UserGroupsChange     = "user.groups_change"
+	UserRoleChange       = "user.role_change"
+	UserTenantChange     = "user.tenant_change"
+	UserUnlock           = "user.unlock"
+	MFAEnable            = "user.mfa_enable"
+	MFADisable           = "user.mfa_disable"
+	MFAReset             = "user.mfa_reset"
+	RecoveryCodesRenew   = "user.recovery_codes_renew"
+	ServiceAccountCreate = "service_account.create"
+	APIKeyCreate         = "api_key.create"
+	APIKeyRevoke         = "api_key.revoke"
+	TokenIssue           = "token.issue"
+	OrganizationCreate   = "organization.create"
+	OrganizationUpdate   = "organization.update"
+	DeviceTenantChange   = "device.tenant_change"
+	DeviceUpdate         = "device.update"
+	LocationCreate       = "location.create"
+	LocationRename       = "location.rename"
+	LocationDelete       = "location.delete"
+	ClaimCreate          = "provisioning.claim_create"
+	ClaimDelete          = "provisioning.claim_delete"
+	DeviceProvision      = "device.provision"
+	KPIDefinitionCreate  = "kpi_definition.create"
+	KPIDefinitionUpdate  = "kpi_definition.update"
+	KPIDefinitionDelete  = "kpi_definition.delete"
+	DataExport           = "data.export"
+	KPIExport            = "kpi.export"
+	AuditExport          = "audit.export"
+)
+
+// Outcomes.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Actor types.
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+	ActorOIDC   = "oidc"
+	ActorCLI    = "cli"
+	ActorDevice = "device" // A device bootstrapping with its claim code
+)
+
+// Record appends the event to the audit log. It is not cancelled with the request, so an
+// event is kept even if the client disconnects, and a failure is logged rather than
+// failing the audited operation.
+func Record(ctx context.Context, event models.AuditEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.RequestID == "" {
+		event.RequestID = logging.RequestID(ctx)
+	}
+	if event.Outcome == "" {
+		event.Outcome = Success
+	}
+
+	if err := db.InsertAuditEvent(context.WithoutCancel(ctx), event); err != nil {
+		logger.ErrorContext(ctx, "Failed to record audit event", "action", event.Action, "actor", event.Actor, "error", err)
+		return
+	}
+	logger.InfoContext(ctx, "Audit", "action", event.Action, "outcome", event.Outcome, "actor", event.Actor,
+		"target_type", event.TargetType, "target_id", event.TargetID)
+}
+
+// Diff returns the top-level fields that differ between two values, compared through
+// their JSON form so fields hidden from JSON, such as password hashes, never appear.
+// Either value may be nil for a created or deleted target.
+func Diff(before, after any) []models.AuditChange {
+	b, a := toMap(before), toMap(after)
+
+	fields := map[string]bool{}
+	for k := range b {
+		fields[k] = true
+	}
+	for k := range a {
+		fields[k] = true
+	}
+
+	var changes []models.AuditChange
+	for field := range fields {
+		if !reflect.DeepEqual(b[field], a[field]) {
+			changes = append(changes, models.AuditChange{Field: field, Before: b[field], After: a[field]})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+func toMap(v any) map[string]any {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/export"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Query selects audit events. Empty fields match everything; an action ending in ".*"
+// matches every action with that prefix, e.g. "auth.*".
+type Query struct {
+	Actor      string
+	Action     string
+	Outcome    string
+	TargetType string
+	TargetID   string
+	TenantID   string
+	From       time.Time
+	To         time.Time
+}
+
+// Filter returns the MongoDB filter for the query.
+func (q Query) Filter() bson.M {
+	filter := bson.M{}
+	for field, value := range map[string]string{
+		"actor":       q.Actor,
+		"outcome":     q.Outcome,
+		"target_type": q.TargetType,
+		"target_id":   q.TargetID,
+		"tenant_id":   q.TenantID,
+	} {
+		if value != "" {
+			filter[field] = value
+		}
+	}
+	if prefix, ok := strings.CutSuffix(q.Action, ".*"); ok {
+		filter["action"] = bson.M{"$regex": "^" + regexp.QuoteMeta(prefix+".")}
+	} else if q.Action != "" {
+		filter["action"] = q.Action
+	}
+
+	timeRange := bson.M{}
+	if !q.From.IsZero() {
+		timeRange["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		timeRange["$lte"] = q.To
+	}
+	if len(timeRange) > 0 {
+		filter["time"] = timeRange
+	}
+	return filter
+}
+
+var csvColumns = []string{
+	"time", "action", "outcome", "actor", "actor_type", "api_key_id", "tenant_id",
+	"client_ip", "request_id", "target_type", "target_id", "changes", "details",
+}
+
+// WriteCSV streams the events from the cursor as CSV, one row per event, with changes
+// and details as JSON.
+func WriteCSV(ctx context.Context, w io.Writer, cursor *mongo.Cursor) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write(csvColumns); err != nil {
+		return err
+	}
+	for cursor.Next(ctx) {
+		var e models.AuditEvent
+		if err := cursor.Decode(&e); err != nil {
+			return err
+		}
+		row := []string{
+			e.Time.UTC().Format(time.RFC3339Nano), e.Action, e.Outcome, e.Actor, e.ActorType, e.APIKeyID, e.TenantID,
+			e.ClientIP, e.RequestID, e.TargetType, e.TargetID, jsonCell(e.Changes), jsonCell(e.Details),
+		}
+		// Actors of failed logins are whatever the client sent, so no cell is trusted
+		for i := range row {
+			row[i] = export.SafeCell(row[i])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		cw.Flush()
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return cursor.Err()
+}
+
+func jsonCell(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return ""
+	}
+	return string(data)
+}
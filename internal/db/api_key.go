@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+func apiKeysCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_API_KEYS_COLLECTION", "api_keys"))
+}
+
+// CreateAPIKey stores a new API key and sets its ID.
+func CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := apiKeysCollection().InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		key.ID = id
+	}
+	return nil
+}
+
+// FindAPIKeyByKeyID returns the API key with the given lookup ID.
+func FindAPIKeyByKeyID(ctx context.Context, keyID string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	err := apiKeysCollection().FindOne(ctx, bson.M{"key_id": keyID}).Decode(&key)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the API keys, optionally of one service account or tenant, newest first.
+func ListAPIKeys(ctx context.Context, serviceAccount, tenantID string) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if serviceAccount != "" {
+		filter["service_account"] = serviceAccount
+	}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := apiKeysCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey marks the API key with the given ID as revoked. Revoking an already
+// revoked key keeps the original revocation time. With a tenantID, keys of other tenants
+// are reported as not found.
+func RevokeAPIKey(ctx context.Context, id primitive.ObjectID, tenantID string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+	res, err := apiKeysCollection().UpdateOne(ctx,
+		filter,
+		bson.A{bson.M{"$set": bson.M{"revoked_at": bson.M{"$ifNull": bson.A{"$revoked_at", at}}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that the API key was used at the given time.
+func TouchAPIKey(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := apiKeysCollection().UpdateByID(ctx, id, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
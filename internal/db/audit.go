@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit collection is append-only: this file deliberately offers no update or delete.
+
+func auditCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_AUDIT_COLLECTION", "audit"))
+}
+
+// InsertAuditEvent appends an event to the audit log.
+func InsertAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := auditCollection().InsertOne(ctx, event)
+	return err
+}
+
+// FindAuditEvents returns up to limit events matching filter, newest first, after
+// skipping the first skip.
+func FindAuditEvents(ctx context.Context, filter bson.M, limit, skip int64) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit).
+		SetSkip(skip)
+	cursor, err := auditCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AuditCursor opens a cursor over every event matching filter, oldest first, for exports.
+// It has no timeout of its own, so callers bound it with ctx.
+func AuditCursor(ctx context.Context, filter bson.M) (*mongo.Cursor, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}, {Key: "_id", Value: 1}})
+	return auditCollection().Find(ctx, filter, opts)
+}
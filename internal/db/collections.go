@@ -0,0 +1,37 @@
+package db
+
+import (
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionName returns the collection name set in the environment variable envKey, or
+// fallback when it is unset. Every package names its collections through it, so a
+// collection has the same default wherever it is used.
+func CollectionName(envKey, fallback string) string {
+	if name := os.Getenv(envKey); name != "" {
+		return name
+	}
+	return fallback
+}
+
+// SensorsCollection returns the raw sensor data collection.
+func SensorsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_SENSORS_COLLECTION", "sensor_data"))
+}
+
+// DevicesCollection returns the device registry collection.
+func DevicesCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_DEVICES_COLLECTION", "devices"))
+}
+
+// MeshEventsCollection returns the collection holding the mesh topology history.
+func MeshEventsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_MESH_EVENTS_COLLECTION", "mesh_events"))
+}
+
+// KPIsCollection returns the computed KPI collection.
+func KPIsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_KPIS_COLLECTION", "kpis"))
+}
@@ -0,0 +1,238 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDeviceNotFound is returned when no device matches the given ID.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// DeviceIDsInGroups returns the IDs of the devices that belong to any of the groups,
+// limited to the tenant's devices when tenantID is set.
+func DeviceIDsInGroups(ctx context.Context, tenantID string, groups []string) ([]string, error) {
+	if len(groups) == 0 {
+		return []string{}, nil
+	}
+	filter := bson.M{"groups": bson.M{"$in": groups}}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+	return distinctDeviceIDs(ctx, filter)
+}
+
+// DeviceIDsInTenant returns the IDs of the tenant's devices.
+func DeviceIDsInTenant(ctx context.Context, tenantID string) ([]string, error) {
+	return distinctDeviceIDs(ctx, bson.M{"tenant_id": tenantID})
+}
+
+func distinctDeviceIDs(ctx context.Context, filter bson.M) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	values, err := DevicesCollection().Distinct(ctx, "device_id", filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// DeviceTenants maps the ID of every device assigned to a tenant to that tenant.
+func DeviceTenants(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"tenant_id": bson.M{"$exists": true, "$ne": ""}}
+	opts := options.Find().SetProjection(bson.M{"device_id": 1, "tenant_id": 1})
+	cursor, err := DevicesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tenants := map[string]string{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			DeviceID string `bson:"device_id"`
+			TenantID string `bson:"tenant_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, err
+		}
+		tenants[doc.DeviceID] = doc.TenantID
+	}
+	return tenants, cursor.Err()
+}
+
+// ClaimDeviceForTenant assigns an unassigned device to the tenant, registering the device
+// if it is new, and returns the tenant the device belongs to afterwards. A device that
+// already belongs to another tenant keeps it.
+func ClaimDeviceForTenant(ctx context.Context, deviceID, tenantID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := DevicesCollection().UpdateOne(ctx,
+		bson.M{"device_id": deviceID, "tenant_id": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"tenant_id": tenantID}},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var doc struct {
+		TenantID string `bson:"tenant_id"`
+	}
+	err = DevicesCollection().FindOne(ctx, bson.M{"device_id": deviceID}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		_, err = DevicesCollection().InsertOne(ctx, bson.M{"device_id": deviceID, "tenant_id": tenantID})
+		if mongo.IsDuplicateKeyError(err) {
+			return ClaimDeviceForTenant(ctx, deviceID, tenantID)
+		}
+		return tenantID, err
+	}
+	return doc.TenantID, err
+}
+
+// SetDeviceTenant moves a device to the tenant, registering it if needed, or removes it
+// from its tenant when tenantID is empty.
+func SetDeviceTenant(ctx context.Context, deviceID, tenantID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"tenant_id": tenantID}}
+	if tenantID == "" {
+		update = bson.M{"$unset": bson.M{"tenant_id": ""}}
+	}
+	_, err := DevicesCollection().UpdateOne(ctx, bson.M{"device_id": deviceID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// GetDevice returns the registry entry of a device.
+func GetDevice(ctx context.Context, deviceID string) (*models.Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var device models.Device
+	err := DevicesCollection().FindOne(ctx, bson.M{"device_id": deviceID}).Decode(&device)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrDeviceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// ListDevices returns the registry entries matching the filter, sorted by device ID.
+func ListDevices(ctx context.Context, filter bson.M) ([]models.Device, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "device_id", Value: 1}})
+	cursor, err := DevicesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []models.Device{}
+	if err := cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// UpdateDevice replaces the name, groups, tags and location of a device, registering it
+// if it is new. The tenant is left as it is.
+func UpdateDevice(ctx context.Context, device models.Device) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	set := bson.M{
+		"groups":     device.Groups,
+		"tags":       device.Tags,
+		"updated_at": device.UpdatedAt,
+	}
+	unset := bson.M{}
+	for field, value := range map[string]any{
+		"name":          device.Name,
+		"location":      device.Location,
+		"location_id":   device.LocationID,
+		"location_path": device.LocationPath,
+	} {
+		if isZero(value) {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err := DevicesCollection().UpdateOne(ctx, bson.M{"device_id": device.DeviceID}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+func isZero(v any) bool {
+	switch v := v.(type) {
+	case string:
+		return v == ""
+	case *primitive.ObjectID:
+		return v == nil
+	case []primitive.ObjectID:
+		return len(v) == 0
+	}
+	return v == nil
+}
+
+// SelectDeviceIDs returns the IDs of the devices matching the selector, limited to the
+// tenant's devices when tenantID is set.
+func SelectDeviceIDs(ctx context.Context, tenantID string, sel models.DeviceSelector) ([]string, error) {
+	filter := bson.M{}
+	if tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+	if len(sel.Groups) > 0 {
+		filter["groups"] = bson.M{"$in": sel.Groups}
+	}
+	if len(sel.Tags) > 0 {
+		filter["tags"] = bson.M{"$in": sel.Tags}
+	}
+	if len(sel.Locations) > 0 {
+		filter["location_path"] = bson.M{"$in": sel.Locations}
+	}
+	return distinctDeviceIDs(ctx, filter)
+}
+
+// SetDeviceLocations updates the location display path of each device in the map, after
+// a location above them was renamed.
+func SetDeviceLocations(ctx context.Context, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	writes := make([]mongo.WriteModel, 0, len(labels))
+	for deviceID, label := range labels {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"device_id": deviceID}).
+			SetUpdate(bson.M{"$set": bson.M{"location": label}}))
+	}
+	_, err := DevicesCollection().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -2,15 +2,20 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/metrics"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+var logger = logging.For("db")
+
 var MongoClient *mongo.Client
 var DBName string
 
@@ -26,22 +31,38 @@ func InitDB() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOpts := options.Client().ApplyURI(uri)
+	clientOpts := options.Client().ApplyURI(uri).SetMonitor(metrics.MongoMonitor())
 
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
-		log.Fatalf("[MongoDB] Failed to connect: %v", err)
+		logging.Fatal(logger, "Failed to connect", "error", err)
 	}
 
 	// Ping the database
 	if err := client.Ping(ctx, nil); err != nil {
-		log.Fatalf("[MongoDB] Ping failed: %v", err)
+		logging.Fatal(logger, "Ping failed", "error", err)
 	}
 
 	MongoClient = client
-	log.Println("[MongoDB] Connected successfully.")
+	logger.Info("Connected", "host", mongoHost, "database", DBName)
 }
 
 func GetMongoClient() *mongo.Client {
 	return MongoClient
 }
+
+// Ping checks that the MongoDB primary is reachable.
+func Ping(ctx context.Context) error {
+	if MongoClient == nil {
+		return errors.New("not connected")
+	}
+	return MongoClient.Ping(ctx, readpref.Primary())
+}
+
+// Disconnect closes the MongoDB connection pool, waiting for in-use connections up to ctx's deadline.
+func Disconnect(ctx context.Context) error {
+	if MongoClient == nil {
+		return nil
+	}
+	return MongoClient.Disconnect(ctx)
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrKPIDefinitionNotFound is returned when no KPI definition matches the given ID.
+var ErrKPIDefinitionNotFound = errors.New("KPI definition not found")
+
+func kpiDefinitionsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_KPI_DEFINITIONS_COLLECTION", "kpi_definitions"))
+}
+
+// ListKPIDefinitions returns the stored KPI definitions sorted by name.
+func ListKPIDefinitions(ctx context.Context, enabledOnly bool) ([]models.KPIDefinition, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{}
+	if enabledOnly {
+		filter["enabled"] = true
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+
+	cursor, err := kpiDefinitionsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	defs := []models.KPIDefinition{}
+	if err := cursor.All(ctx, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// GetKPIDefinition returns the KPI definition with the given ID.
+func GetKPIDefinition(ctx context.Context, id primitive.ObjectID) (*models.KPIDefinition, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var def models.KPIDefinition
+	err := kpiDefinitionsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&def)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrKPIDefinitionNotFound
+		}
+		return nil, err
+	}
+	return &def, nil
+}
+
+// CreateKPIDefinition stores a new KPI definition and sets its ID.
+func CreateKPIDefinition(ctx context.Context, def *models.KPIDefinition) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kpiDefinitionsCollection().InsertOne(ctx, def)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		def.ID = id
+	}
+	return nil
+}
+
+// UpdateKPIDefinition replaces the stored KPI definition with the same ID.
+func UpdateKPIDefinition(ctx context.Context, def models.KPIDefinition) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kpiDefinitionsCollection().ReplaceOne(ctx, bson.M{"_id": def.ID}, def)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrKPIDefinitionNotFound
+	}
+	return nil
+}
+
+// DeleteKPIDefinition removes the KPI definition with the given ID.
+func DeleteKPIDefinition(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := kpiDefinitionsCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrKPIDefinitionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrLocationNotFound is returned when no location matches the given ID.
+var ErrLocationNotFound = errors.New("location not found")
+
+func locationsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_LOCATIONS_COLLECTION", "locations"))
+}
+
+// CreateLocation stores a new location and sets its ID.
+func CreateLocation(ctx context.Context, loc *models.Location) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := locationsCollection().InsertOne(ctx, loc)
+	if err != nil {
+		return err
+	}
+	loc.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetLocation returns the location with the given ID.
+func GetLocation(ctx context.Context, id primitive.ObjectID) (*models.Location, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var loc models.Location
+	err := locationsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&loc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrLocationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &loc, nil
+}
+
+// ListLocations returns the locations matching the filter, such as {"tenant_id": t}.
+func ListLocations(ctx context.Context, filter bson.M) ([]models.Location, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := locationsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	locations := []models.Location{}
+	if err := cursor.All(ctx, &locations); err != nil {
+		return nil, err
+	}
+	return locations, nil
+}
+
+// RenameLocation changes the name of a location.
+func RenameLocation(ctx context.Context, id primitive.ObjectID, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := locationsCollection().UpdateByID(ctx, id, bson.M{"$set": bson.M{"name": name}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrLocationNotFound
+	}
+	return nil
+}
+
+// LocationInUse reports whether a location has child locations or devices installed in it.
+func LocationInUse(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	children, err := locationsCollection().CountDocuments(ctx, bson.M{"parent_id": id}, options.Count().SetLimit(1))
+	if err != nil || children > 0 {
+		return children > 0, err
+	}
+	devices, err := DevicesCollection().CountDocuments(ctx, bson.M{"location_id": id}, options.Count().SetLimit(1))
+	return devices > 0, err
+}
+
+// DeleteLocation removes a location.
+func DeleteLocation(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := locationsCollection().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrLocationNotFound
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func loginAttemptsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_LOGIN_ATTEMPTS_COLLECTION", "login_attempts"))
+}
+
+// GetLoginAttempt returns the failed login state of a username, or nil if it has none.
+func GetLoginAttempt(ctx context.Context, username string) (*models.LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var attempt models.LoginAttempt
+	err := loginAttemptsCollection().FindOne(ctx, bson.M{"_id": username}).Decode(&attempt)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// RecordLoginFailure applies update, a pipeline from lockout.Policy.FailureUpdate, to
+// the failed login state of a username in one atomic operation and returns the state
+// afterwards.
+func RecordLoginFailure(ctx context.Context, username string, update bson.A) (*models.LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempt models.LoginAttempt
+	err := loginAttemptsCollection().FindOneAndUpdate(ctx, bson.M{"_id": username}, update, opts).Decode(&attempt)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+// DeleteLoginAttempt clears the failed login state and any lockout of a username.
+// It reports whether there was anything to clear.
+func DeleteLoginAttempt(ctx context.Context, username string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := loginAttemptsCollection().DeleteOne(ctx, bson.M{"_id": username})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
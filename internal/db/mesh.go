@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrMeshNodeNotFound is returned when a device has never reported its mesh position.
+var ErrMeshNodeNotFound = errors.New("mesh node not found")
+
+func meshNodesCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_MESH_NODES_COLLECTION", "mesh_nodes"))
+}
+
+// ListMeshNodes returns the mesh nodes matching the filter.
+func ListMeshNodes(ctx context.Context, filter bson.M) ([]models.MeshNode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := meshNodesCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	nodes := []models.MeshNode{}
+	if err := cursor.All(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// GetMeshNode returns the last reported mesh position of the device.
+func GetMeshNode(ctx context.Context, deviceID string) (*models.MeshNode, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var node models.MeshNode
+	err := meshNodesCollection().FindOne(ctx, bson.M{"device_id": deviceID}).Decode(&node)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrMeshNodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// ApplyMeshNode stores a new position of the node in the tree, provided it is still at
+// prev, or not stored at all when prev is nil. It reports false when another instance
+// recorded a change first, so that only one of them records the event.
+func ApplyMeshNode(ctx context.Context, node models.MeshNode, prev *models.MeshNode) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if prev == nil {
+		_, err := meshNodesCollection().InsertOne(ctx, node)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	set := bson.M{
+		"mesh_id":    node.MeshID,
+		"parent_id":  node.ParentID,
+		"layer":      node.Layer,
+		"rssi":       node.RSSI,
+		"last_seen":  node.LastSeen,
+		"changed_at": node.ChangedAt,
+	}
+	if node.TenantID != "" {
+		set["tenant_id"] = node.TenantID
+	}
+	res, err := meshNodesCollection().UpdateOne(ctx,
+		bson.M{"device_id": node.DeviceID, "mesh_id": prev.MeshID, "parent_id": prev.ParentID, "layer": prev.Layer},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// TouchMeshNode records the signal strength and time of the node's latest report.
+func TouchMeshNode(ctx context.Context, deviceID string, rssi int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := meshNodesCollection().UpdateOne(ctx,
+		bson.M{"device_id": deviceID, "last_seen": bson.M{"$lt": at}},
+		bson.M{"$set": bson.M{"rssi": rssi, "last_seen": at}},
+	)
+	return err
+}
+
+// SetMeshNodeOrphaned marks the node orphaned at the given time, or clears the mark when
+// at is nil. Marking reports false when the node was already marked.
+func SetMeshNodeOrphaned(ctx context.Context, deviceID string, at *time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"device_id": deviceID, "orphaned_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"orphaned_at": at}}
+	if at == nil {
+		filter["orphaned_at"] = bson.M{"$exists": true}
+		update = bson.M{"$unset": bson.M{"orphaned_at": ""}}
+	}
+	res, err := meshNodesCollection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// InsertMeshEvent appends an event to the topology history.
+func InsertMeshEvent(ctx context.Context, event models.MeshEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := MeshEventsCollection().InsertOne(ctx, event)
+	return err
+}
+
+// FindMeshEvents returns up to limit topology events matching filter, newest first.
+func FindMeshEvents(ctx context.Context, filter bson.M, limit int64) ([]models.MeshEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(limit)
+	cursor, err := MeshEventsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.MeshEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrOrganizationNotFound is returned when no organization matches the given ID.
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// ErrOrganizationExists is returned when creating an organization whose ID is taken.
+var ErrOrganizationExists = errors.New("organization already exists")
+
+func organizationsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_ORGANIZATIONS_COLLECTION", "organizations"))
+}
+
+// CreateOrganization stores a new organization.
+func CreateOrganization(ctx context.Context, org models.Organization) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := organizationsCollection().InsertOne(ctx, org)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrOrganizationExists
+	}
+	return err
+}
+
+// GetOrganization returns the organization with the given ID.
+func GetOrganization(ctx context.Context, id string) (*models.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var org models.Organization
+	err := organizationsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&org)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// ListOrganizations returns every organization sorted by ID.
+func ListOrganizations(ctx context.Context) ([]models.Organization, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := organizationsCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orgs := []models.Organization{}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// SetOrganizationTopicPrefixes replaces the MQTT topic prefixes of an organization.
+func SetOrganizationTopicPrefixes(ctx context.Context, id string, prefixes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := organizationsCollection().UpdateOne(ctx, bson.M{"_id": id},
+		bson.M{"$set": bson.M{"topic_prefixes": prefixes}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrOrganizationNotFound
+	}
+	return nil
+}
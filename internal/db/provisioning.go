@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrClaimNotFound is returned when no provisioning claim exists for a factory ID.
+	ErrClaimNotFound = errors.New("provisioning claim not found")
+	// ErrClaimExists is returned when the factory ID already has a claim, or the device ID
+	// a pending one.
+	ErrClaimExists = errors.New("provisioning claim already exists")
+	// ErrDeviceOwned is returned when provisioning a device that belongs to another tenant.
+	ErrDeviceOwned = errors.New("device belongs to another tenant")
+)
+
+func claimsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_PROVISIONING_CLAIMS_COLLECTION", "provisioning_claims"))
+}
+
+func mqttUsersCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_MQTT_USERS_COLLECTION", "mqtt_users"))
+}
+
+// CreateClaim stores a new pending provisioning claim and sets its ID.
+func CreateClaim(ctx context.Context, claim *models.ProvisioningClaim) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	claim.Pending = true
+	res, err := claimsCollection().InsertOne(ctx, claim)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrClaimExists
+	}
+	if err != nil {
+		return err
+	}
+	claim.ID = res.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// GetClaim returns the provisioning claim of the factory ID.
+func GetClaim(ctx context.Context, factoryID string) (*models.ProvisioningClaim, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var claim models.ProvisioningClaim
+	err := claimsCollection().FindOne(ctx, bson.M{"factory_id": factoryID}).Decode(&claim)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrClaimNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// ListClaims returns the provisioning claims matching the filter, newest first.
+func ListClaims(ctx context.Context, filter bson.M) ([]models.ProvisioningClaim, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cursor, err := claimsCollection().Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	claims := []models.ProvisioningClaim{}
+	if err := cursor.All(ctx, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// DeleteClaim removes the provisioning claim of the factory ID, so a new one can be
+// created, e.g. to provision a device again after a factory reset.
+func DeleteClaim(ctx context.Context, factoryID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := claimsCollection().DeleteOne(ctx, bson.M{"factory_id": factoryID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrClaimNotFound
+	}
+	return nil
+}
+
+// RecordFailedClaim counts a wrong claim code presented for the claim.
+func RecordFailedClaim(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := claimsCollection().UpdateByID(ctx, id, bson.M{"$inc": bson.M{"failed_attempts": 1}})
+	return err
+}
+
+// RedeemClaim marks the claim used at the given time. It reports false when the claim
+// was already used, so of two devices presenting the same code only one joins.
+func RedeemClaim(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := claimsCollection().UpdateOne(ctx,
+		bson.M{"_id": id, "claimed_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"claimed_at": at}, "$unset": bson.M{"pending": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// ReleaseClaim makes a redeemed claim usable again, when provisioning failed after it
+// was redeemed.
+func ReleaseClaim(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := claimsCollection().UpdateByID(ctx, id,
+		bson.M{"$set": bson.M{"pending": true}, "$unset": bson.M{"claimed_at": ""}})
+	return err
+}
+
+// ProvisionDevice registers a provisioned device, or updates the entry of a device that
+// is provisioned again, with its tenant, factory ID, sealed key and, when set, its groups
+// and location. Name and tags are kept. A device of another tenant is left untouched and
+// ErrDeviceOwned returned.
+func ProvisionDevice(ctx context.Context, device models.Device) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	set := bson.M{
+		"factory_id":     device.FactoryID,
+		"encryption_key": device.EncryptionKey,
+		"provisioned_at": device.ProvisionedAt,
+		"updated_at":     device.ProvisionedAt,
+	}
+	if device.TenantID != "" {
+		set["tenant_id"] = device.TenantID
+	}
+	if len(device.Groups) > 0 {
+		set["groups"] = device.Groups
+	}
+	if device.LocationID != nil {
+		set["location_id"] = device.LocationID
+		set["location_path"] = device.LocationPath
+		set["location"] = device.Location
+	}
+	// Device IDs are unique, so when the device exists under another tenant the upsert
+	// fails to insert a second entry instead of taking it over
+	filter := bson.M{"device_id": device.DeviceID, "tenant_id": bson.M{"$in": bson.A{device.TenantID, nil}}}
+	_, err := DevicesCollection().UpdateOne(ctx, filter, bson.M{"$set": set}, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDeviceOwned
+	}
+	return err
+}
+
+// SaveMQTTCredential creates or replaces the broker account of the credential's username.
+// An account of another tenant is left untouched and ErrDeviceOwned returned.
+func SaveMQTTCredential(ctx context.Context, cred models.MQTTCredential) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"username": cred.Username, "tenant_id": bson.M{"$in": bson.A{cred.TenantID, nil}}}
+	_, err := mqttUsersCollection().ReplaceOne(ctx, filter, cred, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDeviceOwned
+	}
+	return err
+}
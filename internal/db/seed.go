@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"log"
 	"os"
 	"time"
 
@@ -15,43 +14,44 @@ func SeedAdminUser() {
 	username := os.Getenv("ADMIN_USERNAME")
 	password := os.Getenv("ADMIN_PASSWORD")
 	if username == "" || password == "" {
-		log.Println("[SEED] ADMIN_USERNAME or ADMIN_PASSWORD not set.")
+		logger.Warn("ADMIN_USERNAME or ADMIN_PASSWORD not set, skipping admin seed")
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	users := MongoClient.Database(DBName).Collection("users")
+	users := usersCollection()
 
 	count, err := users.CountDocuments(ctx, bson.M{"username": username})
 	if err != nil {
-		log.Printf("[SEED] Failed to count users: %v", err)
+		logger.Error("Failed to count users", "error", err)
 		return
 	}
 
 	if count > 0 {
-		log.Println("[SEED] Admin user already exists. Skipping seed.")
+		logger.Info("Admin user already exists, skipping seed")
 		return
 	}
 
 	hashedPassword, err := utils.HashPassword(password)
 	if err != nil {
-		log.Printf("[SEED] Failed to hash password: %v", err)
+		logger.Error("Failed to hash admin password", "error", err)
 		return
 	}
 
 	admin := models.User{
-		Username: username,
-		Password: hashedPassword,
-		Role:     "admin",
+		Username:  username,
+		Password:  hashedPassword,
+		Role:      "admin",
+		CreatedAt: time.Now().UTC(),
 	}
 
 	_, err = users.InsertOne(ctx, admin)
 	if err != nil {
-		log.Printf("[SEED] Failed to insert admin user: %v", err)
+		logger.Error("Failed to insert admin user", "error", err)
 		return
 	}
 
-	log.Println("[SEED] Admin user created successfully.")
+	logger.Info("Admin user created", "username", username)
 }
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrSessionNotFound is returned when no session matches.
+var ErrSessionNotFound = errors.New("session not found")
+
+func sessionsCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_SESSIONS_COLLECTION", "sessions"))
+}
+
+// CreateSession stores a new session.
+func CreateSession(ctx context.Context, session models.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := sessionsCollection().InsertOne(ctx, session)
+	return err
+}
+
+// GetSession returns the session with the given ID.
+func GetSession(ctx context.Context, id string) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var session models.Session
+	if err := sessionsCollection().FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ListActiveSessions returns the user's sessions that are neither revoked nor expired,
+// newest first.
+func ListActiveSessions(ctx context.Context, username string, now time.Time) ([]models.Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"username":   username,
+		"revoked_at": bson.M{"$exists": false},
+		"expires_at": bson.M{"$gt": now},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := sessionsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []models.Session{}
+	if err := cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the user's sessions. Revoking an already revoked session
+// keeps the original revocation time.
+func RevokeSession(ctx context.Context, username, id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := sessionsCollection().UpdateOne(ctx,
+		bson.M{"_id": id, "username": username},
+		bson.A{bson.M{"$set": bson.M{"revoked_at": bson.M{"$ifNull": bson.A{"$revoked_at", at}}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every active session of the user except keep, and returns
+// how many were revoked.
+func RevokeOtherSessions(ctx context.Context, username, keep string, at time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := sessionsCollection().UpdateMany(ctx,
+		bson.M{"username": username, "_id": bson.M{"$ne": keep}, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": at}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// TouchSession records that the session was used at the given time.
+func TouchSession(ctx context.Context, id string, at time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := sessionsCollection().UpdateByID(ctx, id, bson.M{"$set": bson.M{"last_seen_at": at}})
+	return err
+}
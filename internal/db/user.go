@@ -3,42 +3,38 @@ package db
 import (
 	"context"
 	"errors"
-	"log"
-	"os"
 	"time"
 
 	"github.com/rednexx46/esp32-backend-api/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrUserNotFound is returned when no user matches the given username.
+var ErrUserNotFound = errors.New("user not found")
+
+func usersCollection() *mongo.Collection {
+	return MongoClient.Database(DBName).Collection(CollectionName("MONGO_USERS_COLLECTION", "users"))
+}
+
 func CreateUser(user models.User) error {
-	collection := GetMongoClient().
-		Database(os.Getenv("MONGO_DATABASE")).
-		Collection(os.Getenv("MONGO_USERS_COLLECTION"))
+	collection := usersCollection()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	_, err := collection.InsertOne(ctx, user)
 	if err != nil {
-		log.Printf("[MongoDB] Failed to insert user: %v", err)
+		logger.Error("Failed to insert user", "username", user.Username, "error", err)
 		return err
 	}
-	log.Println("[MongoDB] User created successfully.")
+	logger.Info("User created", "username", user.Username)
 	return nil
 }
 
 func FindUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	DB_NAME := os.Getenv("MONGO_DATABASE")
-	if DB_NAME == "" {
-		return nil, errors.New("MONGO_DATABASE environment variable is not set")
-	}
-	USERS_COLLECTION := os.Getenv("MONGO_USERS_COLLECTION")
-	if USERS_COLLECTION == "" {
-		return nil, errors.New("MONGO_USERS_COLLECTION environment variable is not set")
-	}
-	collection := MongoClient.Database(DB_NAME).Collection(USERS_COLLECTION)
+	collection := usersCollection()
 
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -47,10 +43,206 @@ func FindUserByUsername(ctx context.Context, username string) (*models.User, err
 	err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+// ListUsers returns every user sorted by username.
+func ListUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "username", Value: 1}})
+	cursor, err := usersCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []models.User{}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateUserPassword replaces the password hash of the given user.
+func UpdateUserPassword(ctx context.Context, username, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx,
+		bson.M{"username": username},
+		bson.M{"$set": bson.M{"password": hashedPassword}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserDeviceGroups replaces the device groups of the given user.
+func SetUserDeviceGroups(ctx context.Context, username string, groups []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx,
+		bson.M{"username": username},
+		bson.M{"$set": bson.M{"device_groups": groups}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// FindUserByExternalID returns the user provisioned for the given OIDC issuer and subject.
+func FindUserByExternalID(ctx context.Context, issuer, subject string) (*models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var user models.User
+	filter := bson.M{"external": true, "external_issuer": issuer, "external_subject": subject}
+	if err := usersCollection().FindOne(ctx, filter).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateExternalUser refreshes the role, tenant and email of a provisioned user from the IdP.
+func UpdateExternalUser(ctx context.Context, username, role, tenantID, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"role": role, "email": email, "tenant_id": tenantID}}
+	if tenantID == "" {
+		update = bson.M{"$set": bson.M{"role": role, "email": email}, "$unset": bson.M{"tenant_id": ""}}
+	}
+	res, err := usersCollection().UpdateOne(ctx, bson.M{"username": username, "external": true}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetTOTPPending stores a TOTP secret awaiting confirmation, replacing any earlier one.
+func SetTOTPPending(ctx context.Context, username, secret string) error {
+	return updateUser(ctx, username, bson.M{"$set": bson.M{"totp_pending_secret": secret}})
+}
+
+// EnableTOTP activates the pending secret once a code for it has been verified at step.
+// It fails with ErrUserNotFound if the pending secret changed in the meantime.
+func EnableTOTP(ctx context.Context, username, secret string, step int64, recoveryHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx,
+		bson.M{"username": username, "totp_pending_secret": secret},
+		bson.M{
+			"$set": bson.M{
+				"totp_enabled":   true,
+				"totp_secret":    secret,
+				"totp_last_step": step,
+				"recovery_codes": recoveryHashes,
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DisableTOTP removes the user's second factor and recovery codes.
+func DisableTOTP(ctx context.Context, username string) error {
+	return updateUser(ctx, username, bson.M{"$unset": bson.M{
+		"totp_enabled":        "",
+		"totp_secret":         "",
+		"totp_pending_secret": "",
+		"totp_last_step":      "",
+		"recovery_codes":      "",
+	}})
+}
+
+// SetRecoveryCodes replaces the user's recovery code hashes.
+func SetRecoveryCodes(ctx context.Context, username string, hashes []string) error {
+	return updateUser(ctx, username, bson.M{"$set": bson.M{"recovery_codes": hashes}})
+}
+
+// UseTOTPStep records that a code for step was accepted. It returns false if a code for
+// that step or a later one was already used, so each code works only once.
+func UseTOTPStep(ctx context.Context, username string, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx,
+		bson.M{"username": username, "totp_enabled": true, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+// UseRecoveryCode removes a recovery code hash. It returns false if the code was already
+// used, e.g. by a concurrent login.
+func UseRecoveryCode(ctx context.Context, username, hash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx,
+		bson.M{"username": username, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func updateUser(ctx context.Context, username string, update bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := usersCollection().UpdateOne(ctx, bson.M{"username": username}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserTenant moves the user to the tenant, or makes them a platform user when
+// tenantID is empty.
+func SetUserTenant(ctx context.Context, username, tenantID string) error {
+	if tenantID == "" {
+		return updateUser(ctx, username, bson.M{"$unset": bson.M{"tenant_id": ""}})
+	}
+	return updateUser(ctx, username, bson.M{"$set": bson.M{"tenant_id": tenantID}})
+}
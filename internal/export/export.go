@@ -0,0 +1,188 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Supported export formats.
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// CSV columns of each exportable collection. Readings have no fixed schema beyond these
+// fields; their measurements are in payload. NDJSON exports keep every field.
+var (
+	SensorColumns = []string{"_id", "device_id", "tenant_id", "message_id", "timestamp", "payload"}
+	KPIColumns    = []string{"_id", "device_id", "tenant_id", "name", "value", "unit", "samples",
+		"window_start", "window_end", "timestamp", "computed_at"}
+)
+
+// ErrInvalidFormat is returned for formats other than csv and ndjson.
+var ErrInvalidFormat = errors.New("invalid format, expected csv or ndjson")
+
+// ContentType returns the MIME type of the format.
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// Filter builds the Mongo filter for an optional device ID and optional RFC3339 bounds.
+func Filter(deviceID, from, to string) (bson.M, error) {
+	filter := bson.M{}
+	if deviceID != "" {
+		filter["device_id"] = deviceID
+	}
+
+	timeRange := bson.M{}
+	if from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return nil, errors.New("invalid from timestamp, expected RFC3339")
+		}
+		timeRange["$gte"] = t
+	}
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return nil, errors.New("invalid to timestamp, expected RFC3339")
+		}
+		timeRange["$lte"] = t
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+	return filter, nil
+}
+
+// Find opens a cursor over the documents matching filter, oldest first.
+func Find(ctx context.Context, collection *mongo.Collection, filter bson.M) (*mongo.Cursor, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+	return collection.Find(ctx, filter, opts)
+}
+
+// Write streams every document from the cursor to w in the given format, decrypting
+// payloads when decrypt is set. CSV exports have the given columns. When w is an
+// http.Flusher it is flushed after every document so clients receive data as soon as
+// it is read.
+func Write(ctx context.Context, w io.Writer, cursor *mongo.Cursor, format string, columns []string, decrypt bool) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(ctx, w, cursor, columns, decrypt)
+	case FormatNDJSON:
+		return writeNDJSON(ctx, w, cursor, decrypt)
+	}
+	return ErrInvalidFormat
+}
+
+func writeNDJSON(ctx context.Context, w io.Writer, cursor *mongo.Cursor, decrypt bool) error {
+	enc := json.NewEncoder(w)
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		prepare(doc, decrypt)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		flush(w)
+	}
+	return cursor.Err()
+}
+
+// writeCSV writes the header and one row per document. Fields missing from a document
+// are left empty and fields outside the columns are dropped, so every row lines up with
+// the header whatever the documents contain.
+func writeCSV(ctx context.Context, w io.Writer, cursor *mongo.Cursor, columns []string, decrypt bool) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	cw.Flush()
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		prepare(doc, decrypt)
+
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = csvValue(doc[col])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+		cw.Flush()
+		flush(w)
+	}
+	return cursor.Err()
+}
+
+func flush(w io.Writer) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func prepare(doc bson.M, decrypt bool) {
+	if !decrypt {
+		return
+	}
+	if payload, ok := doc["payload"].(string); ok {
+		doc["payload"] = utils.DecryptPayloadIfNeeded(payload)
+	}
+}
+
+func csvValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return SafeCell(val)
+	case primitive.ObjectID:
+		return val.Hex()
+	case primitive.DateTime:
+		return val.Time().UTC().Format(time.RFC3339)
+	case time.Time:
+		return val.UTC().Format(time.RFC3339)
+	case bson.M, bson.A, bson.D, map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// SafeCell stops spreadsheets from running a CSV cell as a formula by prefixing cells
+// that start like one with "'". Payloads and IDs come from devices, so a reading such as
+// "=HYPERLINK(...)" must stay text. Numbers are formatted by csvValue and never pass
+// through here, so negative values stay numeric.
+func SafeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
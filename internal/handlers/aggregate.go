@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/retention"
+)
+
+// GetAggregatedSensorData godoc
+// @Summary      Get aggregated sensor data
+// @Description  Returns per-bucket count, sum, min, max and mean of every numeric metric reported by a device. The resolution defaults to the finest one still retained for the range: minute (computed from raw data), hour or day.
+// @Tags         sensors
+// @Produce      json
+// @Security     BearerAuth
+// @Param        device_id   query    string  true   "Device ID"
+// @Param        from        query    string  false  "Start of the time range (RFC3339, default: 24 hours ago)"
+// @Param        to          query    string  false  "End of the time range (RFC3339, default: now)"
+// @Param        resolution  query    string  false  "minute, hour or day"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/data/aggregate [get]
+func GetAggregatedSensorData(c *gin.Context) {
+	deviceID := c.Query("device_id")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id is required"})
+		return
+	}
+	if !deviceScope(c).Allows(deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from timestamp, expected RFC3339"})
+			return
+		}
+		from = t.UTC()
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to timestamp, expected RFC3339"})
+			return
+		}
+		to = t.UTC()
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	resolution := c.Query("resolution")
+	switch resolution {
+	case "", models.ResolutionMinute, models.ResolutionHour, models.ResolutionDay:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resolution, expected minute, hour or day"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resolution, rollups, err := retention.Query(ctx, retention.LoadConfig(), deviceID, from, to, resolution)
+	if err != nil {
+		if errors.Is(err, retention.ErrResolutionUnavailable) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Resolution " + resolution + " is no longer retained for this range"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate data"})
+		return
+	}
+	if rollups == nil {
+		rollups = []models.Rollup{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"device_id":  deviceID,
+		"resolution": resolution,
+		"from":       from,
+		"to":         to,
+		"buckets":    rollups,
+	})
+}
@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateServiceAccount godoc
+// @Summary      Create a service account
+// @Description  Creates a user without a password that authenticates with API keys only. The role may not grant permissions the caller lacks, and callers without devices:all can only assign their own device groups.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        account  body      models.CreateServiceAccountRequest  true  "Service account"
+// @Success      201  {object}  models.User
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/service-accounts [post]
+func CreateServiceAccount(c *gin.Context) {
+	var req models.CreateServiceAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !rbac.IsRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role " + req.Role})
+		return
+	}
+
+	// Callers can only hand out permissions and devices they have themselves
+	for _, perm := range rbac.Permissions(req.Role) {
+		if !callerCan(c, perm) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Role " + req.Role + " grants " + string(perm) + ", which you do not have"})
+			return
+		}
+	}
+	if !requireOwnGroups(c, req.DeviceGroups) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := callerTenant(c)
+	if tenantID == "" && req.TenantID != "" {
+		if _, err := db.GetOrganization(ctx, req.TenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tenant " + req.TenantID})
+			return
+		}
+		tenantID = req.TenantID
+	}
+	if _, err := db.FindUserByUsername(ctx, req.Username); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "A user with this name already exists"})
+		return
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+
+	user := models.User{
+		Username:       req.Username,
+		Role:           req.Role,
+		CreatedAt:      time.Now().UTC(),
+		TenantID:       tenantID,
+		DeviceGroups:   req.DeviceGroups,
+		ServiceAccount: true,
+	}
+	if err := db.CreateUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.ServiceAccountCreate, TargetType: "user",
+		TargetID: user.Username, Changes: audit.Diff(nil, user)})
+	c.JSON(http.StatusCreated, user)
+}
+
+// requireOwnGroups answers 403 unless the caller may hand out the device groups:
+// callers with devices:all or the "*" group may hand out any, others only their own.
+func requireOwnGroups(c *gin.Context, groups []string) bool {
+	if callerCan(c, rbac.DevicesAll) {
+		return true
+	}
+	// Callers without a users entry, such as service tokens, have no device groups
+	var own []string
+	if caller, err := db.FindUserByUsername(c.Request.Context(), c.GetString("username")); err == nil {
+		own = caller.DeviceGroups
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return false
+	}
+	if slices.Contains(own, rbac.AllGroups) {
+		return true
+	}
+	for _, group := range groups {
+		if !slices.Contains(own, group) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Device group " + group + " is not one of yours"})
+			return false
+		}
+	}
+	return true
+}
+
+// callerCan reports whether the caller's role grants the permission and, for API keys,
+// whether the key's scopes include it, as middleware.RequirePermission checks.
+func callerCan(c *gin.Context, perm rbac.Permission) bool {
+	if !rbac.Has(c.GetString("role"), perm) {
+		return false
+	}
+	if scopes, ok := c.Get("scopes"); ok {
+		return slices.Contains(scopes.([]string), string(perm))
+	}
+	return true
+}
+
+// CreateAPIKey godoc
+// @Summary      Create an API key
+// @Description  Issues an API key for a service account. Scopes must be permissions of both the account's role and the caller, and callers without devices:all can only issue keys for accounts within their own device groups. The key is returned only in this response.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        key  body      models.CreateAPIKeyRequest  true  "API key"
+// @Success      201  {object}  models.CreateAPIKeyResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/api-keys [post]
+func CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must not be negative"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	owner, err := db.FindUserByUsername(ctx, req.ServiceAccount)
+	if err != nil || !owner.ServiceAccount || !sameTenant(c, owner.TenantID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "API keys can only be issued to existing service accounts"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !rbac.IsPermission(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+		if !rbac.Has(owner.Role, rbac.Permission(scope)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Role " + owner.Role + " does not grant " + scope})
+			return
+		}
+		if !callerCan(c, rbac.Permission(scope)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have " + scope})
+			return
+		}
+	}
+	// The key acts on the account's device groups, so the caller must hold them too
+	if !requireOwnGroups(c, owner.DeviceGroups) {
+		return
+	}
+
+	key, keyID, hash := utils.GenerateAPIKey()
+	now := time.Now().UTC()
+	stored := models.APIKey{
+		Name:           req.Name,
+		KeyID:          keyID,
+		Hash:           hash,
+		ServiceAccount: owner.Username,
+		TenantID:       owner.TenantID,
+		Scopes:         req.Scopes,
+		CreatedBy:      c.GetString("username"),
+		CreatedAt:      now,
+	}
+	if req.ExpiresInDays > 0 {
+		expires := now.AddDate(0, 0, req.ExpiresInDays)
+		stored.ExpiresAt = &expires
+	}
+
+	if err := db.CreateAPIKey(ctx, &stored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+	logger.InfoContext(ctx, "API key created", "key_id", keyID, "service_account", owner.Username, "by", stored.CreatedBy)
+	recordAudit(c, models.AuditEvent{Action: audit.APIKeyCreate, TargetType: "api_key",
+		TargetID: stored.ID.Hex(), Changes: audit.Diff(nil, stored)})
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{APIKey: stored, Key: key})
+}
+
+// ListAPIKeys godoc
+// @Summary      List API keys
+// @Description  Lists API keys without their secrets, optionally for one service account. Tenant users only see their tenant's keys.
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        service_account  query  string  false  "Service account"
+// @Success      200  {array}   models.APIKey
+// @Failure      500  {object}  map[string]string
+// @Router       /api/api-keys [get]
+func ListAPIKeys(c *gin.Context) {
+	keys, err := db.ListAPIKeys(c.Request.Context(), c.Query("service_account"), callerTenant(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke an API key
+// @Description  Revokes an API key immediately. The record is kept for auditing.
+// @Tags         api-keys
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id  path  string  true  "API key ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/api-keys/{id} [delete]
+func RevokeAPIKey(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := db.RevokeAPIKey(ctx, id, callerTenant(c), time.Now().UTC()); err != nil {
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	logger.InfoContext(ctx, "API key revoked", "id", id.Hex(), "by", c.GetString("username"))
+	recordAudit(c, models.AuditEvent{Action: audit.APIKeyRevoke, TargetType: "api_key", TargetID: id.Hex()})
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
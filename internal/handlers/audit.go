@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+// recordAudit fills in who made the request and from where, then records the event.
+// The actor is the authenticated user unless the event already names one, as failed
+// logins do.
+func recordAudit(c *gin.Context, event models.AuditEvent) {
+	if event.Actor == "" {
+		event.Actor = c.GetString("username")
+	}
+	if event.ActorType == "" {
+		event.ActorType = audit.ActorUser
+		if keyID := c.GetString("api_key_id"); keyID != "" {
+			event.ActorType = audit.ActorAPIKey
+			event.APIKeyID = keyID
+		}
+	}
+	if event.TenantID == "" {
+		event.TenantID = c.GetString(middleware.TenantKey)
+	}
+	event.ClientIP = c.ClientIP()
+	audit.Record(c.Request.Context(), event)
+}
+
+// auditQuery reads the audit filters from the query string. Tenant callers only see
+// their own tenant's events; platform users may pick a tenant with tenant_id.
+func auditQuery(c *gin.Context) (audit.Query, error) {
+	q := audit.Query{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		Outcome:    c.Query("outcome"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		TenantID:   c.Query("tenant_id"),
+	}
+	if tenantID := c.GetString(middleware.TenantKey); tenantID != "" {
+		q.TenantID = tenantID
+	}
+	var err error
+	if from := c.Query("from"); from != "" {
+		if q.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return q, fmt.Errorf("invalid from timestamp, expected RFC3339")
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if q.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return q, fmt.Errorf("invalid to timestamp, expected RFC3339")
+		}
+	}
+	return q, nil
+}
+
+// ListAuditEvents godoc
+// @Summary      Query the audit log
+// @Description  Returns audit events, newest first. An action ending in ".*" matches every action with that prefix.
+// @Tags         audit
+// @Produce      json
+// @Security     BearerAuth
+// @Param        actor        query  string  false  "Username"
+// @Param        action       query  string  false  "Action, e.g. auth.login or auth.*"
+// @Param        outcome      query  string  false  "success or failure"
+// @Param        target_type  query  string  false  "Target type, e.g. user"
+// @Param        target_id    query  string  false  "Target ID"
+// @Param        tenant_id    query  string  false  "Tenant, for platform users"
+// @Param        from         query  string  false  "Start of the time range (RFC3339)"
+// @Param        to           query  string  false  "End of the time range (RFC3339)"
+// @Param        limit        query  int     false  "Maximum events (default 100, max 1000)"
+// @Param        skip         query  int     false  "Events to skip, for paging"
+// @Success      200  {array}   models.AuditEvent
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/audit [get]
+func ListAuditEvents(c *gin.Context) {
+	q, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	skip, err := strconv.ParseInt(c.DefaultQuery("skip", "0"), 10, 64)
+	if err != nil || skip < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "skip must not be negative"})
+		return
+	}
+
+	events, err := db.FindAuditEvents(c.Request.Context(), q.Filter(), limit, skip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// ExportAuditEvents godoc
+// @Summary      Export the audit log
+// @Description  Streams the audit events matching the same filters as /api/audit as CSV, oldest first.
+// @Tags         audit
+// @Produce      text/csv
+// @Security     BearerAuth
+// @Param        actor        query  string  false  "Username"
+// @Param        action       query  string  false  "Action, e.g. auth.login or auth.*"
+// @Param        outcome      query  string  false  "success or failure"
+// @Param        target_type  query  string  false  "Target type"
+// @Param        target_id    query  string  false  "Target ID"
+// @Param        tenant_id    query  string  false  "Tenant, for platform users"
+// @Param        from         query  string  false  "Start of the time range (RFC3339)"
+// @Param        to           query  string  false  "End of the time range (RFC3339)"
+// @Success      200  {string}  string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/audit/export [get]
+func ExportAuditEvents(c *gin.Context) {
+	q, err := auditQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	cursor, err := db.AuditCursor(ctx, q.Filter())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit log"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	recordAudit(c, models.AuditEvent{Action: audit.AuditExport, Details: queryDetails(c)})
+
+	filename := fmt.Sprintf("audit_%s.csv", time.Now().UTC().Format("20060102T150405Z"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only cut the stream short.
+	if err := audit.WriteCSV(ctx, c.Writer, cursor); err != nil {
+		logger.WarnContext(ctx, "Audit export interrupted", "error", err)
+	}
+}
+
+// queryDetails returns the request's query parameters for the details of an export event.
+func queryDetails(c *gin.Context) map[string]any {
+	details := map[string]any{}
+	for key, values := range c.Request.URL.Query() {
+		if len(values) > 0 {
+			details[key] = values[0]
+		}
+	}
+	return details
+}
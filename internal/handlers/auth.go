@@ -1,21 +1,18 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
 	"github.com/rednexx46/esp32-backend-api/internal/db"
 	"github.com/rednexx46/esp32-backend-api/internal/models"
 	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/rednexx46/esp32-backend-api/internal/ws"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
 // GetProfile godoc
 // @Summary      Get authenticated user's profile
 // @Description  Retrieves the profile information of the currently authenticated user.
@@ -24,20 +21,110 @@ var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
 // @Produce      json
 // @Success      200  {object}  map[string]interface{}  "User profile"
 // @Failure      401  {object}  map[string]string       "Unauthorized"
+// @Failure      404  {object}  map[string]string       "User not found"
 // @Router       /auth/profile [get]
 func GetProfile(c *gin.Context) {
-	user, exists := c.Get("user")
-	if !exists {
+	username := c.GetString("username")
+	if username == "" {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
+	user, err := db.FindUserByUsername(c.Request.Context(), username)
+	if errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load profile"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
+// ChangePassword godoc
+// @Summary      Change your password
+// @Description  Replaces the caller's password after checking the current one and the password policy, and revokes the caller's other sessions.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        body  body      models.ChangePasswordRequest  true  "Current and new password"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  map[string]string  "Password policy not met"
+// @Failure      401   {object}  map[string]string  "Current password is wrong"
+// @Failure      429   {object}  map[string]string  "Rate limited or account locked"
+// @Router       /api/profile/password [post]
+func ChangePassword(c *gin.Context) {
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	user, ok := currentLocalUser(c)
+	if !ok {
+		return
+	}
+
+	// Guessing the current password with a stolen token counts like a failed login
+	if !allowLoginAttempt(c, user.Username) {
+		return
+	}
+	ctx := c.Request.Context()
+	attempt, err := db.GetLoginAttempt(ctx, loginKey(user.Username))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if !checkLockout(c, attempt) {
+		return
+	}
+	if !utils.ComparePassword(user.Password, req.CurrentPassword) {
+		recordLoginFailure(c, user.Username)
+		recordAudit(c, models.AuditEvent{Action: audit.UserPasswordChange, Outcome: audit.Failure, TargetType: "user",
+			TargetID: user.Username, Details: map[string]any{"reason": "wrong_current_password"}})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is wrong"})
+		return
+	}
+
+	if err := utils.LoadPasswordPolicy().Check(user.Username, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "New password must differ from the current one"})
+		return
+	}
+
+	hashed, err := utils.HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	if err := db.UpdateUserPassword(ctx, user.Username, hashed); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	revoked, err := db.RevokeOtherSessions(ctx, user.Username, c.GetString("session_id"), time.Now().UTC())
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to revoke sessions after password change", "username", user.Username, "error", err)
+	}
+	ws.CloseUserSessions(user.Username, c.GetString("session_id"))
+	logger.InfoContext(ctx, "Password changed", "username", user.Username, "revoked_sessions", revoked)
+	recordAudit(c, models.AuditEvent{Action: audit.UserPasswordChange, TargetType: "user", TargetID: user.Username,
+		Details: map[string]any{"revoked_sessions": revoked}})
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed", "revoked_sessions": revoked})
+}
+
+// dummyPasswordHash is a bcrypt hash, at the cost HashPassword uses, of a password
+// nobody has.
+const dummyPasswordHash = "$2a$10$0YTBOiw/KwHm/9OfK9yfseTYdmBUUj7AvzNm4sl8AEYFXuXcfMly2"
+
 // LoginHandler godoc
 // @Summary      Login user
-// @Description  Authenticates a user and returns a JWT token.
+// @Description  Authenticates a user and returns a JWT token. Users with two-factor authentication get mfa_required and a challenge token to complete at /api/login/mfa.
 // @Tags         auth
 // @Accept       json
 // @Produce      json
@@ -45,6 +132,7 @@ func GetProfile(c *gin.Context) {
 // @Success      200    {object}  models.LoginResponse "JWT token"
 // @Failure      400    {object}  map[string]string    "Invalid request body"
 // @Failure      401    {object}  map[string]string    "Invalid username or password"
+// @Failure      429    {object}  map[string]string    "Rate limited or account locked"
 // @Failure      500    {object}  map[string]string    "Failed to generate token"
 // @Router       /auth/login [post]
 func LoginHandler(c *gin.Context) {
@@ -54,33 +142,60 @@ func LoginHandler(c *gin.Context) {
 		return
 	}
 
-	ctx := context.Background()
-	user, err := db.FindUserByUsername(ctx, login.Username)
+	if !allowLoginAttempt(c, login.Username) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	attempt, err := db.GetLoginAttempt(ctx, loginKey(login.Username))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if !checkLockout(c, attempt) {
 		return
 	}
 
-	if !utils.ComparePassword(login.Password, user.Password) {
+	user, err := db.FindUserByUsername(ctx, login.Username)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return
+	}
+	// Usernames that cannot log in with a password are checked against a dummy hash, so
+	// the response time does not reveal which usernames exist
+	hasPassword := err == nil && !user.ServiceAccount && !user.External
+	hash := dummyPasswordHash
+	if hasPassword {
+		hash = user.Password
+	}
+	if !utils.ComparePassword(hash, login.Password) || !hasPassword {
+		recordLoginFailure(c, login.Username)
+		recordAudit(c, models.AuditEvent{Action: audit.Login, Outcome: audit.Failure, Actor: login.Username,
+			Details: map[string]any{"reason": "invalid_credentials"}})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
 		return
 	}
 
-	TOKEN_TTL_STR := os.Getenv("TOKEN_TTL_MINUTES")
-	TOKEN_TTL := 30 // Default to 30 minutes if not set
-	if TOKEN_TTL_STR != "" {
-		if ttl, err := strconv.Atoi(TOKEN_TTL_STR); err == nil {
-			TOKEN_TTL = ttl
+	// Failures stay counted until the second factor succeeds too, so knowing the
+	// password does not reset the lockout for guessing codes
+	if user.TOTPEnabled {
+		challenge, err := utils.GenerateMFAChallenge(user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
 		}
+		recordAudit(c, models.AuditEvent{Action: audit.Login, Actor: user.Username, Details: map[string]any{"mfa_required": true}})
+		c.JSON(http.StatusOK, models.LoginResponse{MFARequired: true, ChallengeToken: challenge})
+		return
 	}
-	expiration := time.Now().Add(time.Minute * time.Duration(TOKEN_TTL))
-	claims := jwt.MapClaims{
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      expiration.Unix(),
+
+	if attempt != nil {
+		if _, err := db.DeleteLoginAttempt(ctx, loginKey(login.Username)); err != nil {
+			logger.ErrorContext(ctx, "Failed to reset failed logins", "username", login.Username, "error", err)
+		}
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtSecret)
+
+	tokenString, err := issueSession(c, user, "password")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -95,13 +210,26 @@ func LoginHandler(c *gin.Context) {
 // LogoutHandler handles user logout by revoking the authentication token.
 //
 // @Summary      Logout user
-// @Description  Logs out the currently authenticated user by revoking their token.
+// @Description  Logs out the currently authenticated user by revoking their session.
 // @Tags         auth
 // @Produce      json
+// @Security     BearerAuth
 // @Success      200  {object}  map[string]string  "Logged out successfully"
+// @Failure      400  {object}  map[string]string  "Token has no session"
 // @Failure      401  {object}  map[string]string  "Unauthorized"
 // @Router       /auth/logout [post]
 func LogoutHandler(c *gin.Context) {
-	// TODO: implement token revocation
+	sessionID := c.GetString("session_id")
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This token has no session to revoke"})
+		return
+	}
+	err := db.RevokeSession(c.Request.Context(), c.GetString("username"), sessionID, time.Now().UTC())
+	if err != nil && !errors.Is(err, db.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	ws.CloseSession(sessionID)
+	recordAudit(c, models.AuditEvent{Action: audit.Logout, TargetType: "session", TargetID: sessionID})
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+)
+
+var logger = logging.For("api")
+
+// InitCollections binds the handler collections to the configured MongoDB database.
+// It must be called after db.InitDB.
+func InitCollections() {
+	sensorsCollection = db.SensorsCollection()
+	kpisCollection = db.KPIsCollection()
+	devicesCollection = db.DevicesCollection()
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListDeviceRegistry godoc
+// @Summary      List registered devices
+// @Description  Lists the registry entries of the devices the user may see, with their groups, tags and location. Accepts the group, tag and location selectors.
+// @Tags         devices
+// @Produce      json
+// @Security     BearerAuth
+// @Param        group     query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag       query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location  query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
+// @Success      200  {array}   models.Device
+// @Failure      500  {object}  map[string]string
+// @Router       /api/devices/registry [get]
+func ListDeviceRegistry(c *gin.Context) {
+	devices, err := db.ListDevices(c.Request.Context(), deviceScope(c).Apply(bson.M{}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+	c.JSON(http.StatusOK, devices)
+}
+
+// GetDevice godoc
+// @Summary      Get a device
+// @Description  Returns the registry entry of a device.
+// @Tags         devices
+// @Produce      json
+// @Security     BearerAuth
+// @Param        device_id  path  string  true  "Device ID"
+// @Success      200  {object}  models.Device
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/devices/{device_id} [get]
+func GetDevice(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	if !deviceScope(c).Allows(deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	device, err := db.GetDevice(c.Request.Context(), deviceID)
+	if errors.Is(err, db.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device"})
+		return
+	}
+	c.JSON(http.StatusOK, device)
+}
+
+// UpdateDevice godoc
+// @Summary      Update a device
+// @Description  Replaces the name, groups, tags and location of a device, registering it if it is new. The location must belong to the device's tenant. Callers without devices:all can only add or remove their own device groups.
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        device_id  path  string                      true  "Device ID"
+// @Param        device     body  models.UpdateDeviceRequest  true  "Device"
+// @Success      200  {object}  models.Device
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/devices/{device_id} [put]
+func UpdateDevice(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	if !deviceScope(c).Allows(deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	var req models.UpdateDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before, err := db.GetDevice(ctx, deviceID)
+	if errors.Is(err, db.ErrDeviceNotFound) {
+		before = &models.Device{DeviceID: deviceID}
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device"})
+		return
+	}
+
+	// Groups decide who else sees the device, so callers limited to device groups can
+	// only add or remove their own
+	if !requireOwnGroups(c, changedGroups(before.Groups, req.Groups)) {
+		return
+	}
+
+	now := time.Now().UTC()
+	device := *before
+	device.Name = req.Name
+	device.Groups = nonNil(req.Groups)
+	device.Tags = nonNil(req.Tags)
+	device.LocationID, device.LocationPath, device.Location = nil, nil, ""
+	device.UpdatedAt = &now
+
+	if req.LocationID != "" {
+		id, err := primitive.ObjectIDFromHex(req.LocationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+			return
+		}
+		loc, err := db.GetLocation(ctx, id)
+		if errors.Is(err, db.ErrLocationNotFound) || (err == nil && loc.TenantID != device.TenantID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown location for this device"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location"})
+			return
+		}
+		label, err := locationLabel(c, *loc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location"})
+			return
+		}
+		device.LocationID, device.LocationPath, device.Location = &loc.ID, loc.Path(), label
+	}
+
+	if err := db.UpdateDevice(ctx, device); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.DeviceUpdate, TenantID: device.TenantID, TargetType: "device",
+		TargetID: deviceID, Changes: audit.Diff(before, device)})
+	c.JSON(http.StatusOK, device)
+}
+
+// changedGroups returns the groups in only one of before and after.
+func changedGroups(before, after []string) []string {
+	var changed []string
+	for _, g := range after {
+		if !slices.Contains(before, g) {
+			changed = append(changed, g)
+		}
+	}
+	for _, g := range before {
+		if !slices.Contains(after, g) {
+			changed = append(changed, g)
+		}
+	}
+	return changed
+}
+
+// locationLabel returns the display path of the location.
+func locationLabel(c *gin.Context, loc models.Location) (string, error) {
+	byID := map[primitive.ObjectID]models.Location{loc.ID: loc}
+	if len(loc.Ancestors) > 0 {
+		ancestors, err := db.ListLocations(c.Request.Context(), bson.M{"_id": bson.M{"$in": loc.Ancestors}})
+		if err != nil {
+			return "", err
+		}
+		for _, a := range ancestors {
+			byID[a.ID] = a
+		}
+	}
+	return models.LocationLabel(loc.Path(), byID), nil
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/export"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ExportSensorData godoc
+// @Summary      Export sensor data
+// @Description  Streams sensor data as CSV or NDJSON, decrypting payloads on the fly. Optional from/to bounds are RFC3339 timestamps.
+// @Tags         sensors
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Param        format     query  string  false  "Export format: csv or ndjson (default: csv)"
+// @Param        from       query  string  false  "Start of the time range (RFC3339)"
+// @Param        to         query  string  false  "End of the time range (RFC3339)"
+// @Param        device_id  query  string  false  "Device ID"
+// @Param        group      query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag        query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location   query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
+// @Success      200  {string}  string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/data/export [get]
+func ExportSensorData(c *gin.Context) {
+	exportCollection(c, sensorsCollection, "sensor_data", export.SensorColumns, audit.DataExport, true)
+}
+
+// ExportKPIs godoc
+// @Summary      Export KPIs
+// @Description  Streams KPIs as CSV or NDJSON. Optional from/to bounds are RFC3339 timestamps.
+// @Tags         kpis
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Param        format     query  string  false  "Export format: csv or ndjson (default: csv)"
+// @Param        from       query  string  false  "Start of the time range (RFC3339)"
+// @Param        to         query  string  false  "End of the time range (RFC3339)"
+// @Param        device_id  query  string  false  "Device ID"
+// @Param        group      query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag        query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location   query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
+// @Success      200  {string}  string
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/export [get]
+func ExportKPIs(c *gin.Context) {
+	exportCollection(c, kpisCollection, "kpis", export.KPIColumns, audit.KPIExport, false)
+}
+
+// exportCollection streams every document matching the request filters straight from
+// the cursor to the response, so large exports never have to fit in memory.
+func exportCollection(c *gin.Context, collection *mongo.Collection, name string, columns []string, action string, decrypt bool) {
+	format := c.DefaultQuery("format", export.FormatCSV)
+	if format != export.FormatCSV && format != export.FormatNDJSON {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected csv or ndjson"})
+		return
+	}
+
+	filter, err := export.Filter(c.Query("device_id"), c.Query("from"), c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Exports can take far longer than the usual 5s query budget, so they are only
+	// bounded by the client staying connected.
+	ctx := c.Request.Context()
+
+	cursor, err := export.Find(ctx, collection, deviceScope(c).Apply(filter))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	recordAudit(c, models.AuditEvent{Action: action, TargetType: "collection", TargetID: name, Details: queryDetails(c)})
+
+	filename := fmt.Sprintf("%s_%s.%s", name, time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Content-Type", export.ContentType(format))
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only cut the stream short.
+	if err := export.Write(ctx, c.Writer, cursor, format, columns, decrypt); err != nil {
+		logger.WarnContext(c.Request.Context(), "Export interrupted", "collection", name, "error", err)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/health"
+)
+
+// Healthz godoc
+// @Summary      Liveness probe
+// @Description  Returns 200 as long as the process is serving HTTP requests. It does not check dependencies.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /healthz [get]
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusUp})
+}
+
+// Readyz godoc
+// @Summary      Readiness probe
+// @Description  Checks every dependency (MongoDB, MQTT, WebSocket hub and, when encryption is enabled, the cipher API) and returns a per-dependency breakdown. Responds 503 if any dependency is down.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  health.Report
+// @Failure      503  {object}  health.Report
+// @Router       /readyz [get]
+func Readyz(c *gin.Context) {
+	report := health.Check(c.Request.Context(), 2*time.Second)
+	status := http.StatusOK
+	if report.Status != health.StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+)
+
+// JWKS godoc
+// @Summary      Token verification keys
+// @Description  Publishes the public keys that verify access tokens, so other services can check them without a shared secret. Empty while tokens are signed with HS256.
+// @Tags         auth
+// @Produce      json
+// @Success      200  {object}  jwtkeys.JWKSet
+// @Router       /.well-known/jwks.json [get]
+func JWKS(c *gin.Context) {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Signing keys unavailable"})
+		return
+	}
+	// Short cache, so verifiers pick up a new key soon after it is added
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.JWKS())
+}
@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/kpi"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListKPIDefinitions godoc
+// @Summary      List KPI definitions
+// @Description  Retrieves every user-defined KPI definition.
+// @Tags         kpis
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.KPIDefinition
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/definitions [get]
+func ListKPIDefinitions(c *gin.Context) {
+	defs, err := db.ListKPIDefinitions(c.Request.Context(), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch KPI definitions"})
+		return
+	}
+	c.JSON(http.StatusOK, defs)
+}
+
+// CreateKPIDefinition godoc
+// @Summary      Create a KPI definition
+// @Description  Validates and stores a KPI definition. It is picked up by the KPI engine on its next run. Definitions apply to every tenant, so only platform users may change them.
+// @Tags         kpis
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        definition  body      models.KPIDefinition  true  "KPI definition"
+// @Success      201  {object}  models.KPIDefinition
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/definitions [post]
+func CreateKPIDefinition(c *gin.Context) {
+	if !requirePlatform(c) {
+		return
+	}
+	var def models.KPIDefinition
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	def.ID = primitive.NilObjectID
+	if _, _, err := validateKPIDefinition(ctx, def); err != nil {
+		respondKPIValidationError(c, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	def.CreatedBy = c.GetString("username")
+	def.CreatedAt = now
+	def.UpdatedAt = now
+	if err := db.CreateKPIDefinition(ctx, &def); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save KPI definition"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.KPIDefinitionCreate, TargetType: "kpi_definition",
+		TargetID: def.ID.Hex(), Changes: audit.Diff(nil, def)})
+	c.JSON(http.StatusCreated, def)
+}
+
+// UpdateKPIDefinition godoc
+// @Summary      Update a KPI definition
+// @Description  Validates and replaces an existing KPI definition. Definitions referenced by enabled formulas cannot be disabled or renamed. Platform users only.
+// @Tags         kpis
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id          path      string                true  "Definition ID"
+// @Param        definition  body      models.KPIDefinition  true  "KPI definition"
+// @Success      200  {object}  models.KPIDefinition
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/definitions/{id} [put]
+func UpdateKPIDefinition(c *gin.Context) {
+	if !requirePlatform(c) {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid definition ID"})
+		return
+	}
+
+	var def models.KPIDefinition
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := db.GetKPIDefinition(ctx, id)
+	if err != nil {
+		respondKPIDefinitionError(c, err)
+		return
+	}
+
+	def.ID = id
+	if _, _, err := validateKPIDefinition(ctx, def); err != nil {
+		respondKPIValidationError(c, err)
+		return
+	}
+	// Disabling or renaming would break the formulas that reference it
+	if !def.Enabled || def.Name != existing.Name {
+		if !checkKPIDependents(c, *existing) {
+			return
+		}
+	}
+
+	def.CreatedBy = existing.CreatedBy
+	def.CreatedAt = existing.CreatedAt
+	def.UpdatedAt = time.Now().UTC()
+	if err := db.UpdateKPIDefinition(ctx, def); err != nil {
+		respondKPIDefinitionError(c, err)
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.KPIDefinitionUpdate, TargetType: "kpi_definition",
+		TargetID: id.Hex(), Changes: audit.Diff(existing, def)})
+	c.JSON(http.StatusOK, def)
+}
+
+// DeleteKPIDefinition godoc
+// @Summary      Delete a KPI definition
+// @Description  Removes a KPI definition. KPIs already computed from it are kept. Definitions referenced by enabled formulas cannot be removed. Platform users only.
+// @Tags         kpis
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Definition ID"
+// @Success      200  {object}  map[string]string
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/definitions/{id} [delete]
+func DeleteKPIDefinition(c *gin.Context) {
+	if !requirePlatform(c) {
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid definition ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := db.GetKPIDefinition(ctx, id)
+	if err != nil {
+		respondKPIDefinitionError(c, err)
+		return
+	}
+	if !checkKPIDependents(c, *existing) {
+		return
+	}
+	if err := db.DeleteKPIDefinition(ctx, id); err != nil {
+		respondKPIDefinitionError(c, err)
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.KPIDefinitionDelete, TargetType: "kpi_definition",
+		TargetID: id.Hex(), Changes: audit.Diff(existing, nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "KPI definition deleted"})
+}
+
+// PreviewKPIDefinition godoc
+// @Summary      Preview a KPI definition
+// @Description  Evaluates a KPI definition over historical sensor data without saving it. Defaults to the last 24 hours. Platform users only, as it reads every tenant's data.
+// @Tags         kpis
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        from        query     string                false  "Start of the time range (RFC3339)"
+// @Param        to          query     string                false  "End of the time range (RFC3339)"
+// @Param        definition  body      models.KPIDefinition  true   "KPI definition"
+// @Success      200  {array}   models.KPI
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/definitions/preview [post]
+func PreviewKPIDefinition(c *gin.Context) {
+	if !requirePlatform(c) {
+		return
+	}
+	var def models.KPIDefinition
+	if err := c.ShouldBindJSON(&def); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-24 * time.Hour)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from timestamp, expected RFC3339"})
+			return
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to timestamp, expected RFC3339"})
+			return
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	parsed, all, err := validateKPIDefinition(ctx, def)
+	if err != nil {
+		respondKPIValidationError(c, err)
+		return
+	}
+
+	cfg, err := kpi.LoadConfig()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid KPI engine configuration"})
+		return
+	}
+
+	results, err := kpi.Preview(ctx, cfg, parsed, all, from, to)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Preview timed out"})
+			return
+		}
+		if errors.Is(err, kpi.ErrPreviewTooLong) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		logger.ErrorContext(ctx, "KPI preview failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview KPI definition"})
+		return
+	}
+	if results == nil {
+		results = []models.KPI{}
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// kpiDefinitionError is a problem with the definition itself, answered with 400, as
+// opposed to a failure loading the configuration or the stored definitions.
+type kpiDefinitionError struct{ error }
+
+// validateKPIDefinition checks the definition on its own and against the built-in and
+// stored definitions: the name must be unique and formula references must resolve to
+// built-in or enabled definitions without cycles. It returns the parsed definition and
+// every definition it may reference. Invalid definitions give a kpiDefinitionError.
+func validateKPIDefinition(ctx context.Context, m models.KPIDefinition) (kpi.Definition, []kpi.Definition, error) {
+	def, err := kpi.FromModel(m)
+	if err != nil {
+		return kpi.Definition{}, nil, kpiDefinitionError{err}
+	}
+
+	cfg, err := kpi.LoadConfig()
+	if err != nil {
+		return kpi.Definition{}, nil, err
+	}
+	for _, builtin := range cfg.Definitions {
+		if builtin.Name == m.Name {
+			return kpi.Definition{}, nil, kpiDefinitionError{fmt.Errorf("name %q is used by a built-in KPI", m.Name)}
+		}
+	}
+
+	stored, err := db.ListKPIDefinitions(ctx, false)
+	if err != nil {
+		return kpi.Definition{}, nil, err
+	}
+
+	all := make([]kpi.Definition, 0, len(cfg.Definitions)+len(stored)+1)
+	for _, builtin := range cfg.Definitions {
+		builtin.Window = cfg.Window
+		all = append(all, builtin)
+	}
+	for _, other := range stored {
+		if other.ID == m.ID {
+			continue
+		}
+		if other.Name == m.Name {
+			return kpi.Definition{}, nil, kpiDefinitionError{fmt.Errorf("a KPI named %q already exists", m.Name)}
+		}
+		// The engine skips disabled definitions, so formulas cannot rely on them
+		if !other.Enabled {
+			continue
+		}
+		if parsed, err := kpi.FromModel(other); err == nil {
+			all = append(all, parsed)
+		}
+	}
+
+	if err := kpi.ValidateReferences(def, all); err != nil {
+		return kpi.Definition{}, nil, kpiDefinitionError{err}
+	}
+	return def, all, nil
+}
+
+// checkKPIDependents responds with 409 and returns false when enabled formulas reference
+// the stored definition, which therefore cannot be deleted, disabled or renamed.
+func checkKPIDependents(c *gin.Context, m models.KPIDefinition) bool {
+	stored, err := db.ListKPIDefinitions(c.Request.Context(), true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to access KPI definitions"})
+		return false
+	}
+	others := make([]kpi.Definition, 0, len(stored))
+	for _, other := range stored {
+		if other.ID == m.ID {
+			continue
+		}
+		if parsed, err := kpi.FromModel(other); err == nil {
+			others = append(others, parsed)
+		}
+	}
+	if dependents := kpi.Dependents(m.Name, others); len(dependents) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("KPI %q is used by %s", m.Name, strings.Join(dependents, ", "))})
+		return false
+	}
+	return true
+}
+
+// respondKPIValidationError answers 400 with the problem for invalid definitions and 500
+// for any other failure, whose details are only logged.
+func respondKPIValidationError(c *gin.Context, err error) {
+	var invalid kpiDefinitionError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.ErrorContext(c.Request.Context(), "Failed to validate KPI definition", "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate KPI definition"})
+}
+
+func respondKPIDefinitionError(c *gin.Context, err error) {
+	if errors.Is(err, db.ErrKPIDefinitionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "KPI definition not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to access KPI definitions"})
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var devicesCollection *mongo.Collection
+
+// GetKPISummary godoc
+// @Summary      Fleet-wide KPI summary
+// @Description  Summarises KPIs across the fleet, grouped by KPI name, device tag or device location. Each group reports the mean latest value, the mean over the current and previous period, the trend between them and the top/bottom devices.
+// @Tags         kpis
+// @Produce      json
+// @Security     BearerAuth
+// @Param        group_by      query    string  false  "name, tag or location (default: name)"
+// @Param        name          query    string  false  "Only summarise this KPI"
+// @Param        period_hours  query    int     false  "Length of the current period in hours (default: 24)"
+// @Param        top           query    int     false  "Number of top and bottom devices (default: 5)"
+// @Param        group         query    []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag           query    []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location      query    []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
+// @Success      200  {array}   models.KPISummary
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/kpis/summary [get]
+func GetKPISummary(c *gin.Context) {
+	groupBy := c.DefaultQuery("group_by", "name")
+	if groupBy != "name" && groupBy != "tag" && groupBy != "location" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by, expected name, tag or location"})
+		return
+	}
+
+	periodHours, _ := strconv.Atoi(c.DefaultQuery("period_hours", "24"))
+	if periodHours <= 0 {
+		periodHours = 24
+	}
+	if periodHours > 24*90 {
+		periodHours = 24 * 90
+	}
+
+	top, _ := strconv.Atoi(c.DefaultQuery("top", "5"))
+	if top < 0 {
+		top = 5
+	}
+	if top > 50 {
+		top = 50
+	}
+
+	period := time.Duration(periodHours) * time.Hour
+	periodStart := time.Now().UTC().Add(-period)
+	pipeline := kpiSummaryPipeline(deviceScope(c), groupBy, c.Query("name"), periodStart, period)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := kpisCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Aggregation failed"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	summaries := []models.KPISummary{}
+	if err := cursor.All(ctx, &summaries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding KPI summary"})
+		return
+	}
+
+	for i := range summaries {
+		finishKPISummary(&summaries[i], top)
+	}
+
+	c.JSON(http.StatusOK, summaries)
+}
+
+// kpiSummaryPipeline reduces the KPIs of the last two periods to one value per device
+// and KPI, optionally joins the device metadata, and then aggregates per group.
+func kpiSummaryPipeline(scope rbac.Scope, groupBy, name string, periodStart time.Time, period time.Duration) mongo.Pipeline {
+	match := scope.Apply(bson.M{"timestamp": bson.M{"$gte": periodStart.Add(-period)}})
+	if name != "" {
+		match["name"] = name
+	}
+
+	inCurrent := bson.M{"$gte": bson.A{"$timestamp", periodStart}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"device_id": "$device_id", "name": "$name"},
+			"latest":   bson.M{"$first": "$value"},
+			"current":  bson.M{"$avg": bson.M{"$cond": bson.A{inCurrent, "$value", nil}}},
+			"previous": bson.M{"$avg": bson.M{"$cond": bson.A{inCurrent, nil, "$value"}}},
+		}}},
+	}
+
+	var groupKey interface{}
+	switch groupBy {
+	case "tag":
+		pipeline = append(pipeline, deviceLookupStages()...)
+		pipeline = append(pipeline, bson.D{{Key: "$unwind", Value: "$device.tags"}})
+		groupKey = "$device.tags"
+	case "location":
+		pipeline = append(pipeline, deviceLookupStages()...)
+		groupKey = bson.M{"$ifNull": bson.A{"$device.location", "unassigned"}}
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":      bson.M{"name": "$_id.name", "group": groupKey},
+			"devices":  bson.M{"$sum": 1},
+			"latest":   bson.M{"$avg": "$latest"},
+			"current":  bson.M{"$avg": "$current"},
+			"previous": bson.M{"$avg": "$previous"},
+			"device_values": bson.M{"$push": bson.M{
+				"device_id": "$_id.device_id",
+				"value":     "$latest",
+			}},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":           0,
+			"name":          "$_id.name",
+			"group":         "$_id.group",
+			"devices":       1,
+			"latest":        1,
+			"current":       1,
+			"previous":      1,
+			"device_values": 1,
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "group", Value: 1}}}},
+	)
+	return pipeline
+}
+
+func deviceLookupStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         devicesCollection.Name(),
+			"localField":   "_id.device_id",
+			"foreignField": "device_id",
+			"as":           "device",
+		}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$device", "preserveNullAndEmptyArrays": true}}},
+	}
+}
+
+// finishKPISummary derives the trend and the top/bottom devices, which are simpler to
+// compute here than with array operators that older MongoDB releases lack.
+func finishKPISummary(s *models.KPISummary, top int) {
+	if s.Current != nil && s.Previous != nil {
+		trend := *s.Current - *s.Previous
+		s.Trend = &trend
+		if *s.Previous != 0 {
+			pct := trend / *s.Previous * 100
+			s.TrendPercent = &pct
+		}
+	}
+
+	values := s.DeviceValues
+	sort.Slice(values, func(i, j int) bool { return values[i].Value > values[j].Value })
+	n := top
+	if n > len(values) {
+		n = len(values)
+	}
+	s.Top = append([]models.DeviceKPIValue{}, values[:n]...)
+	s.Bottom = make([]models.DeviceKPIValue, 0, n)
+	for i := len(values) - 1; i >= len(values)-n; i-- {
+		s.Bottom = append(s.Bottom, values[i])
+	}
+}
@@ -45,7 +45,12 @@ func GetKPIsByDevice(c *gin.Context) {
 	}
 	skip := (page - 1) * limit
 
-	filter := bson.M{"device_id": deviceID}
+	scope := deviceScope(c)
+	if !scope.Allows(deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	filter := scope.Apply(bson.M{"device_id": deviceID})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -76,6 +81,9 @@ func GetKPIsByDevice(c *gin.Context) {
 // @Produce      json
 // @Param        limit  query    int     false  "Max results (default: 100)"
 // @Param        page   query    int     false  "Page number (default: 1)"
+// @Param        group     query    []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag       query    []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location  query    []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
 // @Success      200    {array}  map[string]interface{}
 // @Failure      500    {object} map[string]string
 // @Router       /api/kpis [get]
@@ -102,7 +110,7 @@ func GetAllKPIs(c *gin.Context) {
 
 	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit)).SetSkip(int64(skip))
 
-	cursor, err := kpisCollection.Find(ctx, bson.M{}, opts)
+	cursor, err := kpisCollection.Find(ctx, deviceScope(c).Apply(bson.M{}), opts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch KPIs"})
 		return
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetLocationTree godoc
+// @Summary      Get the location tree
+// @Description  Returns the sites of the caller's tenant with their buildings and rooms nested below them, and the devices the user may see at each location. Platform users see every tenant's locations unless tenant_id is given.
+// @Tags         locations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        tenant_id  query  string  false  "Tenant, for platform users"
+// @Success      200  {array}   models.LocationNode
+// @Failure      500  {object}  map[string]string
+// @Router       /api/locations [get]
+func GetLocationTree(c *gin.Context) {
+	filter := bson.M{}
+	if tenantID := callerTenant(c); tenantID != "" {
+		filter["tenant_id"] = tenantID
+	} else if tenantID := c.Query("tenant_id"); tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+
+	ctx := c.Request.Context()
+	locations, err := db.ListLocations(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+		return
+	}
+	devices, err := db.ListDevices(ctx, deviceScope(c).Apply(bson.M{"location_id": bson.M{"$exists": true}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+	c.JSON(http.StatusOK, models.LocationTree(locations, devices))
+}
+
+// CreateLocation godoc
+// @Summary      Create a location
+// @Description  Creates a site, or a building in a site, or a room in a building. Children belong to the tenant of their site.
+// @Tags         locations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        location  body      models.CreateLocationRequest  true  "Location"
+// @Success      201  {object}  models.Location
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/locations [post]
+func CreateLocation(c *gin.Context) {
+	var req models.CreateLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	parentKind, ok := models.ParentKind(req.Kind)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be one of " + strings.Join(models.LocationKinds, ", ")})
+		return
+	}
+
+	ctx := c.Request.Context()
+	loc := models.Location{Kind: req.Kind, Name: strings.TrimSpace(req.Name), CreatedAt: time.Now().UTC()}
+	if loc.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+		return
+	}
+
+	if parentKind == "" {
+		if req.ParentID != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A site has no parent"})
+			return
+		}
+		loc.TenantID = callerTenant(c)
+		if loc.TenantID == "" && req.TenantID != "" {
+			if _, err := db.GetOrganization(ctx, req.TenantID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tenant " + req.TenantID})
+				return
+			}
+			loc.TenantID = req.TenantID
+		}
+	} else {
+		parent, ok := findLocation(c, req.ParentID)
+		if !ok {
+			return
+		}
+		if parent.Kind != parentKind {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A " + req.Kind + " must be inside a " + parentKind})
+			return
+		}
+		loc.ParentID = &parent.ID
+		loc.Ancestors = parent.Path()
+		loc.TenantID = parent.TenantID
+	}
+
+	if err := db.CreateLocation(ctx, &loc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create location"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.LocationCreate, TenantID: loc.TenantID, TargetType: "location",
+		TargetID: loc.ID.Hex(), Changes: audit.Diff(nil, loc)})
+	c.JSON(http.StatusCreated, loc)
+}
+
+// RenameLocation godoc
+// @Summary      Rename a location
+// @Description  Renames a location and updates the location shown for every device at or below it.
+// @Tags         locations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id        path  string                        true  "Location ID"
+// @Param        location  body  models.RenameLocationRequest  true  "New name"
+// @Success      200  {object}  models.Location
+// @Failure      400  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/locations/{id} [put]
+func RenameLocation(c *gin.Context) {
+	loc, ok := findLocation(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	var req models.RenameLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	before := *loc
+	loc.Name = strings.TrimSpace(req.Name)
+	if err := db.RenameLocation(ctx, loc.ID, loc.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename location"})
+		return
+	}
+	if err := relabelDevices(c, loc.ID); err != nil {
+		logger.ErrorContext(ctx, "Failed to update device locations after rename", "location_id", loc.ID.Hex(), "error", err)
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.LocationRename, TenantID: loc.TenantID, TargetType: "location",
+		TargetID: loc.ID.Hex(), Changes: audit.Diff(before, loc)})
+	c.JSON(http.StatusOK, loc)
+}
+
+// DeleteLocation godoc
+// @Summary      Delete a location
+// @Description  Deletes a location that has no locations or devices inside it.
+// @Tags         locations
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path  string  true  "Location ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      409  {object}  map[string]string  "Location not empty"
+// @Failure      500  {object}  map[string]string
+// @Router       /api/locations/{id} [delete]
+func DeleteLocation(c *gin.Context) {
+	loc, ok := findLocation(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	inUse, err := db.LocationInUse(ctx, loc.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		return
+	}
+	if inUse {
+		c.JSON(http.StatusConflict, gin.H{"error": "Move or delete the locations and devices inside it first"})
+		return
+	}
+	if err := db.DeleteLocation(ctx, loc.ID); err != nil && !errors.Is(err, db.ErrLocationNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.LocationDelete, TenantID: loc.TenantID, TargetType: "location",
+		TargetID: loc.ID.Hex(), Changes: audit.Diff(loc, nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "Location deleted"})
+}
+
+// findLocation loads the location with the given ID, answering 404 for locations of
+// other tenants.
+func findLocation(c *gin.Context, hexID string) (*models.Location, bool) {
+	id, err := primitive.ObjectIDFromHex(hexID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+		return nil, false
+	}
+	loc, err := db.GetLocation(c.Request.Context(), id)
+	if errors.Is(err, db.ErrLocationNotFound) || (err == nil && !sameTenant(c, loc.TenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location"})
+		return nil, false
+	}
+	return loc, true
+}
+
+// relabelDevices recomputes the location display path of every device at or below the
+// location.
+func relabelDevices(c *gin.Context, id primitive.ObjectID) error {
+	ctx := c.Request.Context()
+	devices, err := db.ListDevices(ctx, bson.M{"location_path": id})
+	if err != nil || len(devices) == 0 {
+		return err
+	}
+
+	var ids []primitive.ObjectID
+	for _, d := range devices {
+		ids = append(ids, d.LocationPath...)
+	}
+	locations, err := db.ListLocations(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	byID := make(map[primitive.ObjectID]models.Location, len(locations))
+	for _, l := range locations {
+		byID[l.ID] = l
+	}
+
+	labels := make(map[string]string, len(devices))
+	for _, d := range devices {
+		labels[d.DeviceID] = models.LocationLabel(d.LocationPath, byID)
+	}
+	return db.SetDeviceLocations(ctx, labels)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/lockout"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/ratelimit"
+)
+
+var (
+	loginGuardOnce   sync.Once
+	loginIPLimiter   *ratelimit.Limiter
+	loginUserLimiter *ratelimit.Limiter
+	lockoutPolicy    lockout.Policy
+)
+
+// loadLoginGuard reads the login protection settings on first use, after .env is loaded.
+func loadLoginGuard() {
+	loginGuardOnce.Do(func() {
+		ipRate := envPositiveInt("LOGIN_IP_RATE_PER_MINUTE", 20)
+		userRate := envPositiveInt("LOGIN_USER_RATE_PER_MINUTE", 10)
+		loginIPLimiter = ratelimit.PerMinute(ipRate, ipRate)
+		loginUserLimiter = ratelimit.PerMinute(userRate, userRate)
+		lockoutPolicy = lockout.LoadPolicy()
+	})
+}
+
+// allowLoginAttempt applies the per-IP and per-username rate limits. It responds with
+// 429 and returns false when either bucket is empty.
+func allowLoginAttempt(c *gin.Context, username string) bool {
+	loadLoginGuard()
+	now := time.Now()
+
+	if ok, wait := loginIPLimiter.Allow(c.ClientIP(), now); !ok {
+		tooManyLoginAttempts(c, wait, "Too many login attempts from this address")
+		return false
+	}
+	if ok, wait := loginUserLimiter.Allow(loginKey(username), now); !ok {
+		tooManyLoginAttempts(c, wait, "Too many login attempts for this account")
+		return false
+	}
+	return true
+}
+
+// checkLockout responds with 429 and returns false while the account is locked out.
+// Unknown usernames are tracked too, so the response does not reveal which exist.
+func checkLockout(c *gin.Context, attempt *models.LoginAttempt) bool {
+	if until, locked := lockout.LockedUntil(attempt, time.Now()); locked {
+		tooManyLoginAttempts(c, time.Until(until), "Account temporarily locked after repeated failed logins")
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed login and locks the account once the policy says so.
+func recordLoginFailure(c *gin.Context, username string) {
+	ctx := c.Request.Context()
+	next, err := db.RecordLoginFailure(ctx, loginKey(username), lockoutPolicy.FailureUpdate(time.Now().UTC()))
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to record failed login", "username", username, "error", err)
+		return
+	}
+	if until, locked := lockout.LockedUntil(next, time.Now()); locked {
+		logger.WarnContext(ctx, "Account locked after repeated failed logins",
+			"username", username, "client_ip", c.ClientIP(), "locked_until", until, "lockouts", next.Lockouts)
+	}
+}
+
+func tooManyLoginAttempts(c *gin.Context, wait time.Duration, msg string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": msg})
+}
+
+// loginKey normalizes usernames so case variations share one bucket and lockout.
+func loginKey(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func envPositiveInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
+
+// UnlockUser godoc
+// @Summary      Unlock a user account
+// @Description  Clears the failed login count and any lockout of the given username.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        username  path      string  true  "Username"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/users/{username}/unlock [post]
+func UnlockUser(c *gin.Context) {
+	username := c.Param("username")
+	if !requireUserInTenant(c, username) {
+		return
+	}
+	cleared, err := db.DeleteLoginAttempt(c.Request.Context(), loginKey(username))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	if !cleared {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failed logins recorded for this user"})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Account unlocked", "username", username, "by", c.GetString("username"))
+	recordAudit(c, models.AuditEvent{Action: audit.UserUnlock, TargetType: "user", TargetID: username})
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked"})
+}
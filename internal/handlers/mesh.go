@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/mesh"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetMeshTopology godoc
+// @Summary      Get the mesh topology
+// @Description  Returns the ESP-MESH tree as nodes and parent edges, with each node's layer, signal strength and status: online, offline (not heard from within MESH_NODE_TIMEOUT_SECONDS) or orphaned (reporting, but its parents do not reach a root). Only the user's devices are listed; statuses take the whole tenant's mesh into account.
+// @Tags         mesh
+// @Produce      json
+// @Security     BearerAuth
+// @Param        mesh_id   query  string    false  "Only this mesh"
+// @Param        group     query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag       query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location  query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
+// @Success      200  {object}  models.MeshTopology
+// @Failure      500  {object}  map[string]string
+// @Router       /api/mesh/topology [get]
+func GetMeshTopology(c *gin.Context) {
+	scope := deviceScope(c)
+	filter := bson.M{}
+	if scope.TenantID != "" {
+		filter["tenant_id"] = bson.M{"$in": bson.A{scope.TenantID, nil}}
+	}
+	if meshID := c.Query("mesh_id"); meshID != "" {
+		filter["mesh_id"] = meshID
+	}
+
+	nodes, err := db.ListMeshNodes(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mesh topology"})
+		return
+	}
+	topo := mesh.Build(nodes, time.Now().UTC(), mesh.LoadConfig().NodeTimeout)
+
+	visible := models.MeshTopology{Nodes: []models.MeshTopologyNode{}, Edges: []models.MeshEdge{}, Roots: []string{},
+		GeneratedAt: topo.GeneratedAt}
+	for _, n := range topo.Nodes {
+		if scope.Allows(n.DeviceID) {
+			visible.Nodes = append(visible.Nodes, n)
+		}
+	}
+	for _, e := range topo.Edges {
+		if scope.Allows(e.Source) && scope.Allows(e.Target) {
+			visible.Edges = append(visible.Edges, e)
+		}
+	}
+	for _, id := range topo.Roots {
+		if scope.Allows(id) {
+			visible.Roots = append(visible.Roots, id)
+		}
+	}
+	c.JSON(http.StatusOK, visible)
+}
+
+// ListMeshEvents godoc
+// @Summary      Mesh topology history
+// @Description  Returns changes to the mesh, newest first: nodes joining, changing parent or becoming orphaned, and roots changing.
+// @Tags         mesh
+// @Produce      json
+// @Security     BearerAuth
+// @Param        type       query  string  false  "node.joined, node.parent_change, node.orphaned or root.change"
+// @Param        device_id  query  string  false  "Device ID"
+// @Param        mesh_id    query  string  false  "Mesh ID"
+// @Param        from       query  string  false  "Start of the time range (RFC3339)"
+// @Param        to         query  string  false  "End of the time range (RFC3339)"
+// @Param        limit      query  int     false  "Maximum events (default 100, max 1000)"
+// @Success      200  {array}   models.MeshEvent
+// @Failure      400  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/mesh/events [get]
+func ListMeshEvents(c *gin.Context) {
+	filter := bson.M{}
+	for _, key := range []string{"type", "device_id", "mesh_id"} {
+		if v := c.Query(key); v != "" {
+			filter[key] = v
+		}
+	}
+	window := bson.M{}
+	for key, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if v := c.Query(key); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + key + " timestamp, expected RFC3339"})
+				return
+			}
+			window[op] = t
+		}
+	}
+	if len(window) > 0 {
+		filter["time"] = window
+	}
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "100"), 10, 64)
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	events, err := db.FindMeshEvents(c.Request.Context(), deviceScope(c).Apply(filter), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mesh events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
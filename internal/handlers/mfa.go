@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/totp"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+)
+
+const recoveryCodeCount = 10
+
+func totpIssuer() string {
+	if v := os.Getenv("MFA_ISSUER"); v != "" {
+		return v
+	}
+	return "ESP32 Backend API"
+}
+
+// MFALoginHandler godoc
+// @Summary      Complete a login with a second factor
+// @Description  Exchanges the challenge token from /api/login and a TOTP or recovery code for a JWT.
+// @Tags         auth
+// @Accept       json
+// @Produce      json
+// @Param        login  body      models.MFALoginRequest  true  "Challenge token and code"
+// @Success      200    {object}  models.LoginResponse
+// @Failure      400    {object}  map[string]string  "Invalid request body"
+// @Failure      401    {object}  map[string]string  "Invalid or expired challenge, or wrong code"
+// @Failure      429    {object}  map[string]string  "Rate limited or account locked"
+// @Router       /api/login/mfa [post]
+func MFALoginHandler(c *gin.Context) {
+	var req models.MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	username, err := utils.ParseMFAChallenge(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+
+	// Codes are guessed against the same rate limits and lockout as passwords
+	if !allowLoginAttempt(c, username) {
+		return
+	}
+	ctx := c.Request.Context()
+	attempt, err := db.GetLoginAttempt(ctx, loginKey(username))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if !checkLockout(c, attempt) {
+		return
+	}
+
+	user, err := db.FindUserByUsername(ctx, username)
+	if err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+	ok, err := verifySecondFactor(ctx, user, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !ok {
+		recordLoginFailure(c, username)
+		recordAudit(c, models.AuditEvent{Action: audit.MFALogin, Outcome: audit.Failure, Actor: username,
+			Details: map[string]any{"reason": "invalid_code"}})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if attempt != nil {
+		if _, err := db.DeleteLoginAttempt(ctx, loginKey(username)); err != nil {
+			logger.ErrorContext(ctx, "Failed to reset failed logins", "username", username, "error", err)
+		}
+	}
+	tokenString, err := issueSession(c, user, "mfa")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	c.JSON(http.StatusOK, models.LoginResponse{Token: tokenString})
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when allowed, and consumes it.
+func verifySecondFactor(ctx context.Context, user *models.User, code string, allowRecovery bool) (bool, error) {
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		return db.UseTOTPStep(ctx, user.Username, step)
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+
+	code = totp.NormalizeRecoveryCode(code)
+	for _, hash := range user.RecoveryCodes {
+		if !utils.ComparePassword(hash, code) {
+			continue
+		}
+		used, err := db.UseRecoveryCode(ctx, user.Username, hash)
+		if used {
+			logger.WarnContext(ctx, "Recovery code used", "username", user.Username, "remaining", len(user.RecoveryCodes)-1)
+		}
+		return used, err
+	}
+	return false, nil
+}
+
+// currentLocalUser loads the caller for the password and MFA endpoints, which only apply
+// to local users signing in with a password.
+func currentLocalUser(c *gin.Context) (*models.User, bool) {
+	if c.GetString("api_key_id") != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not available with an API key"})
+		return nil, false
+	}
+	user, err := db.FindUserByUsername(c.Request.Context(), c.GetString("username"))
+	if errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return nil, false
+	}
+	if user.ServiceAccount || user.External {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not available for service accounts or accounts managed by the identity provider"})
+		return nil, false
+	}
+	return user, true
+}
+
+// guardCodeAttempt applies the login rate limits and lockout to the caller's code checks,
+// since guessing codes with a stolen token counts like a failed login. It returns false
+// once it has responded.
+func guardCodeAttempt(c *gin.Context, username string) bool {
+	if !allowLoginAttempt(c, username) {
+		return false
+	}
+	attempt, err := db.GetLoginAttempt(c.Request.Context(), loginKey(username))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return false
+	}
+	return checkLockout(c, attempt)
+}
+
+// newRecoveryCodes returns fresh recovery codes and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hash, err := utils.HashPassword(code)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashes[i] = hash
+	}
+	return codes, hashes, nil
+}
+
+// EnrollTOTP godoc
+// @Summary      Start TOTP enrollment
+// @Description  Generates a TOTP secret and its otpauth:// URI for an authenticator app. Enrollment completes with /api/mfa/totp/verify.
+// @Tags         mfa
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {object}  models.TOTPEnrollResponse
+// @Failure      409  {object}  map[string]string  "Already enabled"
+// @Router       /api/mfa/totp/enroll [post]
+func EnrollTOTP(c *gin.Context) {
+	user, ok := currentLocalUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret := totp.GenerateSecret()
+	if err := db.SetTOTPPending(c.Request.Context(), user.Username, secret); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+	c.JSON(http.StatusOK, models.TOTPEnrollResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer(), user.Username, secret),
+	})
+}
+
+// VerifyTOTP godoc
+// @Summary      Confirm TOTP enrollment
+// @Description  Enables two-factor login once a code from the new secret is verified, and returns recovery codes shown only once.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        code  body      models.MFACodeRequest  true  "Code from the authenticator app"
+// @Success      200   {object}  models.RecoveryCodesResponse
+// @Failure      400   {object}  map[string]string  "No enrollment in progress or invalid code"
+// @Failure      429   {object}  map[string]string  "Rate limited or account locked"
+// @Router       /api/mfa/totp/verify [post]
+func VerifyTOTP(c *gin.Context) {
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	user, ok := currentLocalUser(c)
+	if !ok {
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No enrollment in progress"})
+		return
+	}
+	if !guardCodeAttempt(c, user.Username) {
+		return
+	}
+	step, valid := totp.Validate(user.TOTPPendingSecret, req.Code, time.Now())
+	if !valid {
+		recordLoginFailure(c, user.Username)
+		recordAudit(c, models.AuditEvent{Action: audit.MFAEnable, Outcome: audit.Failure, TargetType: "user",
+			TargetID: user.Username, Details: map[string]any{"reason": "invalid_code"}})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := db.EnableTOTP(c.Request.Context(), user.Username, user.TOTPPendingSecret, step, hashes); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Enrollment changed, start again"})
+		return
+	}
+
+	logger.InfoContext(c.Request.Context(), "Two-factor authentication enabled", "username", user.Username)
+	recordAudit(c, models.AuditEvent{Action: audit.MFAEnable, TargetType: "user", TargetID: user.Username})
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP godoc
+// @Summary      Disable TOTP
+// @Description  Turns off two-factor login after checking a current TOTP or recovery code.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        code  body      models.MFACodeRequest  true  "TOTP or recovery code"
+// @Success      200   {object}  map[string]string
+// @Failure      400   {object}  map[string]string  "Not enabled or invalid code"
+// @Failure      429   {object}  map[string]string  "Rate limited or account locked"
+// @Router       /api/mfa/totp/disable [post]
+func DisableTOTP(c *gin.Context) {
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	user, ok := currentLocalUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !guardCodeAttempt(c, user.Username) {
+		return
+	}
+	valid, err := verifySecondFactor(c.Request.Context(), user, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		recordLoginFailure(c, user.Username)
+		recordAudit(c, models.AuditEvent{Action: audit.MFADisable, Outcome: audit.Failure, TargetType: "user",
+			TargetID: user.Username, Details: map[string]any{"reason": "invalid_code"}})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := db.DisableTOTP(c.Request.Context(), user.Username); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+	logger.InfoContext(c.Request.Context(), "Two-factor authentication disabled", "username", user.Username)
+	recordAudit(c, models.AuditEvent{Action: audit.MFADisable, TargetType: "user", TargetID: user.Username})
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary      Replace recovery codes
+// @Description  Invalidates the remaining recovery codes and returns new ones after checking a TOTP code.
+// @Tags         mfa
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        code  body      models.MFACodeRequest  true  "TOTP code"
+// @Success      200   {object}  models.RecoveryCodesResponse
+// @Failure      400   {object}  map[string]string  "Not enabled or invalid code"
+// @Failure      429   {object}  map[string]string  "Rate limited or account locked"
+// @Router       /api/mfa/recovery-codes [post]
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var req models.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	user, ok := currentLocalUser(c)
+	if !ok {
+		return
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !guardCodeAttempt(c, user.Username) {
+		return
+	}
+	valid, err := verifySecondFactor(c.Request.Context(), user, req.Code, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		recordLoginFailure(c, user.Username)
+		recordAudit(c, models.AuditEvent{Action: audit.RecoveryCodesRenew, Outcome: audit.Failure, TargetType: "user",
+			TargetID: user.Username, Details: map[string]any{"reason": "invalid_code"}})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+	if err := db.SetRecoveryCodes(c.Request.Context(), user.Username, hashes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store recovery codes"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.RecoveryCodesRenew, TargetType: "user", TargetID: user.Username})
+	c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// ResetUserMFA godoc
+// @Summary      Reset a user's two-factor authentication
+// @Description  Removes the TOTP secret and recovery codes of a user who lost both.
+// @Tags         mfa
+// @Produce      json
+// @Security     BearerAuth
+// @Param        username  path      string  true  "Username"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /api/users/{username}/mfa/reset [post]
+func ResetUserMFA(c *gin.Context) {
+	username := c.Param("username")
+	if !requireUserInTenant(c, username) {
+		return
+	}
+	err := db.DisableTOTP(c.Request.Context(), username)
+	if errors.Is(err, db.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication"})
+		return
+	}
+
+	logger.WarnContext(c.Request.Context(), "Two-factor authentication reset", "username", username, "by", c.GetString("username"))
+	recordAudit(c, models.AuditEvent{Action: audit.MFAReset, TargetType: "user", TargetID: username})
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication reset"})
+}
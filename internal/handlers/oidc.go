@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/sso"
+)
+
+const oidcFlowCookie = "oidc_flow"
+
+var ssoClient *sso.Client
+
+// InitSSO sets the OIDC client used by the login endpoints.
+func InitSSO(client *sso.Client) {
+	ssoClient = client
+}
+
+// oidcCookieSecret returns the key sealing the flow cookie, or nil if the token keys
+// failed to load, which Seal and OpenFlow refuse.
+func oidcCookieSecret() []byte {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		return nil
+	}
+	return keys.StateSecret()
+}
+
+// OIDCLogin godoc
+// @Summary      Start an OIDC login
+// @Description  Redirects to the identity provider using the authorization-code flow with PKCE.
+// @Tags         auth
+// @Success      302
+// @Failure      404  {object}  map[string]string  "OIDC login is disabled"
+// @Failure      502  {object}  map[string]string  "Identity provider unavailable"
+// @Router       /api/auth/oidc/login [get]
+func OIDCLogin(c *gin.Context) {
+	if ssoClient == nil || !ssoClient.Config().Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is disabled"})
+		return
+	}
+
+	flow := sso.NewFlow(time.Now())
+	authURL, err := ssoClient.AuthCodeURL(c.Request.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "OIDC login failed", "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	sealed, err := flow.Seal(oidcCookieSecret())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	setOIDCFlowCookie(c, sealed, int(sso.FlowTTL.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback godoc
+// @Summary      Complete an OIDC login
+// @Description  Exchanges the authorization code, provisions or updates the external user, and returns a JWT. When OIDC_POST_LOGIN_REDIRECT is set, redirects there with the token in the URL fragment instead.
+// @Tags         auth
+// @Produce      json
+// @Param        code   query     string  true  "Authorization code"
+// @Param        state  query     string  true  "State"
+// @Success      200    {object}  models.LoginResponse
+// @Failure      400    {object}  map[string]string
+// @Failure      403    {object}  map[string]string  "No role for the user's groups"
+// @Failure      409    {object}  map[string]string  "Username taken by a local account"
+// @Router       /api/auth/oidc/callback [get]
+func OIDCCallback(c *gin.Context) {
+	if ssoClient == nil || !ssoClient.Config().Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is disabled"})
+		return
+	}
+	ctx := c.Request.Context()
+	cfg := ssoClient.Config()
+
+	if idpErr := c.Query("error"); idpErr != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Identity provider returned " + idpErr})
+		return
+	}
+
+	sealed, err := c.Cookie(oidcFlowCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login session missing or expired, start again"})
+		return
+	}
+	setOIDCFlowCookie(c, "", -1)
+
+	flow, err := sso.OpenFlow(sealed, c.Query("state"), oidcCookieSecret())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid login state, start again"})
+		return
+	}
+
+	identity, err := ssoClient.Exchange(ctx, c.Query("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		logger.WarnContext(ctx, "OIDC callback rejected", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login could not be verified"})
+		return
+	}
+
+	role, err := cfg.Role(identity.Groups)
+	if err != nil || !rbac.IsRole(role) {
+		logger.WarnContext(ctx, "OIDC user has no local role", "username", identity.Username, "groups", identity.Groups, "role", role)
+		recordAudit(c, models.AuditEvent{Action: audit.OIDCLogin, Outcome: audit.Failure, Actor: identity.Username,
+			ActorType: audit.ActorOIDC, Details: map[string]any{"reason": "no_role", "groups": identity.Groups}})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is not authorized for this application"})
+		return
+	}
+
+	tenantID, err := cfg.Tenant(identity.Tenant)
+	if err == nil && tenantID != "" {
+		_, err = db.GetOrganization(ctx, tenantID)
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "OIDC user has no valid tenant", "username", identity.Username, "tenant_id", tenantID, "error", err)
+		recordAudit(c, models.AuditEvent{Action: audit.OIDCLogin, Outcome: audit.Failure, Actor: identity.Username,
+			ActorType: audit.ActorOIDC, TenantID: tenantID, Details: map[string]any{"reason": "no_tenant"}})
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account is not authorized for this application"})
+		return
+	}
+
+	user, status, err := provisionExternalUser(c, identity, role, tenantID)
+	if err != nil {
+		recordAudit(c, models.AuditEvent{Action: audit.OIDCLogin, Outcome: audit.Failure, Actor: identity.Username,
+			ActorType: audit.ActorOIDC, Details: map[string]any{"reason": err.Error()}})
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, err := issueSession(c, user, "oidc")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	if cfg.PostLoginRedirect != "" {
+		c.Redirect(http.StatusFound, cfg.PostLoginRedirect+"#token="+url.QueryEscape(token))
+		return
+	}
+	c.JSON(http.StatusOK, models.LoginResponse{Token: token})
+}
+
+// provisionExternalUser returns the local user for an IdP identity, creating it on first
+// login and refreshing its role, tenant and email afterwards. A local account with the
+// same username is never taken over.
+func provisionExternalUser(c *gin.Context, identity sso.Identity, role, tenantID string) (*models.User, int, error) {
+	ctx := c.Request.Context()
+
+	user, err := db.FindUserByExternalID(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		if user.Role != role || user.Email != identity.Email || user.TenantID != tenantID {
+			if err := db.UpdateExternalUser(ctx, user.Username, role, tenantID, identity.Email); err != nil {
+				return nil, http.StatusInternalServerError, errors.New("failed to update user")
+			}
+			action := audit.UserRoleChange
+			if user.TenantID != tenantID {
+				action = audit.UserTenantChange
+			}
+			before := *user
+			user.Role, user.TenantID, user.Email = role, tenantID, identity.Email
+			recordAudit(c, models.AuditEvent{Action: action, Actor: user.Username, ActorType: audit.ActorOIDC,
+				TenantID: tenantID, TargetType: "user", TargetID: user.Username, Changes: audit.Diff(before, user)})
+		}
+		return user, 0, nil
+	}
+	if !errors.Is(err, db.ErrUserNotFound) {
+		return nil, http.StatusInternalServerError, errors.New("failed to look up user")
+	}
+
+	if _, err := db.FindUserByUsername(ctx, identity.Username); err == nil {
+		logger.WarnContext(ctx, "OIDC username collides with a local account", "username", identity.Username, "issuer", identity.Issuer)
+		return nil, http.StatusConflict, errors.New("a local account with this username already exists")
+	} else if !errors.Is(err, db.ErrUserNotFound) {
+		return nil, http.StatusInternalServerError, errors.New("failed to look up user")
+	}
+
+	user = &models.User{
+		Username:        identity.Username,
+		Role:            role,
+		TenantID:        tenantID,
+		CreatedAt:       time.Now().UTC(),
+		External:        true,
+		ExternalIssuer:  identity.Issuer,
+		ExternalSubject: identity.Subject,
+		Email:           identity.Email,
+	}
+	if err := db.CreateUser(*user); err != nil {
+		return nil, http.StatusInternalServerError, errors.New("failed to provision user")
+	}
+	logger.InfoContext(ctx, "Provisioned external user", "username", user.Username, "role", role, "tenant_id", tenantID, "issuer", identity.Issuer)
+	recordAudit(c, models.AuditEvent{Action: audit.UserCreate, Actor: user.Username, ActorType: audit.ActorOIDC,
+		TenantID: tenantID, TargetType: "user", TargetID: user.Username, Changes: audit.Diff(nil, user)})
+	return user, 0, nil
+}
+
+func setOIDCFlowCookie(c *gin.Context, value string, maxAge int) {
+	secure := strings.HasPrefix(ssoClient.Config().RedirectURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, value, maxAge, "/api/auth/oidc", "", secure, true)
+}
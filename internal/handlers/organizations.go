@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/tenant"
+)
+
+// callerTenant returns the caller's tenant, empty for platform users.
+func callerTenant(c *gin.Context) string {
+	return c.GetString(middleware.TenantKey)
+}
+
+// sameTenant reports whether something of the tenant is visible to the caller.
+func sameTenant(c *gin.Context, tenantID string) bool {
+	caller := callerTenant(c)
+	return caller == "" || caller == tenantID
+}
+
+// requirePlatform answers 403 to tenant callers, for settings shared by every tenant.
+func requirePlatform(c *gin.Context) bool {
+	if callerTenant(c) != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only platform users can do this"})
+		return false
+	}
+	return true
+}
+
+// requireUserInTenant answers 404 unless the user belongs to the caller's tenant, so
+// tenant admins cannot act on, or learn about, other tenants' users. Platform callers
+// may act on any user.
+func requireUserInTenant(c *gin.Context, username string) bool {
+	tenantID := callerTenant(c)
+	if tenantID == "" {
+		return true
+	}
+	user, err := db.FindUserByUsername(c.Request.Context(), username)
+	if errors.Is(err, db.ErrUserNotFound) || (err == nil && !sameTenant(c, user.TenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up user"})
+		return false
+	}
+	return true
+}
+
+// ListOrganizations godoc
+// @Summary      List organizations
+// @Description  Lists every organization for platform users, and only their own for tenant users.
+// @Tags         organizations
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.Organization
+// @Failure      500  {object}  map[string]string
+// @Router       /api/organizations [get]
+func ListOrganizations(c *gin.Context) {
+	ctx := c.Request.Context()
+	if tenantID := callerTenant(c); tenantID != "" {
+		org, err := db.GetOrganization(ctx, tenantID)
+		if errors.Is(err, db.ErrOrganizationNotFound) {
+			c.JSON(http.StatusOK, []models.Organization{})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organizations"})
+			return
+		}
+		c.JSON(http.StatusOK, []models.Organization{*org})
+		return
+	}
+
+	orgs, err := db.ListOrganizations(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organizations"})
+		return
+	}
+	c.JSON(http.StatusOK, orgs)
+}
+
+// CreateOrganization godoc
+// @Summary      Create an organization
+// @Description  Creates a tenant. Only platform users, who belong to no organization, may create one.
+// @Tags         organizations
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        organization  body      models.CreateOrganizationRequest  true  "Organization"
+// @Success      201  {object}  models.Organization
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Router       /api/organizations [post]
+func CreateOrganization(c *gin.Context) {
+	if !requirePlatform(c) {
+		return
+	}
+	var req models.CreateOrganizationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !tenant.ValidID(req.ID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id must be lowercase letters, digits and dashes"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := db.ListOrganizations(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organizations"})
+		return
+	}
+	for _, prefix := range req.TopicPrefixes {
+		if !tenant.ValidPrefix(prefix) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Topic prefix " + prefix + " must end in / and contain no wildcards"})
+			return
+		}
+		if owner := tenant.PrefixOwner(existing, prefix, req.ID); owner != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Topic prefix " + prefix + " already belongs to " + owner})
+			return
+		}
+	}
+
+	org := models.Organization{
+		ID:            req.ID,
+		Name:          req.Name,
+		TopicPrefixes: req.TopicPrefixes,
+		CreatedAt:     time.Now().UTC(),
+	}
+	err = db.CreateOrganization(ctx, org)
+	if errors.Is(err, db.ErrOrganizationExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An organization with this id already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.OrganizationCreate, TenantID: org.ID, TargetType: "organization",
+		TargetID: org.ID, Changes: audit.Diff(nil, org)})
+	c.JSON(http.StatusCreated, org)
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/provision"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// provisioningConfig loads the provisioning settings, answering 503 when provisioning
+// is not configured.
+func provisioningConfig(c *gin.Context) (provision.Config, bool) {
+	cfg, err := provision.LoadConfig()
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Provisioning is not configured", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Provisioning is not configured"})
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// CreateProvisioningClaim godoc
+// @Summary      Create a provisioning claim
+// @Description  Lets the device with the factory ID join once with the returned claim code, as the given device ID in the caller's tenant and the given groups and location. Callers without devices:all can only choose their own device groups. The claim code is only returned here.
+// @Tags         provisioning
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        claim  body      models.CreateClaimRequest  true  "Claim"
+// @Success      201  {object}  models.CreateClaimResponse
+// @Failure      400  {object}  map[string]string
+// @Failure      403  {object}  map[string]string
+// @Failure      409  {object}  map[string]string
+// @Failure      503  {object}  map[string]string  "Provisioning not configured"
+// @Router       /api/provisioning/claims [post]
+func CreateProvisioningClaim(c *gin.Context) {
+	cfg, ok := provisioningConfig(c)
+	if !ok {
+		return
+	}
+	var req models.CreateClaimRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	req.FactoryID = strings.TrimSpace(req.FactoryID)
+	if req.DeviceID == "" {
+		req.DeviceID = req.FactoryID
+	}
+	if !provision.ValidDeviceID(req.DeviceID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device_id must not contain spaces or the characters / + # $"})
+		return
+	}
+
+	// Provisioning puts the device in these groups, as UpdateDevice would
+	if !requireOwnGroups(c, req.Groups) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tenantID := callerTenant(c)
+	if tenantID == "" && req.TenantID != "" {
+		if _, err := db.GetOrganization(ctx, req.TenantID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown tenant " + req.TenantID})
+			return
+		}
+		tenantID = req.TenantID
+	}
+
+	device, err := db.GetDevice(ctx, req.DeviceID)
+	if err == nil && device.TenantID != "" && device.TenantID != tenantID {
+		c.JSON(http.StatusConflict, gin.H{"error": "The device ID is already in use"})
+		return
+	}
+	if err != nil && !errors.Is(err, db.ErrDeviceNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device"})
+		return
+	}
+
+	now := time.Now().UTC()
+	ttl := cfg.ClaimTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	code, hash := provision.GenerateClaimCode()
+	claim := models.ProvisioningClaim{
+		FactoryID: req.FactoryID,
+		CodeHash:  hash,
+		DeviceID:  req.DeviceID,
+		TenantID:  tenantID,
+		Groups:    req.Groups,
+		CreatedBy: c.GetString("username"),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if req.LocationID != "" {
+		id, err := primitive.ObjectIDFromHex(req.LocationID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+			return
+		}
+		loc, err := db.GetLocation(ctx, id)
+		if errors.Is(err, db.ErrLocationNotFound) || (err == nil && loc.TenantID != tenantID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown location for this tenant"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location"})
+			return
+		}
+		claim.LocationID = &loc.ID
+	}
+
+	err = db.CreateClaim(ctx, &claim)
+	if errors.Is(err, db.ErrClaimExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This factory ID already has a claim, or the device ID a pending one; delete it to provision the device again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create claim"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.ClaimCreate, TenantID: tenantID, TargetType: "device",
+		TargetID: claim.DeviceID, Changes: audit.Diff(nil, claim)})
+	c.JSON(http.StatusCreated, models.CreateClaimResponse{ProvisioningClaim: claim, ClaimCode: code})
+}
+
+// ListProvisioningClaims godoc
+// @Summary      List provisioning claims
+// @Description  Lists the provisioning claims of the caller's tenant, newest first, including used and expired ones. Platform users see every tenant's claims unless tenant_id is given.
+// @Tags         provisioning
+// @Produce      json
+// @Security     BearerAuth
+// @Param        tenant_id  query  string  false  "Tenant, for platform users"
+// @Success      200  {array}   models.ProvisioningClaim
+// @Failure      500  {object}  map[string]string
+// @Router       /api/provisioning/claims [get]
+func ListProvisioningClaims(c *gin.Context) {
+	filter := bson.M{}
+	if tenantID := callerTenant(c); tenantID != "" {
+		filter["tenant_id"] = tenantID
+	} else if tenantID := c.Query("tenant_id"); tenantID != "" {
+		filter["tenant_id"] = tenantID
+	}
+	claims, err := db.ListClaims(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch claims"})
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// DeleteProvisioningClaim godoc
+// @Summary      Delete a provisioning claim
+// @Description  Deletes the claim of a factory ID, so its code can no longer be used, or so a new claim can be created to provision the device again. Devices already provisioned keep their credentials.
+// @Tags         provisioning
+// @Produce      json
+// @Security     BearerAuth
+// @Param        factory_id  path  string  true  "Factory ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Failure      500  {object}  map[string]string
+// @Router       /api/provisioning/claims/{factory_id} [delete]
+func DeleteProvisioningClaim(c *gin.Context) {
+	ctx := c.Request.Context()
+	claim, err := db.GetClaim(ctx, c.Param("factory_id"))
+	if errors.Is(err, db.ErrClaimNotFound) || (err == nil && !sameTenant(c, claim.TenantID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Claim not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch claim"})
+		return
+	}
+	if err := db.DeleteClaim(ctx, claim.FactoryID); err != nil && !errors.Is(err, db.ErrClaimNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete claim"})
+		return
+	}
+	recordAudit(c, models.AuditEvent{Action: audit.ClaimDelete, TenantID: claim.TenantID, TargetType: "device",
+		TargetID: claim.DeviceID, Changes: audit.Diff(claim, nil)})
+	c.JSON(http.StatusOK, gin.H{"message": "Claim deleted"})
+}
+
+// BootstrapDevice godoc
+// @Summary      Bootstrap a new device
+// @Description  Called by a new device with its factory ID and the one-time claim code from its label. Registers the device in the tenant, groups and location of its claim, creates its MQTT account and encryption key, and returns them as a configuration bundle. The secrets in the bundle are never returned again. After 5 wrong codes the claim is locked.
+// @Tags         provisioning
+// @Accept       json
+// @Produce      json
+// @Param        request  body      models.BootstrapRequest  true  "Factory ID and claim code"
+// @Success      200  {object}  models.BootstrapBundle
+// @Failure      400  {object}  map[string]string
+// @Failure      401  {object}  map[string]string  "Unknown factory ID, wrong, used, expired or locked claim code"
+// @Failure      500  {object}  map[string]string
+// @Failure      503  {object}  map[string]string  "Provisioning not configured"
+// @Router       /api/provisioning/bootstrap [post]
+func BootstrapDevice(c *gin.Context) {
+	cfg, ok := provisioningConfig(c)
+	if !ok {
+		return
+	}
+	var req models.BootstrapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now().UTC()
+	event := models.AuditEvent{Action: audit.DeviceProvision, Actor: req.FactoryID, ActorType: audit.ActorDevice,
+		Outcome: audit.Failure, TargetType: "device"}
+	refuse := func(reason string) {
+		event.Details = map[string]any{"reason": reason}
+		recordAudit(c, event)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid factory ID or claim code"})
+	}
+
+	claim, err := db.GetClaim(ctx, req.FactoryID)
+	if errors.Is(err, db.ErrClaimNotFound) {
+		refuse("unknown_factory_id")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch claim"})
+		return
+	}
+	event.TenantID, event.TargetID = claim.TenantID, claim.DeviceID
+	switch {
+	case claim.ClaimedAt != nil:
+		refuse("claim_used")
+		return
+	case now.After(claim.ExpiresAt):
+		refuse("claim_expired")
+		return
+	case claim.FailedAttempts >= provision.MaxFailedAttempts:
+		refuse("claim_locked")
+		return
+	case !provision.VerifyClaimCode(req.ClaimCode, claim.CodeHash):
+		if err := db.RecordFailedClaim(ctx, claim.ID); err != nil {
+			logger.ErrorContext(ctx, "Failed to count wrong claim code", "factory_id", claim.FactoryID, "error", err)
+		}
+		refuse("wrong_claim_code")
+		return
+	}
+
+	redeemed, err := db.RedeemClaim(ctx, claim.ID, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem claim"})
+		return
+	}
+	if !redeemed {
+		refuse("claim_used")
+		return
+	}
+
+	bundle, err := provisionDevice(c, cfg, claim, now)
+	if errors.Is(err, db.ErrDeviceOwned) {
+		// The claim can never succeed; it stays used so the code cannot be retried
+		event.Details = map[string]any{"reason": "device_owned"}
+		recordAudit(c, event)
+		c.JSON(http.StatusConflict, gin.H{"error": "The device ID belongs to another tenant"})
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Failed to provision device", "factory_id", claim.FactoryID, "device_id", claim.DeviceID, "error", err)
+		if err := db.ReleaseClaim(context.WithoutCancel(ctx), claim.ID); err != nil {
+			logger.ErrorContext(ctx, "Failed to release claim", "factory_id", claim.FactoryID, "error", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision device"})
+		return
+	}
+
+	event.Outcome = audit.Success
+	recordAudit(c, event)
+	c.JSON(http.StatusOK, bundle)
+}
+
+// provisionDevice registers the claimed device and creates its broker account and
+// encryption key.
+func provisionDevice(c *gin.Context, cfg provision.Config, claim *models.ProvisioningClaim, now time.Time) (*models.BootstrapBundle, error) {
+	ctx := c.Request.Context()
+	var org *models.Organization
+	if claim.TenantID != "" {
+		var err error
+		if org, err = db.GetOrganization(ctx, claim.TenantID); err != nil {
+			return nil, err
+		}
+	}
+	topic := provision.DataTopic(org, cfg.DataTopic, claim.DeviceID)
+
+	creds, err := provision.GenerateCredentials()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := provision.SealKey(cfg.KeyEncryptionKey, creds.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	device := models.Device{
+		DeviceID:      claim.DeviceID,
+		TenantID:      claim.TenantID,
+		Groups:        claim.Groups,
+		FactoryID:     claim.FactoryID,
+		ProvisionedAt: &now,
+		EncryptionKey: sealed,
+	}
+	if claim.LocationID != nil {
+		// A location deleted since the claim was made is skipped rather than failing the device
+		loc, err := db.GetLocation(ctx, *claim.LocationID)
+		if err != nil && !errors.Is(err, db.ErrLocationNotFound) {
+			return nil, err
+		}
+		if err == nil {
+			label, err := locationLabel(c, *loc)
+			if err != nil {
+				return nil, err
+			}
+			device.LocationID, device.LocationPath, device.Location = &loc.ID, loc.Path(), label
+		}
+	}
+	if err := db.ProvisionDevice(ctx, device); err != nil {
+		return nil, err
+	}
+
+	err = db.SaveMQTTCredential(ctx, models.MQTTCredential{
+		Username:     claim.DeviceID,
+		PasswordHash: creds.PasswordHash,
+		ACLs:         provision.ACLs(topic),
+		DeviceID:     claim.DeviceID,
+		TenantID:     claim.TenantID,
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.BootstrapBundle{
+		DeviceID: claim.DeviceID,
+		TenantID: claim.TenantID,
+		MQTT: models.BootstrapMQTT{
+			URL:      cfg.BrokerURL,
+			ClientID: claim.DeviceID,
+			Username: claim.DeviceID,
+			Password: creds.Password,
+			Topic:    topic,
+		},
+		EncryptionKey: base64.StdEncoding.EncodeToString(creds.EncryptionKey),
+		IssuedAt:      now,
+	}, nil
+}
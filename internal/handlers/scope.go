@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+)
+
+// deviceScope returns the devices the request may see. Routes mounted without the
+// DeviceScope middleware see no devices rather than all of them.
+func deviceScope(c *gin.Context) rbac.Scope {
+	if v, ok := c.Get(middleware.DeviceScopeKey); ok {
+		if scope, ok := v.(rbac.Scope); ok {
+			return scope
+		}
+	}
+	return rbac.Scope{}
+}
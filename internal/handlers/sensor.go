@@ -1,43 +1,18 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var sensorsCollection *mongo.Collection
 
-func decryptPayloadIfNeeded(payload string) string {
-	if os.Getenv("ENCRYPTION") != "true" {
-		return payload
-	}
-
-	cipherURL := os.Getenv("ENCRYPT_API_URL")
-	reqBody, _ := json.Marshal(map[string]string{
-		"payload": payload,
-	})
-
-	resp, err := http.Post(cipherURL+"decrypt", "application/json", bytes.NewBuffer(reqBody))
-	if err != nil || resp.StatusCode != http.StatusOK {
-		return "decryption_failed"
-	}
-	defer resp.Body.Close()
-
-	var res map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "decryption_error"
-	}
-	return res["decrypted"]
-}
-
 // GetSensorDataByDevice godoc
 // @Summary      Get sensor data by device ID
 // @Description  Retrieves all sensor data associated with a specific device ID. Decrypts the payload if needed before returning.
@@ -49,7 +24,12 @@ func decryptPayloadIfNeeded(payload string) string {
 // @Router       /sensors/{device_id} [get]
 func GetSensorDataByDevice(c *gin.Context) {
 	deviceID := c.Param("device_id")
-	filter := bson.M{"device_id": deviceID}
+	scope := deviceScope(c)
+	if !scope.Allows(deviceID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	filter := scope.Apply(bson.M{"device_id": deviceID})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -69,7 +49,7 @@ func GetSensorDataByDevice(c *gin.Context) {
 
 	for _, doc := range results {
 		if payload, ok := doc["payload"].(string); ok {
-			doc["payload"] = decryptPayloadIfNeeded(payload)
+			doc["payload"] = utils.DecryptPayloadIfNeeded(payload)
 		}
 	}
 
@@ -78,9 +58,12 @@ func GetSensorDataByDevice(c *gin.Context) {
 
 // GetAllSensorData godoc
 // @Summary      Retrieve all sensor data
-// @Description  Fetches all sensor data from the database, decrypting payloads if necessary.
+// @Description  Fetches all sensor data the user may see, decrypting payloads if necessary.
 // @Tags         sensors
 // @Produce      json
+// @Param        group      query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag        query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location   query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
 // @Success      200  {array}  map[string]interface{}
 // @Failure      500  {object}  map[string]string
 // @Router       /sensors [get]
@@ -88,7 +71,7 @@ func GetAllSensorData(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := sensorsCollection.Find(ctx, bson.M{})
+	cursor, err := sensorsCollection.Find(ctx, deviceScope(c).Apply(bson.M{}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch data"})
 		return
@@ -103,7 +86,7 @@ func GetAllSensorData(c *gin.Context) {
 
 	for _, doc := range results {
 		if payload, ok := doc["payload"].(string); ok {
-			doc["payload"] = decryptPayloadIfNeeded(payload)
+			doc["payload"] = utils.DecryptPayloadIfNeeded(payload)
 		}
 	}
 
@@ -112,9 +95,12 @@ func GetAllSensorData(c *gin.Context) {
 
 // GetActiveDevices godoc
 // @Summary      Get active devices
-// @Description  Retrieves a list of unique active device IDs from the sensors collection.
+// @Description  Retrieves a list of unique active device IDs from the sensors collection, limited to the user's device groups.
 // @Tags         devices
 // @Produce      json
+// @Param        group      query  []string  false  "Device group"  collectionFormat(multi)
+// @Param        tag        query  []string  false  "Device tag"  collectionFormat(multi)
+// @Param        location   query  []string  false  "Location ID, matching devices at or below it"  collectionFormat(multi)
 // @Success      200  {array}  map[string]interface{}  "List of active device IDs"
 // @Failure      500  {object}  map[string]string      "Internal server error"
 // @Router       /devices/active [get]
@@ -123,6 +109,7 @@ func GetActiveDevices(c *gin.Context) {
 	defer cancel()
 
 	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: deviceScope(c).Apply(bson.M{})}},
 		{{Key: "$group", Value: bson.M{
 			"_id": "$device_id",
 		}}},
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/rednexx46/esp32-backend-api/internal/ws"
+)
+
+// issueSession records a new session for the user and returns its access token.
+func issueSession(c *gin.Context, user *models.User, method string) (string, error) {
+	now := time.Now().UTC()
+	ttl := utils.TokenTTL()
+	session := models.Session{
+		ID:        utils.NewID(),
+		Username:  user.Username,
+		Method:    method,
+		ClientIP:  c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := db.CreateSession(c.Request.Context(), session); err != nil {
+		logger.ErrorContext(c.Request.Context(), "Failed to create session", "username", user.Username, "error", err)
+		return "", err
+	}
+
+	action := map[string]string{"password": audit.Login, "mfa": audit.MFALogin, "oidc": audit.OIDCLogin}[method]
+	recordAudit(c, models.AuditEvent{Action: action, Actor: user.Username, TenantID: user.TenantID,
+		TargetType: "session", TargetID: session.ID})
+	return utils.GenerateSessionToken(user.Username, user.Role, user.TenantID, session.ID, ttl)
+}
+
+// ListSessions godoc
+// @Summary      List your sessions
+// @Description  Lists the caller's active sessions, newest first. The session of the request is marked current.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200  {array}   models.Session
+// @Failure      500  {object}  map[string]string
+// @Router       /api/sessions [get]
+func ListSessions(c *gin.Context) {
+	sessions, err := db.ListActiveSessions(c.Request.Context(), c.GetString("username"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	current := c.GetString("session_id")
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary      Revoke one of your sessions
+// @Description  Revokes the session so its token stops working immediately and its live data streams close.
+// @Tags         auth
+// @Produce      json
+// @Security     BearerAuth
+// @Param        id   path      string  true  "Session ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  map[string]string
+// @Router       /api/sessions/{id} [delete]
+func RevokeSession(c *gin.Context) {
+	username := c.GetString("username")
+	err := db.RevokeSession(c.Request.Context(), username, c.Param("id"), time.Now().UTC())
+	if errors.Is(err, db.ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	ws.CloseSession(c.Param("id"))
+	logger.InfoContext(c.Request.Context(), "Session revoked", "username", username, "session_id", c.Param("id"))
+	recordAudit(c, models.AuditEvent{Action: audit.SessionRevoke, TargetType: "session", TargetID: c.Param("id")})
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
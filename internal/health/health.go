@@ -0,0 +1,93 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Status values reported per dependency and overall.
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusDisabled = "disabled"
+)
+
+// CheckFunc reports whether a dependency is usable. Returning ErrDisabled marks the
+// dependency as not in use rather than down.
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of a single dependency check.
+type Result struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// Report is the readiness breakdown returned by Check.
+type Report struct {
+	Status       string            `json:"status"`
+	Dependencies map[string]Result `json:"dependencies"`
+}
+
+// ErrDisabled is returned by checks for optional dependencies that are turned off.
+var ErrDisabled = errors.New("disabled")
+
+var (
+	checks = map[string]CheckFunc{}
+	mutex  sync.RWMutex
+)
+
+// Register adds or replaces the readiness check for a dependency.
+func Register(name string, check CheckFunc) {
+	mutex.Lock()
+	checks[name] = check
+	mutex.Unlock()
+}
+
+// Check runs every registered check concurrently, each bounded by timeout. The
+// overall status is up only if no dependency is down.
+func Check(ctx context.Context, timeout time.Duration) Report {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	report := Report{Status: StatusUp, Dependencies: make(map[string]Result, len(checks))}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			result := run(ctx, check, timeout)
+
+			mu.Lock()
+			report.Dependencies[name] = result
+			if result.Status == StatusDown {
+				report.Status = StatusDown
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+func run(ctx context.Context, check CheckFunc, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	result := Result{Status: StatusUp, LatencyMS: time.Since(start).Milliseconds()}
+	switch {
+	case errors.Is(err, ErrDisabled):
+		result.Status = StatusDisabled
+	case err != nil:
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+)
+
+var logger = logging.For("jobs")
+
+// Job is a unit of background work that runs on a fixed interval.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+var (
+	registry []Job
+	mutex    sync.Mutex
+	running  sync.WaitGroup
+)
+
+// Register adds a job to the scheduler. Jobs registered after Start are not run.
+func Register(job Job) {
+	mutex.Lock()
+	registry = append(registry, job)
+	mutex.Unlock()
+}
+
+// Start runs every registered job once immediately and then on its interval until ctx is
+// cancelled. Cancelling ctx stops scheduling new runs; a run already in progress is not
+// interrupted and finishes on its own context, bounded by the job's timeout.
+func Start(ctx context.Context) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, job := range registry {
+		running.Add(1)
+		go func(job Job) {
+			defer running.Done()
+			runLoop(ctx, job)
+		}(job)
+		logger.Info("Scheduled job", "job", job.Name, "interval", job.Interval.String())
+	}
+}
+
+// Wait blocks until every job loop has returned after the context passed to Start was
+// cancelled, which includes waiting for in-flight runs to finish their writes. It gives
+// up when ctx is done.
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func runLoop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		runOnce(context.WithoutCancel(ctx), job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce executes a single run of the job, bounded by its timeout when one is set.
+// A run never overlaps with the next one because the loop waits for it to return.
+func runOnce(ctx context.Context, job Job) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	if err := job.Run(ctx); err != nil {
+		logger.Error("Job failed", "job", job.Name, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+		return
+	}
+	logger.Info("Job completed", "job", job.Name, "duration_ms", time.Since(start).Milliseconds())
+}
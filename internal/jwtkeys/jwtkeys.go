@@ -0,0 +1,217 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one token signing key. Keys loaded from a public PEM can only verify.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer // nil for verify-only keys
+	Public    crypto.PublicKey
+	stateSeed []byte
+}
+
+// Set holds the key that signs new tokens and every key still accepted for verification.
+type Set struct {
+	signing *Key
+	keys    map[string]*Key
+	secret  []byte // HS256 secret: the signing key in legacy mode, otherwise accepted when AcceptHS256
+	legacy  bool
+}
+
+var (
+	loadOnce sync.Once
+	current  *Set
+	loadErr  error
+)
+
+// Load reads the signing keys on first use. With JWT_KEYS_DIR set, every PEM file in it
+// is a key named by its file name (without .pem): private keys (PKCS#8 RSA or Ed25519)
+// sign and verify, public keys only verify. JWT_SIGNING_KID picks the signing key and
+// defaults to the last private key in name order, so date-named keys rotate by adding a
+// file. Without JWT_KEYS_DIR, tokens are signed with HS256 and JWT_SECRET as before.
+func Load() error {
+	loadOnce.Do(func() {
+		current, loadErr = NewSet(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KID"),
+			os.Getenv("JWT_SECRET"), os.Getenv("JWT_ACCEPT_HS256") == "true")
+	})
+	return loadErr
+}
+
+// Current returns the loaded key set.
+func Current() (*Set, error) {
+	if err := Load(); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// NewSet loads the keys in dir as described for Load, or uses the HS256 secret alone
+// when dir is empty.
+func NewSet(dir, signingKID, secret string, acceptHS256 bool) (*Set, error) {
+	if dir == "" {
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET or JWT_KEYS_DIR must be set")
+		}
+		return &Set{secret: []byte(secret), legacy: true, keys: map[string]*Key{}}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	set := &Set{keys: map[string]*Key{}}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := readKey(kid, file)
+		if err != nil {
+			return nil, err
+		}
+		set.keys[kid] = key
+		if key.Private != nil && signingKID == "" {
+			set.signing = key // Last private key in name order wins
+		}
+	}
+	if signingKID != "" {
+		set.signing = set.keys[signingKID]
+		if set.signing == nil || set.signing.Private == nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KID: no private key %q in %s", signingKID, dir)
+		}
+	}
+	if set.signing == nil {
+		return nil, fmt.Errorf("JWT_KEYS_DIR: no private key in %s", dir)
+	}
+
+	if acceptHS256 {
+		if secret == "" {
+			return nil, errors.New("JWT_ACCEPT_HS256 requires JWT_SECRET")
+		}
+		set.secret = []byte(secret)
+	}
+	return set, nil
+}
+
+func readKey(kid, file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", file)
+	}
+
+	key := &Key{ID: kid}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported private key", file)
+		}
+		key.Private = signer
+		key.Public = signer.Public()
+		key.stateSeed = block.Bytes
+	case "PUBLIC KEY":
+		if key.Public, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q, expected PKCS#8 or PKIX", file, block.Type)
+	}
+
+	switch key.Public.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", file)
+	}
+	return key, nil
+}
+
+// Sign signs claims with the current signing key, naming it in the kid header.
+func (s *Set) Sign(claims jwt.Claims) (string, error) {
+	if s.legacy {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+	}
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signing.Private)
+}
+
+// Parse verifies a token against the key named by its kid, accepting only that key's
+// algorithm, or against the HS256 secret when it has no kid and HS256 is allowed.
+func (s *Set) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	methods := []string{}
+	if s.secret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	for _, key := range s.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+
+	opts = append(opts, jwt.WithValidMethods(methods))
+	_, err := jwt.ParseWithClaims(tokenString, claims, s.keyFunc, opts...)
+	return err
+}
+
+func (s *Set) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if s.secret != nil && token.Method == jwt.SigningMethodHS256 {
+			return s.secret, nil
+		}
+		return nil, errors.New("token has no kid")
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("kid %q is not an %s key", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// StateSecret returns an HMAC key for short-lived internal state, such as login
+// challenges and OIDC flow cookies, that other services never need to verify. It is
+// derived so that it never equals a token signing secret.
+func (s *Set) StateSecret() []byte {
+	seed := s.secret
+	if !s.legacy {
+		seed = s.signing.stateSeed
+	}
+	sum := sha256.Sum256(append([]byte("esp32-backend-api state:"), seed...))
+	return sum[:]
+}
+
+// SigningKeyID returns the kid of new tokens, or "" in HS256 mode.
+func (s *Set) SigningKeyID() string {
+	if s.legacy {
+		return ""
+	}
+	return s.signing.ID
+}
@@ -0,0 +1,268 @@
+package kpi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported KPI kinds.
+const (
+	KindUptime      = "uptime"
+	KindMessageRate = "message_rate"
+	KindPacketLoss  = "packet_loss"
+	KindMean        = "mean"
+	KindMin         = "min"
+	KindMax         = "max"
+	KindPercentile  = "percentile"
+	KindFormula     = "formula"
+)
+
+// DefaultDefinitions is used when KPI_DEFINITIONS is not set.
+const DefaultDefinitions = "uptime,message_rate,packet_loss"
+
+// Sample is a single sensor reading reduced to what the calculators need.
+type Sample struct {
+	Timestamp time.Time
+	Values    map[string]float64
+	Seq       int64
+	HasSeq    bool
+}
+
+// Window is a half-open time range [Start, End).
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Duration returns the length of the window.
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+// Definition describes how to derive one KPI from a device's samples.
+type Definition struct {
+	Name         string
+	Kind         string
+	Metric       string
+	Percentile   float64
+	Unit         string
+	Window       time.Duration // Zero means the engine's default window
+	DeviceIDs    []string      // Empty means every device
+	DevicePrefix string
+	Formula      *Formula // Set when Kind is KindFormula
+}
+
+// Matches reports whether the definition applies to the device.
+func (d Definition) Matches(deviceID string) bool {
+	if d.DevicePrefix != "" && !strings.HasPrefix(deviceID, d.DevicePrefix) {
+		return false
+	}
+	if len(d.DeviceIDs) == 0 {
+		return true
+	}
+	for _, id := range d.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseDefinitions parses a comma-separated list of KPI specs. Each spec is either a
+// metric-less kind (uptime, message_rate, packet_loss) or kind:metric, where kind is
+// mean, min, max or pNN (e.g. p95:temperature).
+func ParseDefinitions(spec string) ([]Definition, error) {
+	var defs []Definition
+	for _, raw := range strings.Split(spec, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		def, err := parseDefinition(raw)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("no KPI definitions in %q", spec)
+	}
+	return defs, nil
+}
+
+func parseDefinition(raw string) (Definition, error) {
+	kind, metric, hasMetric := strings.Cut(raw, ":")
+
+	switch kind {
+	case KindUptime:
+		return Definition{Name: kind, Kind: kind, Unit: "%"}, nil
+	case KindMessageRate:
+		return Definition{Name: kind, Kind: kind, Unit: "msg/min"}, nil
+	case KindPacketLoss:
+		return Definition{Name: kind, Kind: kind, Unit: "%"}, nil
+	}
+
+	if !hasMetric || metric == "" {
+		return Definition{}, fmt.Errorf("KPI %q requires a metric", raw)
+	}
+
+	switch kind {
+	case KindMean, KindMin, KindMax:
+		return Definition{Name: kind + "_" + metric, Kind: kind, Metric: metric}, nil
+	}
+
+	if strings.HasPrefix(kind, "p") {
+		p, err := strconv.ParseFloat(kind[1:], 64)
+		if err != nil || p <= 0 || p > 100 {
+			return Definition{}, fmt.Errorf("invalid percentile in KPI %q", raw)
+		}
+		return Definition{Name: kind + "_" + metric, Kind: KindPercentile, Metric: metric, Percentile: p}, nil
+	}
+
+	return Definition{}, fmt.Errorf("unknown KPI kind %q", kind)
+}
+
+// Compute evaluates the definition over the samples of one device in one window.
+// The boolean is false when there is not enough data to produce a value.
+func Compute(def Definition, samples []Sample, w Window, expectedInterval time.Duration) (float64, bool) {
+	switch def.Kind {
+	case KindUptime:
+		return Uptime(samples, w, expectedInterval), true
+	case KindMessageRate:
+		return MessageRate(samples, w), true
+	case KindPacketLoss:
+		return PacketLoss(samples)
+	}
+
+	values := metricValues(samples, def.Metric)
+	if len(values) == 0 {
+		return 0, false
+	}
+
+	switch def.Kind {
+	case KindMean:
+		return Mean(values), true
+	case KindMin:
+		sort.Float64s(values)
+		return values[0], true
+	case KindMax:
+		sort.Float64s(values)
+		return values[len(values)-1], true
+	case KindPercentile:
+		return Percentile(values, def.Percentile), true
+	}
+	return 0, false
+}
+
+// Uptime returns the percentage of expected reporting slots in the window that
+// received at least one message.
+func Uptime(samples []Sample, w Window, expectedInterval time.Duration) float64 {
+	if expectedInterval <= 0 || w.Duration() <= 0 {
+		return 0
+	}
+	slots := int(math.Ceil(float64(w.Duration()) / float64(expectedInterval)))
+	seen := make(map[int]bool, slots)
+	for _, s := range samples {
+		if s.Timestamp.Before(w.Start) || !s.Timestamp.Before(w.End) {
+			continue
+		}
+		seen[int(s.Timestamp.Sub(w.Start)/expectedInterval)] = true
+	}
+	return float64(len(seen)) / float64(slots) * 100
+}
+
+// MessageRate returns the number of messages per minute in the window.
+func MessageRate(samples []Sample, w Window) float64 {
+	minutes := w.Duration().Minutes()
+	if minutes <= 0 {
+		return 0
+	}
+	return float64(len(samples)) / minutes
+}
+
+// PacketLoss returns the percentage of messages missing according to gaps in the
+// sequence numbers. A sequence number lower than the previous one is treated as a
+// device reboot and starts a new run.
+func PacketLoss(samples []Sample) (float64, bool) {
+	var seqs []int64
+	for _, s := range samples {
+		if s.HasSeq {
+			seqs = append(seqs, s.Seq)
+		}
+	}
+	if len(seqs) < 2 {
+		return 0, false
+	}
+
+	// Samples arrive sorted by timestamp, so runs follow the device's own ordering.
+	var expected, received int64
+	runStart, last := seqs[0], seqs[0]
+	unique := map[int64]bool{seqs[0]: true}
+	flush := func() {
+		expected += last - runStart + 1
+		received += int64(len(unique))
+	}
+	for _, seq := range seqs[1:] {
+		if seq < last {
+			flush()
+			runStart = seq
+			unique = map[int64]bool{}
+		}
+		unique[seq] = true
+		last = seq
+	}
+	flush()
+
+	if expected <= 0 {
+		return 0, false
+	}
+	lost := expected - received
+	if lost < 0 {
+		lost = 0
+	}
+	return float64(lost) / float64(expected) * 100, true
+}
+
+// Mean returns the arithmetic mean of the values.
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// Percentile returns the p-th percentile (0-100) of the values using linear
+// interpolation between the closest ranks.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
+
+func metricValues(samples []Sample, metric string) []float64 {
+	var values []float64
+	for _, s := range samples {
+		if v, ok := s.Values[metric]; ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
@@ -0,0 +1,174 @@
+package kpi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+// MaxWindowMinutes bounds the window of a user-defined KPI to one week.
+const MaxWindowMinutes = 7 * 24 * 60
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,63}$`)
+
+// FromModel validates a stored KPI definition and converts it into an engine Definition.
+// Formula references are not resolved here; see ValidateReferences.
+func FromModel(m models.KPIDefinition) (Definition, error) {
+	if !namePattern.MatchString(m.Name) {
+		return Definition{}, errors.New("name must be 2-64 lowercase letters, digits or underscores, starting with a letter")
+	}
+	if m.WindowMinutes <= 0 || m.WindowMinutes > MaxWindowMinutes {
+		return Definition{}, fmt.Errorf("window_minutes must be between 1 and %d", MaxWindowMinutes)
+	}
+
+	var def Definition
+	switch {
+	case m.Formula != "" && m.Aggregation != "":
+		return Definition{}, errors.New("set either aggregation or formula, not both")
+	case m.Formula != "":
+		formula, err := ParseFormula(m.Formula)
+		if err != nil {
+			return Definition{}, fmt.Errorf("invalid formula: %w", err)
+		}
+		for _, ref := range formula.References() {
+			if ref == m.Name {
+				return Definition{}, errors.New("formula cannot reference itself")
+			}
+		}
+		def = Definition{Kind: KindFormula, Formula: formula}
+	case m.Aggregation != "":
+		spec := m.Aggregation
+		if m.Metric != "" {
+			spec += ":" + m.Metric
+		}
+		parsed, err := parseDefinition(spec)
+		if err != nil {
+			return Definition{}, err
+		}
+		def = parsed
+	default:
+		return Definition{}, errors.New("aggregation or formula is required")
+	}
+
+	def.Name = m.Name
+	def.Window = time.Duration(m.WindowMinutes) * time.Minute
+	def.DeviceIDs = m.DeviceIDs
+	def.DevicePrefix = m.DevicePrefix
+	if m.Unit != "" {
+		def.Unit = m.Unit
+	}
+	return def, nil
+}
+
+// ValidateReferences checks that every KPI referenced by def, directly or through
+// other formulas, exists in all and that the references do not form a cycle.
+func ValidateReferences(def Definition, all []Definition) error {
+	byName := make(map[string]Definition, len(all)+1)
+	for _, other := range all {
+		byName[other.Name] = other
+	}
+	byName[def.Name] = def
+
+	state := map[string]int{} // 1 = visiting, 2 = done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("formula cycle through %q", name)
+		case 2:
+			return nil
+		}
+		def, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown KPI %q", name)
+		}
+		state[name] = 1
+		if def.Formula != nil {
+			for _, ref := range def.Formula.References() {
+				if err := visit(ref); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+
+	return visit(def.Name)
+}
+
+// Dependents returns the names of the definitions in all whose formulas reference name
+// directly, in the order of all.
+func Dependents(name string, all []Definition) []string {
+	var names []string
+	for _, def := range all {
+		if def.Formula != nil && slices.Contains(def.Formula.References(), name) {
+			names = append(names, def.Name)
+		}
+	}
+	return names
+}
+
+// WithDependencies returns the targets plus every definition their formulas depend
+// on, transitively, taken from all. Dependencies are evaluated over the targets'
+// window so formulas always combine values from the same period.
+func WithDependencies(targets, all []Definition) []Definition {
+	byName := make(map[string]Definition, len(all))
+	for _, def := range all {
+		byName[def.Name] = def
+	}
+
+	included := map[string]bool{}
+	var result []Definition
+	var add func(def Definition)
+	add = func(def Definition) {
+		if included[def.Name] {
+			return
+		}
+		included[def.Name] = true
+		if def.Formula != nil {
+			for _, ref := range def.Formula.References() {
+				if dep, ok := byName[ref]; ok {
+					add(dep)
+				}
+			}
+		}
+		result = append(result, def)
+	}
+	for _, def := range targets {
+		add(def)
+	}
+	return result
+}
+
+// Evaluate computes the definitions for every device with samples in the window and
+// returns the values keyed by device and KPI name. Definitions must be ordered so that
+// formulas come after the KPIs they reference, as returned by WithDependencies.
+func Evaluate(defs []Definition, samples map[string][]Sample, w Window, expectedInterval time.Duration) map[string]map[string]float64 {
+	results := make(map[string]map[string]float64, len(samples))
+	for deviceID, deviceSamples := range samples {
+		values := map[string]float64{}
+		for _, def := range defs {
+			if !def.Matches(deviceID) {
+				continue
+			}
+			if def.Kind == KindFormula {
+				if v, err := def.Formula.Eval(values); err == nil {
+					values[def.Name] = v
+				}
+				continue
+			}
+			if v, ok := Compute(def, deviceSamples, w, expectedInterval); ok {
+				values[def.Name] = v
+			}
+		}
+		if len(values) > 0 {
+			results[deviceID] = values
+		}
+	}
+	return results
+}
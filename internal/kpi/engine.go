@@ -0,0 +1,278 @@
+package kpi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/jobs"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var logger = logging.For("kpi")
+
+// MaxPreviewWindows bounds how many windows a single preview may evaluate.
+const MaxPreviewWindows = 500
+
+// ErrPreviewTooLong is returned by Preview for a time range of more than MaxPreviewWindows.
+var ErrPreviewTooLong = errors.New("preview spans too many windows")
+
+// Config controls which KPIs are computed and how often.
+type Config struct {
+	Definitions      []Definition
+	Interval         time.Duration // How often the job runs
+	Window           time.Duration // Size of each KPI window
+	Lookback         int           // Number of completed windows recomputed on each run
+	ExpectedInterval time.Duration // How often a healthy device reports, used for uptime
+}
+
+// LoadConfig reads the KPI engine configuration from the environment.
+func LoadConfig() (Config, error) {
+	spec := os.Getenv("KPI_DEFINITIONS")
+	if spec == "" {
+		spec = DefaultDefinitions
+	}
+	defs, err := ParseDefinitions(spec)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{
+		Definitions:      defs,
+		Interval:         time.Duration(envInt("KPI_INTERVAL_MINUTES", 5)) * time.Minute,
+		Window:           time.Duration(envInt("KPI_WINDOW_MINUTES", 60)) * time.Minute,
+		Lookback:         envInt("KPI_LOOKBACK_WINDOWS", 2),
+		ExpectedInterval: time.Duration(envInt("KPI_EXPECTED_INTERVAL_SECONDS", 60)) * time.Second,
+	}, nil
+}
+
+// RegisterJob registers the KPI computation with the job scheduler, unless
+// KPI_ENGINE_ENABLED is set to "false".
+func RegisterJob() {
+	if os.Getenv("KPI_ENGINE_ENABLED") == "false" {
+		logger.Info("Engine disabled")
+		return
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		logger.Error("Invalid configuration, engine disabled", "error", err)
+		return
+	}
+
+	jobs.Register(jobs.Job{
+		Name:     "kpi-engine",
+		Interval: cfg.Interval,
+		Timeout:  cfg.Interval,
+		Run: func(ctx context.Context) error {
+			return Run(ctx, cfg, time.Now())
+		},
+	})
+}
+
+// Run computes every configured and user-defined KPI for the last cfg.Lookback
+// completed windows before now and upserts the results. Running it twice for the
+// same windows produces the same documents. Each KPI is stamped with the tenant of
+// its device.
+func Run(ctx context.Context, cfg Config, now time.Time) error {
+	defs, err := LoadDefinitions(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	tenants, err := db.DeviceTenants(ctx)
+	if err != nil {
+		return err
+	}
+
+	for size, targets := range groupByWindow(defs) {
+		eval := WithDependencies(targets, defs)
+		persist := make(map[string]bool, len(targets))
+		for _, def := range targets {
+			persist[def.Name] = true
+		}
+
+		for _, w := range CompletedWindows(now, size, cfg.Lookback) {
+			if err := runWindow(ctx, cfg, eval, persist, tenants, w); err != nil {
+				return fmt.Errorf("window %s: %w", w.Start.Format(time.RFC3339), err)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadDefinitions returns the built-in definitions from cfg followed by every enabled
+// user-defined definition. Invalid stored definitions are logged and skipped.
+func LoadDefinitions(ctx context.Context, cfg Config) ([]Definition, error) {
+	defs := make([]Definition, 0, len(cfg.Definitions))
+	for _, def := range cfg.Definitions {
+		def.Window = cfg.Window
+		defs = append(defs, def)
+	}
+
+	stored, err := db.ListKPIDefinitions(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range stored {
+		def, err := FromModel(m)
+		if err != nil {
+			logger.Warn("Skipping invalid definition", "definition", m.Name, "error", err)
+			continue
+		}
+		defs = append(defs, def)
+	}
+	return defs, nil
+}
+
+// Preview evaluates def over the completed windows between from and to without
+// storing anything. Formula references are resolved against all.
+func Preview(ctx context.Context, cfg Config, def Definition, all []Definition, from, to time.Time) ([]models.KPI, error) {
+	size := def.Window
+	if size <= 0 {
+		size = cfg.Window
+	}
+	start := from.UTC().Truncate(size)
+	if n := int(to.Sub(start) / size); n > MaxPreviewWindows {
+		return nil, fmt.Errorf("%w: %d, the maximum is %d", ErrPreviewTooLong, n, MaxPreviewWindows)
+	}
+
+	eval := WithDependencies([]Definition{def}, all)
+	var results []models.KPI
+	for ws := start; !ws.Add(size).After(to); ws = ws.Add(size) {
+		w := Window{Start: ws, End: ws.Add(size)}
+		samples, err := loadSamples(ctx, w)
+		if err != nil {
+			return nil, err
+		}
+		for deviceID, values := range Evaluate(eval, samples, w, cfg.ExpectedInterval) {
+			value, ok := values[def.Name]
+			if !ok {
+				continue
+			}
+			results = append(results, newKPI(deviceID, def, value, len(samples[deviceID]), w))
+		}
+	}
+	return results, nil
+}
+
+// CompletedWindows returns the last n windows of the given size that ended at or
+// before now, aligned to the size and ordered oldest first.
+func CompletedWindows(now time.Time, size time.Duration, n int) []Window {
+	if size <= 0 || n <= 0 {
+		return nil
+	}
+	end := now.UTC().Truncate(size)
+	windows := make([]Window, n)
+	for i := n - 1; i >= 0; i-- {
+		windows[i] = Window{Start: end.Add(-size), End: end}
+		end = end.Add(-size)
+	}
+	return windows
+}
+
+func groupByWindow(defs []Definition) map[time.Duration][]Definition {
+	groups := make(map[time.Duration][]Definition)
+	for _, def := range defs {
+		groups[def.Window] = append(groups[def.Window], def)
+	}
+	return groups
+}
+
+func runWindow(ctx context.Context, cfg Config, eval []Definition, persist map[string]bool, tenants map[string]string, w Window) error {
+	samples, err := loadSamples(ctx, w)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]Definition, len(eval))
+	for _, def := range eval {
+		byName[def.Name] = def
+	}
+
+	var writes []mongo.WriteModel
+	for deviceID, values := range Evaluate(eval, samples, w, cfg.ExpectedInterval) {
+		for name, value := range values {
+			if !persist[name] {
+				continue
+			}
+			kpi := newKPI(deviceID, byName[name], value, len(samples[deviceID]), w)
+			kpi.TenantID = tenants[deviceID]
+			writes = append(writes, upsertModel(kpi))
+		}
+	}
+
+	if len(writes) == 0 {
+		return nil
+	}
+	_, err = db.KPIsCollection().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return err
+	}
+	logger.Debug("Upserted KPIs", "count", len(writes), "window_start", w.Start.Format(time.RFC3339))
+	return nil
+}
+
+func newKPI(deviceID string, def Definition, value float64, samples int, w Window) models.KPI {
+	return models.KPI{
+		DeviceID:    deviceID,
+		Name:        def.Name,
+		Value:       value,
+		Unit:        def.Unit,
+		Samples:     samples,
+		WindowStart: w.Start,
+		WindowEnd:   w.End,
+		Timestamp:   w.End,
+		ComputedAt:  time.Now().UTC(),
+	}
+}
+
+// upsertModel keys each KPI on device, name and window so recomputation replaces
+// the previous value instead of adding a duplicate.
+func upsertModel(kpi models.KPI) mongo.WriteModel {
+	filter := bson.M{
+		"device_id":    kpi.DeviceID,
+		"name":         kpi.Name,
+		"window_start": kpi.WindowStart,
+	}
+	return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(kpi).SetUpsert(true)
+}
+
+func loadSamples(ctx context.Context, w Window) (map[string][]Sample, error) {
+	filter := bson.M{"timestamp": bson.M{"$gte": w.Start, "$lt": w.End}}
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+
+	cursor, err := db.SensorsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	samples := make(map[string][]Sample)
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		deviceID, sample, ok := SampleFromDocument(doc)
+		if !ok {
+			continue
+		}
+		samples[deviceID] = append(samples[deviceID], sample)
+	}
+	return samples, cursor.Err()
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
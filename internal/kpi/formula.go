@@ -0,0 +1,242 @@
+package kpi
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Formula is a parsed arithmetic expression over other KPI names, e.g.
+// "100 - packet_loss" or "mean_temperature * 1.8 + 32".
+type Formula struct {
+	source string
+	root   node
+}
+
+type node interface {
+	eval(vars map[string]float64) (float64, error)
+	refs(out map[string]bool)
+}
+
+type numberNode float64
+
+type refNode string
+
+type unaryNode struct {
+	op    byte
+	child node
+}
+
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+// ErrMissingReference is returned when a formula references a KPI with no value.
+var ErrMissingReference = errors.New("missing KPI value")
+
+// ParseFormula parses a formula supporting numbers, KPI names, parentheses and
+// the + - * / operators with the usual precedence.
+func ParseFormula(src string) (*Formula, error) {
+	p := &formulaParser{src: src}
+	p.next()
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.text, p.pos)
+	}
+	return &Formula{source: src, root: root}, nil
+}
+
+// String returns the formula source.
+func (f *Formula) String() string {
+	return f.source
+}
+
+// References returns the KPI names used by the formula.
+func (f *Formula) References() []string {
+	set := map[string]bool{}
+	f.root.refs(set)
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Eval evaluates the formula with the given KPI values.
+func (f *Formula) Eval(vars map[string]float64) (float64, error) {
+	return f.root.eval(vars)
+}
+
+func (n numberNode) eval(map[string]float64) (float64, error) { return float64(n), nil }
+func (n numberNode) refs(map[string]bool)                     {}
+
+func (n refNode) eval(vars map[string]float64) (float64, error) {
+	v, ok := vars[string(n)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrMissingReference, string(n))
+	}
+	return v, nil
+}
+func (n refNode) refs(out map[string]bool) { out[string(n)] = true }
+
+func (n unaryNode) eval(vars map[string]float64) (float64, error) {
+	v, err := n.child.eval(vars)
+	return -v, err
+}
+func (n unaryNode) refs(out map[string]bool) { n.child.refs(out) }
+
+func (n binaryNode) eval(vars map[string]float64) (float64, error) {
+	l, err := n.left.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	default:
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return l / r, nil
+	}
+}
+func (n binaryNode) refs(out map[string]bool) {
+	n.left.refs(out)
+	n.right.refs(out)
+}
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokOp
+	tokInvalid
+)
+
+type formulaParser struct {
+	src  string
+	pos  int
+	tok  int
+	text string
+}
+
+func (p *formulaParser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok, p.text = tokEOF, ""
+		return
+	}
+
+	start := p.pos
+	ch := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(ch) || ch == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = tokNumber
+	case unicode.IsLetter(ch) || ch == '_':
+		for p.pos < len(p.src) && (unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '_') {
+			p.pos++
+		}
+		p.tok = tokIdent
+	case ch == '+' || ch == '-' || ch == '*' || ch == '/' || ch == '(' || ch == ')':
+		p.pos++
+		p.tok = tokOp
+	default:
+		p.pos++
+		p.tok = tokInvalid
+	}
+	p.text = p.src[start:p.pos]
+}
+
+// parseExpr handles + and -.
+func (p *formulaParser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == tokOp && (p.text == "+" || p.text == "-") {
+		op := p.text[0]
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseTerm handles * and /.
+func (p *formulaParser) parseTerm() (node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == tokOp && (p.text == "*" || p.text == "/") {
+		op := p.text[0]
+		p.next()
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) parseFactor() (node, error) {
+	switch p.tok {
+	case tokNumber:
+		v, err := strconv.ParseFloat(p.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", p.text)
+		}
+		p.next()
+		return numberNode(v), nil
+	case tokIdent:
+		name := p.text
+		p.next()
+		return refNode(name), nil
+	case tokOp:
+		switch p.text {
+		case "-":
+			p.next()
+			child, err := p.parseFactor()
+			if err != nil {
+				return nil, err
+			}
+			return unaryNode{op: '-', child: child}, nil
+		case "(":
+			p.next()
+			inner, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if p.tok != tokOp || p.text != ")" {
+				return nil, errors.New("missing closing parenthesis")
+			}
+			p.next()
+			return inner, nil
+		}
+	case tokEOF:
+		return nil, errors.New("unexpected end of formula")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", p.text, p.pos)
+}
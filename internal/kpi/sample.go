@@ -0,0 +1,78 @@
+package kpi
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SampleFromDocument converts a raw sensor document into a Sample. Numeric fields are
+// read from the document itself and from its payload, which is decrypted if needed and
+// may be either a JSON string or an embedded document. The "seq" field, when present,
+// is used as the message sequence number.
+func SampleFromDocument(doc bson.M) (string, Sample, bool) {
+	deviceID, _ := doc["device_id"].(string)
+	ts, ok := toTime(doc["timestamp"])
+	if deviceID == "" || !ok {
+		return "", Sample{}, false
+	}
+
+	sample := Sample{Timestamp: ts, Values: map[string]float64{}}
+	collectValues(doc, &sample)
+
+	switch payload := doc["payload"].(type) {
+	case string:
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(utils.DecryptPayloadIfNeeded(payload)), &fields); err == nil {
+			collectValues(fields, &sample)
+		}
+	case bson.M:
+		collectValues(payload, &sample)
+	}
+
+	return deviceID, sample, true
+}
+
+func collectValues(fields map[string]interface{}, sample *Sample) {
+	for key, raw := range fields {
+		v, ok := toFloat(raw)
+		if !ok {
+			continue
+		}
+		if key == "seq" {
+			sample.Seq = int64(v)
+			sample.HasSeq = true
+			continue
+		}
+		sample.Values[key] = v
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case primitive.DateTime:
+		return t.Time(), true
+	case time.Time:
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,117 @@
+package lockout
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Policy controls when repeated failed logins lock an account and for how long.
+type Policy struct {
+	MaxFailures int           // Failures within Window that trigger a lockout
+	Window      time.Duration // Failures older than this no longer count
+	BaseLockout time.Duration // First lockout; each further lockout doubles it
+	MaxLockout  time.Duration // Upper bound, also how long a clean record is remembered
+}
+
+// LoadPolicy reads the lockout policy from the environment.
+func LoadPolicy() Policy {
+	return Policy{
+		MaxFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		Window:      time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		BaseLockout: time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 5)) * time.Minute,
+		MaxLockout:  time.Duration(envInt("LOGIN_LOCKOUT_MAX_MINUTES", 1440)) * time.Minute,
+	}
+}
+
+// LockoutDuration returns how long the n-th consecutive lockout lasts.
+func (p Policy) LockoutDuration(n int) time.Duration {
+	d := p.BaseLockout
+	for i := 1; i < n && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxLockout)
+}
+
+// LockedUntil reports whether the attempt record locks the account at now, and until when.
+func LockedUntil(a *models.LoginAttempt, now time.Time) (time.Time, bool) {
+	if a == nil || a.LockedUntil == nil || !now.Before(*a.LockedUntil) {
+		return time.Time{}, false
+	}
+	return *a.LockedUntil, true
+}
+
+// RecordFailure returns the attempt record after one more failed login at now. Once
+// MaxFailures failures fall inside Window the account is locked, for longer each time
+// it happens again; the escalation is forgotten after MaxLockout without failures.
+func (p Policy) RecordFailure(a *models.LoginAttempt, username string, now time.Time) models.LoginAttempt {
+	next := models.LoginAttempt{Username: username}
+	if a != nil && now.Sub(a.UpdatedAt) < p.MaxLockout {
+		next = *a
+	}
+
+	if next.Failures == 0 || now.Sub(next.FirstFailure) > p.Window {
+		next.Failures = 0
+		next.FirstFailure = now
+	}
+	next.Failures++
+	next.UpdatedAt = now
+
+	if next.Failures >= p.MaxFailures {
+		next.Lockouts++
+		until := now.Add(p.LockoutDuration(next.Lockouts))
+		next.LockedUntil = &until
+		next.Failures = 0
+	}
+	return next
+}
+
+// FailureUpdate returns RecordFailure as a MongoDB update pipeline for the attempt
+// record, so failures counted at the same time, by one instance or several, are
+// each counted instead of overwriting one another.
+func (p Policy) FailureUpdate(now time.Time) bson.A {
+	ms := func(d time.Duration) int64 { return d.Milliseconds() }
+	age := func(field string) bson.M {
+		return bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{field, time.Unix(0, 0)}}}}
+	}
+	known := bson.M{"$lt": bson.A{age("$updated_at"), ms(p.MaxLockout)}}
+	restart := bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{"$failures", 0}},
+		bson.M{"$gt": bson.A{age("$first_failure"), ms(p.Window)}},
+	}}
+	locks := bson.M{"$gte": bson.A{"$failures", p.MaxFailures}}
+	duration := bson.M{"$min": bson.A{
+		bson.M{"$multiply": bson.A{ms(p.BaseLockout), bson.M{"$pow": bson.A{2, bson.M{"$subtract": bson.A{"$lockouts", 1}}}}}},
+		ms(p.MaxLockout),
+	}}
+
+	return bson.A{
+		// A record untouched for MaxLockout starts over
+		bson.M{"$set": bson.M{
+			"failures":      bson.M{"$cond": bson.A{known, bson.M{"$ifNull": bson.A{"$failures", 0}}, 0}},
+			"lockouts":      bson.M{"$cond": bson.A{known, bson.M{"$ifNull": bson.A{"$lockouts", 0}}, 0}},
+			"first_failure": bson.M{"$cond": bson.A{known, "$first_failure", now}},
+			"locked_until":  bson.M{"$cond": bson.A{known, "$locked_until", "$$REMOVE"}},
+		}},
+		bson.M{"$set": bson.M{
+			"failures":      bson.M{"$cond": bson.A{restart, 1, bson.M{"$add": bson.A{"$failures", 1}}}},
+			"first_failure": bson.M{"$cond": bson.A{restart, now, "$first_failure"}},
+			"updated_at":    now,
+		}},
+		bson.M{"$set": bson.M{"lockouts": bson.M{"$cond": bson.A{locks, bson.M{"$add": bson.A{"$lockouts", 1}}, "$lockouts"}}}},
+		bson.M{"$set": bson.M{
+			"locked_until": bson.M{"$cond": bson.A{locks, bson.M{"$add": bson.A{now, duration}}, "$locked_until"}},
+			"failures":     bson.M{"$cond": bson.A{locks, 0, "$failures"}},
+		}},
+	}
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
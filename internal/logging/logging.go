@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// config is swapped atomically by Setup. Loggers created earlier, e.g. in package-level
+// vars before .env is loaded, pick up the new settings on their next record.
+type config struct {
+	handler slog.Handler
+	level   slog.Level
+	levels  map[string]slog.Level
+}
+
+var current atomic.Pointer[config]
+
+func init() {
+	current.Store(&config{handler: slog.NewJSONHandler(os.Stdout, nil), level: slog.LevelInfo})
+}
+
+type requestIDKey struct{}
+
+// Setup configures logging from the environment:
+//
+//	LOG_FORMAT  json (default) or text
+//	LOG_LEVEL   debug, info (default), warn or error
+//	LOG_LEVELS  per-subsystem overrides, e.g. "mqtt=debug,http=warn"
+//
+// It also routes the standard log package through slog so third-party output stays structured.
+func Setup() {
+	setup(os.Stdout, os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"), os.Getenv("LOG_LEVELS"))
+}
+
+func setup(w io.Writer, format, level, overrides string) {
+	cfg := &config{level: parseLevel(level, slog.LevelInfo), levels: map[string]slog.Level{}}
+
+	// The handler itself lets everything through; levels are enforced per subsystem
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if strings.EqualFold(format, "text") {
+		cfg.handler = slog.NewTextHandler(w, opts)
+	} else {
+		cfg.handler = slog.NewJSONHandler(w, opts)
+	}
+
+	for _, pair := range strings.Split(overrides, ",") {
+		name, lvl, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			continue
+		}
+		cfg.levels[strings.ToLower(name)] = parseLevel(lvl, cfg.level)
+	}
+
+	current.Store(cfg)
+	slog.SetDefault(For("app"))
+	log.SetFlags(0)
+}
+
+func parseLevel(s string, fallback slog.Level) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return fallback
+	}
+	return level
+}
+
+// For returns the logger of a subsystem. Every record carries a "subsystem" attribute
+// and is filtered by that subsystem's level.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem})
+}
+
+// WithRequestID returns a context whose log records carry the given request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID stored in ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type handler struct {
+	subsystem string
+	with      []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	cfg := current.Load()
+	if l, ok := cfg.levels[h.subsystem]; ok {
+		return level >= l
+	}
+	return level >= cfg.level
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	next := current.Load().handler.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+	for _, fn := range h.with {
+		next = fn(next)
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.extend(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *handler) extend(fn func(slog.Handler) slog.Handler) slog.Handler {
+	with := append(append([]func(slog.Handler) slog.Handler(nil), h.with...), fn)
+	return &handler{subsystem: h.subsystem, with: with}
+}
+
+// Fatal logs at error level and exits, replacing log.Fatalf for startup failures.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
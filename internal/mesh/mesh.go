@@ -0,0 +1,133 @@
+// Package mesh keeps track of the ESP-MESH tree the nodes report: which node is each
+// node's parent, on which layer, with what signal, and which nodes are the roots.
+package mesh
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+var logger = logging.For("mesh")
+
+// Config holds when nodes are considered offline and how often orphans are looked for.
+type Config struct {
+	NodeTimeout   time.Duration // A node not heard from for longer is offline
+	SweepInterval time.Duration
+}
+
+// LoadConfig reads the mesh settings from the environment.
+func LoadConfig() Config {
+	return Config{
+		NodeTimeout:   time.Duration(envInt("MESH_NODE_TIMEOUT_SECONDS", 300)) * time.Second,
+		SweepInterval: time.Duration(envInt("MESH_SWEEP_INTERVAL_SECONDS", 60)) * time.Second,
+	}
+}
+
+// ParseReport reads the mesh position from the "mesh" object of a reading, e.g.
+// {"device_id":"esp32-07","mesh":{"parent":"esp32-02","layer":3,"rssi":-67}}. It reports
+// false for readings without one.
+func ParseReport(deviceID string, payload []byte, at time.Time) (models.MeshReport, bool) {
+	var doc struct {
+		Mesh *struct {
+			MeshID string `json:"mesh_id"`
+			Parent string `json:"parent"`
+			Layer  int    `json:"layer"`
+			RSSI   int    `json:"rssi"`
+		} `json:"mesh"`
+	}
+	if deviceID == "" || json.Unmarshal(payload, &doc) != nil || doc.Mesh == nil || doc.Mesh.Layer < 1 {
+		return models.MeshReport{}, false
+	}
+	report := models.MeshReport{
+		DeviceID: deviceID,
+		MeshID:   doc.Mesh.MeshID,
+		ParentID: doc.Mesh.Parent,
+		Layer:    doc.Mesh.Layer,
+		RSSI:     doc.Mesh.RSSI,
+		Time:     at,
+	}
+	if report.Layer == 1 {
+		report.ParentID = ""
+	}
+	return report, true
+}
+
+// Build returns the topology graph of the nodes at the given time. A node is offline
+// when it has not reported within timeout, and orphaned when it still reports but its
+// chain of parents does not reach an online root of the same mesh.
+func Build(nodes []models.MeshNode, now time.Time, timeout time.Duration) models.MeshTopology {
+	byID := make(map[string]models.MeshNode, len(nodes))
+	for _, n := range nodes {
+		byID[n.DeviceID] = n
+	}
+	online := func(n models.MeshNode) bool {
+		return now.Sub(n.LastSeen) <= timeout
+	}
+
+	// connected caches whether a node's chain reaches a root; nodes on a loop are not
+	connected := map[string]bool{}
+	var reachesRoot func(n models.MeshNode, visiting map[string]bool) bool
+	reachesRoot = func(n models.MeshNode, visiting map[string]bool) bool {
+		if ok, done := connected[n.DeviceID]; done {
+			return ok
+		}
+		ok := false
+		if n.Root() {
+			ok = true
+		} else if parent, known := byID[n.ParentID]; known && !visiting[n.DeviceID] &&
+			parent.MeshID == n.MeshID && online(parent) {
+			visiting[n.DeviceID] = true
+			ok = reachesRoot(parent, visiting)
+		}
+		connected[n.DeviceID] = ok
+		return ok
+	}
+
+	topo := models.MeshTopology{
+		Nodes:       make([]models.MeshTopologyNode, 0, len(nodes)),
+		Edges:       []models.MeshEdge{},
+		Roots:       []string{},
+		GeneratedAt: now,
+	}
+	for _, n := range nodes {
+		status := models.MeshOnline
+		switch {
+		case !online(n):
+			status = models.MeshOffline
+		case !reachesRoot(n, map[string]bool{}):
+			status = models.MeshOrphaned
+		}
+		topo.Nodes = append(topo.Nodes, models.MeshTopologyNode{MeshNode: n, Status: status, Root: n.Root()})
+
+		if n.Root() && status == models.MeshOnline {
+			topo.Roots = append(topo.Roots, n.DeviceID)
+		}
+		if _, known := byID[n.ParentID]; known && !n.Root() {
+			topo.Edges = append(topo.Edges, models.MeshEdge{Source: n.DeviceID, Target: n.ParentID, RSSI: n.RSSI})
+		}
+	}
+
+	sort.Slice(topo.Nodes, func(i, j int) bool {
+		a, b := topo.Nodes[i], topo.Nodes[j]
+		if a.Layer != b.Layer {
+			return a.Layer < b.Layer
+		}
+		return a.DeviceID < b.DeviceID
+	})
+	sort.Slice(topo.Edges, func(i, j int) bool { return topo.Edges[i].Source < topo.Edges[j].Source })
+	sort.Strings(topo.Roots)
+	return topo
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,315 @@
+package mesh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/jobs"
+	"github.com/rednexx46/esp32-backend-api/internal/metrics"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TouchInterval is how often a node whose place in the tree has not changed gets its
+// signal strength and last report time written, so a 200-node mesh reporting every few
+// seconds does not turn into a write per reading.
+const TouchInterval = time.Minute
+
+// Store persists the current topology and its history.
+type Store interface {
+	ListNodes(ctx context.Context) ([]models.MeshNode, error)
+	GetNode(ctx context.Context, deviceID string) (*models.MeshNode, error)
+	// ApplyNode stores the node's new place in the tree if it is still at prev, or new
+	// when prev is nil, and reports whether it did.
+	ApplyNode(ctx context.Context, node models.MeshNode, prev *models.MeshNode) (bool, error)
+	TouchNode(ctx context.Context, deviceID string, rssi int, at time.Time) error
+	// SetOrphaned marks or, with a nil time, unmarks the node as orphaned, and reports
+	// whether it changed.
+	SetOrphaned(ctx context.Context, deviceID string, at *time.Time) (bool, error)
+	RecordEvent(ctx context.Context, event models.MeshEvent) error
+}
+
+// MongoStore keeps the topology in the mesh_nodes and mesh_events collections.
+type MongoStore struct{}
+
+func (MongoStore) ListNodes(ctx context.Context) ([]models.MeshNode, error) {
+	return db.ListMeshNodes(ctx, bson.M{})
+}
+
+func (MongoStore) GetNode(ctx context.Context, deviceID string) (*models.MeshNode, error) {
+	return db.GetMeshNode(ctx, deviceID)
+}
+
+func (MongoStore) ApplyNode(ctx context.Context, node models.MeshNode, prev *models.MeshNode) (bool, error) {
+	return db.ApplyMeshNode(ctx, node, prev)
+}
+
+func (MongoStore) TouchNode(ctx context.Context, deviceID string, rssi int, at time.Time) error {
+	return db.TouchMeshNode(ctx, deviceID, rssi, at)
+}
+
+func (MongoStore) SetOrphaned(ctx context.Context, deviceID string, at *time.Time) (bool, error) {
+	return db.SetMeshNodeOrphaned(ctx, deviceID, at)
+}
+
+func (MongoStore) RecordEvent(ctx context.Context, event models.MeshEvent) error {
+	return db.InsertMeshEvent(ctx, event)
+}
+
+// LoadRetryInterval is how long the tracker waits after failing to load the topology
+// before trying again. Reports arriving meanwhile are skipped; nodes report again
+// within seconds.
+const LoadRetryInterval = 30 * time.Second
+
+// Tracker records the mesh positions nodes report. It remembers the last position of
+// every node so only changes reach the store. Several instances may share a store:
+// a change is applied, and its event recorded, by whichever instance sees it first.
+// The lock only guards the cache, never store I/O, so reports of different nodes are
+// handled concurrently.
+type Tracker struct {
+	store Store
+
+	mu      sync.Mutex
+	loaded  bool
+	retryAt time.Time // When to try loading again, while loading or after a failure
+	nodes   map[string]models.MeshNode
+	touched map[string]time.Time // When each node's report was last written
+}
+
+// NewTracker returns a Tracker keeping the topology in store.
+func NewTracker(store Store) *Tracker {
+	return &Tracker{store: store, nodes: map[string]models.MeshNode{}, touched: map[string]time.Time{}}
+}
+
+// Observe records a node's report. A node that is new or has moved in the tree is
+// written at once with a node.joined or node.parent_change event, and a root.change
+// event when it became the root of its mesh; otherwise the report is written at most
+// once per TouchInterval.
+func (t *Tracker) Observe(ctx context.Context, r models.MeshReport) {
+	if !t.load(ctx) {
+		return
+	}
+
+	node := models.MeshNode{
+		DeviceID:  r.DeviceID,
+		TenantID:  r.TenantID,
+		MeshID:    r.MeshID,
+		ParentID:  r.ParentID,
+		Layer:     r.Layer,
+		RSSI:      r.RSSI,
+		LastSeen:  r.Time,
+		ChangedAt: r.Time,
+	}
+	t.mu.Lock()
+	prev, known := t.nodes[r.DeviceID]
+	if known && prev.SameLink(node) {
+		node.TenantID, node.ChangedAt, node.OrphanedAt = prev.TenantID, prev.ChangedAt, prev.OrphanedAt
+		t.nodes[r.DeviceID] = node
+		lastTouch := t.touched[r.DeviceID]
+		due := r.Time.Sub(lastTouch) >= TouchInterval
+		if due {
+			t.touched[r.DeviceID] = r.Time // Claimed now, so concurrent reports do not write it too
+		}
+		t.mu.Unlock()
+		if !due {
+			return
+		}
+		if err := t.store.TouchNode(ctx, r.DeviceID, r.RSSI, r.Time); err != nil {
+			logger.Warn("Failed to update mesh node", "device_id", r.DeviceID, "error", err)
+			t.mu.Lock()
+			if t.touched[r.DeviceID].Equal(r.Time) {
+				t.touched[r.DeviceID] = lastTouch
+			}
+			t.mu.Unlock()
+		}
+		return
+	}
+	t.mu.Unlock()
+
+	var prevNode *models.MeshNode
+	if known {
+		prevNode = &prev
+	}
+	// The store applies the change only if the node is still at prev, so of concurrent
+	// reports of the same move only one records it
+	applied, err := t.store.ApplyNode(ctx, node, prevNode)
+	if err != nil {
+		logger.Warn("Failed to update mesh node", "device_id", r.DeviceID, "error", err)
+		return
+	}
+	if !applied {
+		// Another report or instance recorded the change; pick up what it wrote
+		current, err := t.store.GetNode(ctx, r.DeviceID)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if err != nil {
+			logger.Warn("Failed to reload mesh node", "device_id", r.DeviceID, "error", err)
+			delete(t.nodes, r.DeviceID)
+			return
+		}
+		t.nodes[r.DeviceID] = *current
+		t.touched[r.DeviceID] = current.LastSeen
+		return
+	}
+
+	t.mu.Lock()
+	previousRoot := t.root(node)
+	t.nodes[r.DeviceID] = node
+	t.touched[r.DeviceID] = r.Time
+	t.mu.Unlock()
+
+	for _, event := range changeEvents(prevNode, node, previousRoot) {
+		if err := t.store.RecordEvent(ctx, event); err != nil {
+			logger.Warn("Failed to record mesh event", "type", event.Type, "device_id", event.DeviceID, "error", err)
+		}
+		logEvent(event)
+	}
+}
+
+// load fills the cache from the store on first use and reports whether it is filled.
+// Only one report loads at a time, and after a failure the next try waits
+// LoadRetryInterval.
+func (t *Tracker) load(ctx context.Context) bool {
+	t.mu.Lock()
+	if t.loaded || time.Now().Before(t.retryAt) {
+		defer t.mu.Unlock()
+		return t.loaded
+	}
+	t.retryAt = time.Now().Add(LoadRetryInterval)
+	t.mu.Unlock()
+
+	nodes, err := t.store.ListNodes(ctx)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		logger.Warn("Failed to load mesh topology", "error", err, "retry_in", LoadRetryInterval)
+		t.retryAt = time.Now().Add(LoadRetryInterval)
+		return false
+	}
+	for _, n := range nodes {
+		t.nodes[n.DeviceID] = n
+	}
+	t.loaded = true
+	return true
+}
+
+// root returns the most recently seen root of the node's mesh other than the node.
+func (t *Tracker) root(node models.MeshNode) string {
+	var root models.MeshNode
+	for _, n := range t.nodes {
+		if n.Root() && n.DeviceID != node.DeviceID && n.TenantID == node.TenantID && n.MeshID == node.MeshID &&
+			n.LastSeen.After(root.LastSeen) {
+			root = n
+		}
+	}
+	return root.DeviceID
+}
+
+// changeEvents returns the events for a node moving from prev, nil when it is new, to
+// node. previousRoot is the root the node's mesh had before.
+func changeEvents(prev *models.MeshNode, node models.MeshNode, previousRoot string) []models.MeshEvent {
+	event := models.MeshEvent{
+		Time:     node.ChangedAt,
+		Type:     models.MeshNodeJoined,
+		TenantID: node.TenantID,
+		MeshID:   node.MeshID,
+		DeviceID: node.DeviceID,
+		ParentID: node.ParentID,
+		Layer:    node.Layer,
+	}
+	if prev != nil {
+		event.Type = models.MeshParentChange
+		event.PreviousParentID = prev.ParentID
+	}
+	events := []models.MeshEvent{event}
+
+	if node.Root() && (prev == nil || !prev.Root() || prev.MeshID != node.MeshID) {
+		rootEvent := event
+		rootEvent.Type = models.MeshRootChange
+		rootEvent.PreviousParentID = ""
+		rootEvent.PreviousRoot = previousRoot
+		events = append(events, rootEvent)
+	}
+	return events
+}
+
+func logEvent(event models.MeshEvent) {
+	attrs := []any{"device_id", event.DeviceID, "tenant_id", event.TenantID, "mesh_id", event.MeshID}
+	switch event.Type {
+	case models.MeshRootChange:
+		if event.PreviousRoot != "" {
+			logger.Warn("Mesh root changed", append(attrs, "previous_root", event.PreviousRoot)...)
+			metrics.MeshRootChanged()
+			return
+		}
+		logger.Info("Mesh root elected", attrs...)
+	case models.MeshNodeOrphaned:
+		logger.Warn("Mesh node orphaned", append(attrs, "parent_id", event.ParentID)...)
+	case models.MeshParentChange:
+		logger.Info("Mesh node changed parent", append(attrs, "parent_id", event.ParentID,
+			"previous_parent_id", event.PreviousParentID, "layer", event.Layer)...)
+	default:
+		logger.Info("Mesh node joined", append(attrs, "parent_id", event.ParentID, "layer", event.Layer)...)
+	}
+}
+
+// Sweep looks for nodes that became orphaned since the last sweep and records a
+// node.orphaned event for each, clears the mark on nodes that reconnected, and updates
+// the node count metrics.
+func (t *Tracker) Sweep(ctx context.Context, now time.Time, timeout time.Duration) error {
+	nodes, err := t.store.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+	topo := Build(nodes, now, timeout)
+
+	counts := map[string]int{models.MeshOnline: 0, models.MeshOffline: 0, models.MeshOrphaned: 0}
+	for _, n := range topo.Nodes {
+		counts[n.Status]++
+		switch {
+		case n.Status == models.MeshOrphaned && n.OrphanedAt == nil:
+			marked, err := t.store.SetOrphaned(ctx, n.DeviceID, &now)
+			if err != nil {
+				return err
+			}
+			if !marked {
+				continue
+			}
+			event := models.MeshEvent{
+				Time:     now,
+				Type:     models.MeshNodeOrphaned,
+				TenantID: n.TenantID,
+				MeshID:   n.MeshID,
+				DeviceID: n.DeviceID,
+				ParentID: n.ParentID,
+				Layer:    n.Layer,
+			}
+			if err := t.store.RecordEvent(ctx, event); err != nil {
+				return err
+			}
+			logEvent(event)
+		case n.Status != models.MeshOrphaned && n.OrphanedAt != nil:
+			if _, err := t.store.SetOrphaned(ctx, n.DeviceID, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for status, n := range counts {
+		metrics.MeshNodes(status, n)
+	}
+	return nil
+}
+
+// RegisterJob registers the orphan sweep with the job scheduler.
+func RegisterJob(t *Tracker, cfg Config) {
+	jobs.Register(jobs.Job{
+		Name:     "mesh-sweep",
+		Interval: cfg.SweepInterval,
+		Timeout:  cfg.SweepInterval,
+		Run: func(ctx context.Context) error {
+			return t.Sweep(ctx, time.Now(), cfg.NodeTimeout)
+		},
+	})
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "esp32"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	mqttMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_received_total",
+		Help:      "MQTT messages received by topic prefix.",
+	}, []string{"topic_prefix"})
+
+	mqttDecodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_decode_failures_total",
+		Help:      "MQTT messages whose payload could not be decoded, by topic prefix.",
+	}, []string{"topic_prefix"})
+
+	wsClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_connected_clients",
+		Help:      "WebSocket clients currently connected.",
+	})
+
+	wsDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_dropped_messages_total",
+		Help:      "Messages that could not be delivered to a WebSocket client.",
+	})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_duration_seconds",
+		Help:      "MongoDB command latency by command name and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"command", "status"})
+
+	decryptCalls = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decrypt_requests_total",
+		Help:      "Payloads sent to the cipher API for decryption.",
+	})
+
+	decryptFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decrypt_failures_total",
+		Help:      "Payloads the cipher API failed to decrypt.",
+	})
+
+	meshNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mesh_nodes",
+		Help:      "Mesh nodes by status (online, offline or orphaned) at the last sweep.",
+	}, []string{"status"})
+
+	meshRootChanges = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mesh_root_changes_total",
+		Help:      "Times a mesh elected a different root node.",
+	})
+)
+
+// GinMiddleware records the count and latency of every request. Routes are labelled
+// with their template (e.g. /api/data/:device_id) to keep cardinality bounded.
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// TopicPrefix reduces a topic to its first two levels, e.g. mesh/data/node-1 becomes mesh/data.
+func TopicPrefix(topic string) string {
+	parts := strings.SplitN(topic, "/", 3)
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.Join(parts, "/")
+}
+
+// MQTTMessageReceived counts a message received on topic.
+func MQTTMessageReceived(topic string) {
+	mqttMessages.WithLabelValues(TopicPrefix(topic)).Inc()
+}
+
+// MQTTDecodeFailed counts a message on topic whose payload could not be decoded.
+func MQTTDecodeFailed(topic string) {
+	mqttDecodeFailures.WithLabelValues(TopicPrefix(topic)).Inc()
+}
+
+// WSClientConnected and WSClientDisconnected track the connected WebSocket clients.
+func WSClientConnected()    { wsClients.Inc() }
+func WSClientDisconnected() { wsClients.Dec() }
+
+// WSMessageDropped counts a message that could not be written to a client.
+func WSMessageDropped() { wsDropped.Inc() }
+
+// DecryptCalled counts a decryption request, and a failure when ok is false.
+func DecryptCalled(ok bool) {
+	decryptCalls.Inc()
+	if !ok {
+		decryptFailures.Inc()
+	}
+}
+
+// MeshNodes sets the number of mesh nodes with the given status.
+func MeshNodes(status string, n int) { meshNodes.WithLabelValues(status).Set(float64(n)) }
+
+// MeshRootChanged counts a mesh electing a different root.
+func MeshRootChanged() { meshRootChanges.Inc() }
+
+// MongoMonitor returns a command monitor that records the latency of every MongoDB command.
+func MongoMonitor() *event.CommandMonitor {
+	var started sync.Map // request ID -> command name
+
+	finish := func(requestID int64, duration time.Duration, status string) {
+		name, ok := started.LoadAndDelete(requestID)
+		if !ok {
+			return
+		}
+		mongoDuration.WithLabelValues(name.(string), status).Observe(duration.Seconds())
+	}
+
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			started.Store(e.RequestID, e.CommandName)
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			finish(e.RequestID, e.Duration, "success")
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finish(e.RequestID, e.Duration, "failure")
+		},
+	}
+}
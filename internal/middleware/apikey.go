@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+)
+
+var authLogger = logging.For("auth")
+
+// lastUsedResolution limits last_used_at writes to one per key per minute.
+const lastUsedResolution = time.Minute
+
+// extractAPIKey returns the API key sent in X-API-Key, or as a Bearer token.
+func extractAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if token := extractToken(c); strings.HasPrefix(token, utils.APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// authenticateAPIKey verifies the key and sets the same context values as a JWT, plus
+// "scopes" and "api_key_id". The role and device groups are the service account's,
+// so they follow changes to the account; scopes can only narrow them.
+func authenticateAPIKey(c *gin.Context, key string) {
+	ctx := c.Request.Context()
+
+	keyID, ok := utils.ParseAPIKey(key)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Malformed API key"})
+		return
+	}
+
+	stored, err := db.FindAPIKeyByKeyID(ctx, keyID)
+	if err != nil && !errors.Is(err, db.ErrAPIKeyNotFound) {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API key"})
+		return
+	}
+	now := time.Now()
+	if err != nil || !utils.VerifyAPIKey(key, stored.Hash) || !stored.Active(now) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		return
+	}
+
+	owner, err := db.FindUserByUsername(ctx, stored.ServiceAccount)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API key owner no longer exists"})
+		return
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= lastUsedResolution {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := db.TouchAPIKey(ctx, stored.ID, now.UTC()); err != nil {
+				authLogger.Warn("Failed to record API key use", "key_id", keyID, "error", err)
+			}
+		}()
+	}
+
+	c.Set("username", owner.Username)
+	c.Set("role", owner.Role)
+	c.Set(TenantKey, owner.TenantID)
+	c.Set("scopes", stored.Scopes)
+	c.Set("api_key_id", stored.ID.Hex())
+	c.Next()
+}
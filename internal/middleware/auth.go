@@ -1,19 +1,22 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
-
-// JWTMiddleware verifies the JWT token and sets user info in context
+// JWTMiddleware verifies the JWT token and sets user info in context. Service accounts
+// may instead send an API key, in X-API-Key or as the Bearer token.
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if key := extractAPIKey(c); key != "" {
+			authenticateAPIKey(c, key)
+			return
+		}
+
 		tokenString := extractToken(c)
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or malformed token"})
@@ -21,29 +24,30 @@ func JWTMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := AuthenticateToken(c.Request.Context(), tokenString)
+		if errors.Is(err, ErrSessionRevoked) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session revoked or expired"})
+			c.Abort()
+			return
+		}
+		if errors.Is(err, ErrInvalidToken) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || claims["username"] == nil || claims["role"] == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
 			c.Abort()
 			return
 		}
 
 		// Add user info to context
-		c.Set("username", claims["username"])
-		c.Set("role", claims["role"])
+		c.Set("username", claims.Username)
+		c.Set("role", claims.Role)
+		c.Set(TenantKey, claims.TenantID)
+		if claims.ID != "" {
+			c.Set("session_id", claims.ID)
+		}
 		c.Next()
 	}
 }
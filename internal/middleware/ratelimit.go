@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/ratelimit"
+)
+
+var rateLimitLogger = logging.For("ratelimit")
+
+// RateLimit limits requests per principal: the API key when one is sent, otherwise the
+// authenticated user, otherwise the client IP. The default rule applies across all
+// routes and a route rule, if configured, additionally limits that route. It sets the
+// X-RateLimit-* headers from the most restrictive rule and answers 429 with Retry-After.
+// It must run after JWTMiddleware so the user is known.
+func RateLimit(cfg ratelimit.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !cfg.Enabled {
+			c.Next()
+			return
+		}
+
+		principal := rateLimitPrincipal(c)
+		ctx := c.Request.Context()
+		now := time.Now()
+
+		res, err := cfg.Store.Take(ctx, principal, cfg.Default.N, cfg.Default.Window, now)
+		if err != nil {
+			// A shared store outage must not take the API down with it
+			rateLimitLogger.ErrorContext(ctx, "Rate limit store failed, allowing request", "error", err)
+			c.Next()
+			return
+		}
+
+		if rule, ok := cfg.RouteRule(c.Request.Method, c.FullPath()); ok && res.Allowed {
+			key := principal + "|" + c.Request.Method + " " + c.FullPath()
+			routeRes, err := cfg.Store.Take(ctx, key, rule.N, rule.Window, now)
+			if err != nil {
+				rateLimitLogger.ErrorContext(ctx, "Rate limit store failed, allowing request", "error", err)
+			} else if !routeRes.Allowed || routeRes.Remaining < res.Remaining {
+				res = routeRes
+			}
+		}
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			rateLimitLogger.WarnContext(ctx, "Rate limit exceeded", "principal", principal, "path", c.FullPath())
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// rateLimitPrincipal identifies who a request counts against. Each API key has its own
+// budget, separate from its service account's other keys.
+func rateLimitPrincipal(c *gin.Context) string {
+	if id := c.GetString("api_key_id"); id != "" {
+		return "key:" + id
+	}
+	if username := c.GetString("username"); username != "" {
+		return "user:" + username
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceScopeKey is the context key holding the rbac.Scope of the request.
+const DeviceScopeKey = "device_scope"
+
+// TenantKey is the context key holding the caller's tenant ID, empty for platform users.
+const TenantKey = "tenant_id"
+
+// RequirePermission ensures that the request's role grants the permission and, for API
+// keys, that the key's scopes include it.
+func RequirePermission(perm rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed := rbac.Has(c.GetString("role"), perm)
+		if scopes, ok := c.Get("scopes"); ok && allowed {
+			allowed = slices.Contains(scopes.([]string), string(perm))
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + string(perm)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// DeviceScope resolves the devices the user may see from their tenant and device groups
+// and stores the result under DeviceScopeKey. Groups and tenant devices are read from the
+// database on every request, so changes apply without waiting for tokens to expire.
+func DeviceScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope, _, err := ResolveScope(c.Request.Context(), c.GetString("username"), c.GetString("role"), c.GetString(TenantKey))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve device access"})
+			return
+		}
+		c.Set(DeviceScopeKey, scope)
+		c.Next()
+	}
+}
+
+// ResolveScope returns the devices the user of the tenant may see, and whether the user
+// may see every device of the tenant, from their role and device groups.
+func ResolveScope(ctx context.Context, username, role, tenantID string) (rbac.Scope, bool, error) {
+	allDevices := rbac.Has(role, rbac.DevicesAll)
+
+	var groups []string
+	if !allDevices {
+		// Callers without a users entry, such as service tokens, get no devices
+		if user, err := db.FindUserByUsername(ctx, username); err == nil {
+			groups = user.DeviceGroups
+		}
+		allDevices = slices.Contains(groups, rbac.AllGroups)
+	}
+
+	if allDevices && tenantID == "" {
+		return rbac.Scope{All: true}, true, nil
+	}
+
+	var ids []string
+	var err error
+	if allDevices {
+		ids, err = db.DeviceIDsInTenant(ctx, tenantID)
+	} else {
+		ids, err = db.DeviceIDsInGroups(ctx, tenantID, groups)
+	}
+	if err != nil {
+		return rbac.Scope{}, false, err
+	}
+	return rbac.Scope{DeviceIDs: ids, TenantID: tenantID}, allDevices, nil
+}
+
+// SelectDevices narrows the request's device scope to the devices picked by the group,
+// tag and location query parameters, each of which may be repeated. It must run after
+// DeviceScope. Without selectors the scope is left as it is.
+func SelectDevices() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sel := models.DeviceSelector{Groups: c.QueryArray("group"), Tags: c.QueryArray("tag")}
+		for _, v := range c.QueryArray("location") {
+			id, err := primitive.ObjectIDFromHex(v)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID " + v})
+				return
+			}
+			sel.Locations = append(sel.Locations, id)
+		}
+		if sel.Empty() {
+			c.Next()
+			return
+		}
+
+		scope, _ := c.Get(DeviceScopeKey)
+		current, _ := scope.(rbac.Scope)
+		ids, err := db.SelectDeviceIDs(c.Request.Context(), current.TenantID, sel)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve device selection"})
+			return
+		}
+		c.Set(DeviceScopeKey, current.Narrow(ids))
+		c.Next()
+	}
+}
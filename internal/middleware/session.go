@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+)
+
+// ErrInvalidToken is returned for a token that fails verification.
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// ErrSessionRevoked is returned for a token whose session was revoked or has expired.
+var ErrSessionRevoked = errors.New("session revoked or expired")
+
+// lastSeenResolution limits last_seen_at writes to one per session per minute.
+const lastSeenResolution = time.Minute
+
+// AuthenticateToken verifies an access token and, when it belongs to a session, that the
+// session is still active. The HTTP middleware and the WebSocket endpoint both use it.
+func AuthenticateToken(ctx context.Context, tokenString string) (*utils.AccessClaims, error) {
+	claims, err := utils.ParseToken(tokenString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.ID == "" {
+		return claims, nil // Issued without a session by older versions of token issue
+	}
+
+	session, err := db.GetSession(ctx, claims.ID)
+	if errors.Is(err, db.ErrSessionNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if !session.Active(now) || session.Username != claims.Username {
+		return nil, ErrSessionRevoked
+	}
+
+	if session.LastSeenAt == nil || now.Sub(*session.LastSeenAt) >= lastSeenResolution {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := db.TouchSession(ctx, session.ID, now.UTC()); err != nil {
+				authLogger.Warn("Failed to record session use", "session_id", session.ID, "error", err)
+			}
+		}()
+	}
+	return claims, nil
+}
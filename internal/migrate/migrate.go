@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var logger = logging.For("migrate")
+
+// Migration is a versioned, one-off change to the database schema or data.
+// Versions must be unique and are applied in ascending order.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, database *mongo.Database) error
+}
+
+// Record is what the runner stores in the migrations collection once a migration has run.
+type Record struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+	Duration  int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// Status describes one known migration and whether it has been applied.
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+func collection() *mongo.Collection {
+	return db.GetMongoClient().Database(db.DBName).Collection(db.CollectionName("MONGO_MIGRATIONS_COLLECTION", "migrations"))
+}
+
+func sorted() []Migration {
+	list := append([]Migration(nil), migrations...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+func applied(ctx context.Context) (map[int]Record, error) {
+	cursor, err := collection().Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]Record, len(records))
+	for _, r := range records {
+		byVersion[r.Version] = r
+	}
+	return byVersion, nil
+}
+
+// Up applies every pending migration in version order and returns how many ran.
+// It stops at the first failure; the failed migration is not recorded and will be
+// retried on the next run, so migrations must be safe to re-run after a partial failure.
+func Up(ctx context.Context) (int, error) {
+	done, err := applied(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	database := db.GetMongoClient().Database(db.DBName)
+	count := 0
+	for _, m := range sorted() {
+		if _, ok := done[m.Version]; ok {
+			continue
+		}
+
+		logger.Info("Applying migration", "version", m.Version, "name", m.Name)
+		start := time.Now()
+		if err := m.Up(ctx, database); err != nil {
+			return count, fmt.Errorf("migration %d_%s failed: %w", m.Version, m.Name, err)
+		}
+
+		record := Record{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: time.Now().UTC(),
+			Duration:  time.Since(start).Milliseconds(),
+		}
+		if _, err := collection().InsertOne(ctx, record); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return count, errors.New("another instance applied migrations concurrently")
+			}
+			return count, err
+		}
+		count++
+	}
+
+	if count == 0 {
+		logger.Info("Database is up to date")
+	} else {
+		logger.Info("Applied migrations", "count", count)
+	}
+	return count, nil
+}
+
+// GetStatus lists every known migration along with when it was applied.
+func GetStatus(ctx context.Context) ([]Status, error) {
+	done, err := applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, m := range sorted() {
+		s := Status{Version: m.Version, Name: m.Name}
+		if r, ok := done[m.Version]; ok {
+			appliedAt := r.AppliedAt
+			s.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,332 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/retention"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations is the full, append-only history. Never edit or renumber an entry that
+// has shipped; add a new one instead.
+var migrations = []Migration{
+	{Version: 1, Name: "sensor_and_kpi_indexes", Up: createQueryIndexes},
+	{Version: 2, Name: "unique_username", Up: createUniqueUsername},
+	{Version: 3, Name: "backfill_user_fields", Up: backfillUserFields},
+	{Version: 4, Name: "retention_ttl_indexes", Up: createTTLIndexes},
+	{Version: 5, Name: "login_attempts_ttl", Up: createLoginAttemptsTTL},
+	{Version: 6, Name: "rate_limits_ttl", Up: createRateLimitsTTL},
+	{Version: 7, Name: "api_key_indexes", Up: createAPIKeyIndexes},
+	{Version: 8, Name: "external_identity_index", Up: createExternalIdentityIndex},
+	{Version: 9, Name: "session_indexes", Up: createSessionIndexes},
+	{Version: 10, Name: "audit_indexes", Up: createAuditIndexes},
+	{Version: 11, Name: "tenant_indexes", Up: createTenantIndexes},
+	{Version: 12, Name: "location_indexes", Up: createLocationIndexes},
+	{Version: 13, Name: "mesh_indexes", Up: createMeshIndexes},
+	{Version: 14, Name: "provisioning_indexes", Up: createProvisioningIndexes},
+	{Version: 15, Name: "pending_claim_device_index", Up: createPendingClaimIndex},
+}
+
+// createQueryIndexes backs the device_id and time range filters used by the sensor,
+// KPI and rollup queries, and the keys the KPI engine and rollup job upsert on.
+func createQueryIndexes(ctx context.Context, database *mongo.Database) error {
+	sensors := db.SensorsCollection()
+	kpis := db.KPIsCollection()
+	definitions := database.Collection(db.CollectionName("MONGO_KPI_DEFINITIONS_COLLECTION", "kpi_definitions"))
+	hourly := retention.RollupCollection(models.ResolutionHour)
+	daily := retention.RollupCollection(models.ResolutionDay)
+
+	deviceTime := mongo.IndexModel{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "timestamp", Value: -1}}}
+
+	if _, err := sensors.Indexes().CreateOne(ctx, deviceTime); err != nil {
+		return err
+	}
+
+	// KPIs written by earlier producers may lack window_start, so uniqueness is only
+	// enforced on the documents written by the KPI engine.
+	_, err := kpis.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		deviceTime,
+		{Keys: bson.D{{Key: "name", Value: 1}, {Key: "timestamp", Value: -1}}},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "name", Value: 1}, {Key: "window_start", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"window_start": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	unique := options.Index().SetUnique(true)
+	if _, err := definitions.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "name", Value: 1}}, Options: unique}); err != nil {
+		return err
+	}
+
+	bucket := mongo.IndexModel{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "bucket", Value: 1}}, Options: unique}
+	if _, err := hourly.Indexes().CreateOne(ctx, bucket); err != nil {
+		return err
+	}
+	_, err = daily.Indexes().CreateOne(ctx, bucket)
+	return err
+}
+
+// createUniqueUsername refuses to run while duplicate usernames exist, since picking
+// which account to keep is an operator decision.
+func createUniqueUsername(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection(db.CollectionName("MONGO_USERS_COLLECTION", "users"))
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$username", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cursor, err := users.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var duplicates []struct {
+		Username string `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		names := make([]string, len(duplicates))
+		for i, d := range duplicates {
+			names[i] = d.Username
+		}
+		return fmt.Errorf("duplicate usernames must be removed first: %s", strings.Join(names, ", "))
+	}
+
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = users.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// backfillUserFields gives users created by older seeds a role and a creation date,
+// taken from their ObjectID.
+func backfillUserFields(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection(db.CollectionName("MONGO_USERS_COLLECTION", "users"))
+
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"$or": bson.A{bson.M{"role": bson.M{"$exists": false}}, bson.M{"role": ""}}},
+		bson.M{"$set": bson.M{"role": "user"}},
+	); err != nil {
+		return err
+	}
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"createdAt": bson.M{"$exists": false}},
+		bson.M{"createdAt": bson.M{"$lte": time.Time{}}}, // Zero value written by older seeds
+	}}
+	cursor, err := users.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"createdAt": doc.ID.Timestamp()}}
+		if _, err := users.UpdateByID(ctx, doc.ID, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// createTTLIndexes applies the retention settings once. They are also re-applied on
+// every startup, so later changes to RETENTION_*_DAYS take effect without a migration.
+func createTTLIndexes(ctx context.Context, _ *mongo.Database) error {
+	return retention.EnsureTTLIndexes(ctx, retention.LoadConfig())
+}
+
+// createLoginAttemptsTTL forgets failed login records a month after the last failure,
+// well past the longest lockout, so probing random usernames does not grow the collection.
+func createLoginAttemptsTTL(ctx context.Context, database *mongo.Database) error {
+	attempts := database.Collection(db.CollectionName("MONGO_LOGIN_ATTEMPTS_COLLECTION", "login_attempts"))
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32((30 * 24 * time.Hour).Seconds())),
+	}
+	_, err := attempts.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// createRateLimitsTTL removes shared rate limit counters once their window has ended.
+func createRateLimitsTTL(ctx context.Context, database *mongo.Database) error {
+	counters := database.Collection(db.CollectionName("MONGO_RATE_LIMITS_COLLECTION", "rate_limits"))
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := counters.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// createAPIKeyIndexes makes key lookups unique and backs listing keys per service account.
+func createAPIKeyIndexes(ctx context.Context, database *mongo.Database) error {
+	keys := database.Collection(db.CollectionName("MONGO_API_KEYS_COLLECTION", "api_keys"))
+	_, err := keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "service_account", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// createExternalIdentityIndex ensures one local user per OIDC issuer and subject.
+func createExternalIdentityIndex(ctx context.Context, database *mongo.Database) error {
+	users := database.Collection(db.CollectionName("MONGO_USERS_COLLECTION", "users"))
+	model := mongo.IndexModel{
+		Keys: bson.D{{Key: "external_issuer", Value: 1}, {Key: "external_subject", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"external": true}),
+	}
+	_, err := users.Indexes().CreateOne(ctx, model)
+	return err
+}
+
+// createSessionIndexes backs the per-user session list and removes sessions once their
+// token has expired, as nothing can use them after that.
+func createSessionIndexes(ctx context.Context, database *mongo.Database) error {
+	sessions := database.Collection(db.CollectionName("MONGO_SESSIONS_COLLECTION", "sessions"))
+	_, err := sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// createAuditIndexes backs the audit query filters, all of which sort by time. Audit
+// events have no TTL: they are kept until an operator archives them.
+func createAuditIndexes(ctx context.Context, database *mongo.Database) error {
+	events := database.Collection(db.CollectionName("MONGO_AUDIT_COLLECTION", "audit"))
+	_, err := events.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+// createTenantIndexes backs tenant lookups. Device IDs become unique so that a device
+// can only be assigned to one tenant; a deployment with duplicate devices entries must
+// merge them before this migration applies.
+func createTenantIndexes(ctx context.Context, database *mongo.Database) error {
+	devices := db.DevicesCollection()
+	if _, err := devices.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "device_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+
+	for envKey, fallback := range map[string]string{
+		"MONGO_USERS_COLLECTION":    "users",
+		"MONGO_API_KEYS_COLLECTION": "api_keys",
+	} {
+		coll := database.Collection(db.CollectionName(envKey, fallback))
+		if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "tenant_id", Value: 1}}}); err != nil {
+			return err
+		}
+	}
+
+	events := database.Collection(db.CollectionName("MONGO_AUDIT_COLLECTION", "audit"))
+	_, err := events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "time", Value: -1}},
+	})
+	return err
+}
+
+// createLocationIndexes backs the location tree and the group, tag and location device
+// selectors.
+func createLocationIndexes(ctx context.Context, database *mongo.Database) error {
+	locations := database.Collection(db.CollectionName("MONGO_LOCATIONS_COLLECTION", "locations"))
+	if _, err := locations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+
+	devices := db.DevicesCollection()
+	_, err := devices.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "groups", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "location_id", Value: 1}}},
+		{Keys: bson.D{{Key: "location_path", Value: 1}}},
+	})
+	return err
+}
+
+// createMeshIndexes backs the mesh topology. Node device IDs are unique so that only
+// one instance records a node joining.
+func createMeshIndexes(ctx context.Context, database *mongo.Database) error {
+	nodes := database.Collection(db.CollectionName("MONGO_MESH_NODES_COLLECTION", "mesh_nodes"))
+	if _, err := nodes.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "device_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "mesh_id", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+
+	events := db.MeshEventsCollection()
+	_, err := events.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "time", Value: -1}}},
+		{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "time", Value: -1}}},
+	})
+	return err
+}
+
+// createProvisioningIndexes gives each factory ID at most one claim and each broker
+// account a unique username, as the broker's authentication plugin looks them up by it.
+func createProvisioningIndexes(ctx context.Context, database *mongo.Database) error {
+	claims := database.Collection(db.CollectionName("MONGO_PROVISIONING_CLAIMS_COLLECTION", "provisioning_claims"))
+	if _, err := claims.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "factory_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	}); err != nil {
+		return err
+	}
+
+	users := database.Collection(db.CollectionName("MONGO_MQTT_USERS_COLLECTION", "mqtt_users"))
+	_, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// createPendingClaimIndex allows one pending provisioning claim per device ID, so two
+// tenants cannot both hold a claim that would provision the same device. Claims made
+// before are marked pending first; if two of them name the same device, delete one
+// before this migration applies.
+func createPendingClaimIndex(ctx context.Context, database *mongo.Database) error {
+	claims := database.Collection(db.CollectionName("MONGO_PROVISIONING_CLAIMS_COLLECTION", "provisioning_claims"))
+	if _, err := claims.UpdateMany(ctx, bson.M{"claimed_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"pending": true}}); err != nil {
+		return err
+	}
+	_, err := claims.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "device_id", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"pending": true}).
+			SetName("pending_device_id"),
+	})
+	return err
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a long-lived credential owned by a service account. Only a hash of the key
+// is stored; KeyID is the non-secret part of the key used to look it up.
+type APIKey struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	KeyID          string             `bson:"key_id" json:"key_id"`
+	Hash           string             `bson:"hash" json:"-"`
+	ServiceAccount string             `bson:"service_account" json:"service_account"`
+	TenantID       string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"` // The service account's tenant
+	Scopes         []string           `bson:"scopes" json:"scopes"`
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt     *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedBy      string             `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Active reports whether the key can be used at now.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKeyRequest is the payload to create an API key.
+type CreateAPIKeyRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	ServiceAccount string   `json:"service_account" binding:"required"`
+	Scopes         []string `json:"scopes" binding:"required"`
+	ExpiresInDays  int      `json:"expires_in_days"`
+}
+
+// CreateAPIKeyResponse returns the key, which is shown only once.
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// CreateServiceAccountRequest is the payload to create a service account.
+type CreateServiceAccountRequest struct {
+	Username     string   `json:"username" binding:"required"`
+	Role         string   `json:"role" binding:"required"`
+	DeviceGroups []string `json:"device_groups"`
+	TenantID     string   `json:"tenant_id"` // Ignored for tenant callers, whose accounts join their own tenant
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditEvent records who did what, from where, to which target. Events are only ever
+// inserted, never updated or deleted.
+type AuditEvent struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Time       time.Time          `bson:"time" json:"time"`
+	Action     string             `bson:"action" json:"action"`
+	Outcome    string             `bson:"outcome" json:"outcome"`       // success or failure
+	Actor      string             `bson:"actor" json:"actor"`           // Username, or the username tried for failed logins
+	ActorType  string             `bson:"actor_type" json:"actor_type"` // user, api_key, oidc, cli or device
+	APIKeyID   string             `bson:"api_key_id,omitempty" json:"api_key_id,omitempty"`
+	TenantID   string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	ClientIP   string             `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+	RequestID  string             `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TargetType string             `bson:"target_type,omitempty" json:"target_type,omitempty"`
+	TargetID   string             `bson:"target_id,omitempty" json:"target_id,omitempty"`
+	Changes    []AuditChange      `bson:"changes,omitempty" json:"changes,omitempty"`
+	Details    map[string]any     `bson:"details,omitempty" json:"details,omitempty"`
+}
+
+// AuditChange is one field that differs between the before and after state of a target.
+type AuditChange struct {
+	Field  string `bson:"field" json:"field"`
+	Before any    `bson:"before,omitempty" json:"before,omitempty"`
+	After  any    `bson:"after,omitempty" json:"after,omitempty"`
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Device is the registry entry of a device: which tenant owns it, where it is installed,
+// and the groups and tags used to select it. Readings refer to it by DeviceID.
+type Device struct {
+	DeviceID   string              `bson:"device_id" json:"device_id"`
+	Name       string              `bson:"name,omitempty" json:"name,omitempty"`
+	TenantID   string              `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Groups     []string            `bson:"groups,omitempty" json:"groups,omitempty"`
+	Tags       []string            `bson:"tags,omitempty" json:"tags,omitempty"`
+	LocationID *primitive.ObjectID `bson:"location_id,omitempty" json:"location_id,omitempty"`
+
+	// Location is the display path of the location, e.g. "Lisbon / Plant A / Boiler room",
+	// by which the KPI summary groups devices.
+	Location string `bson:"location,omitempty" json:"location,omitempty"`
+
+	// LocationPath holds the device's location and every location above it, so a site
+	// or building selects every device below it with one index lookup.
+	LocationPath []primitive.ObjectID `bson:"location_path,omitempty" json:"location_path,omitempty"`
+	UpdatedAt    *time.Time           `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+
+	// FactoryID and ProvisionedAt are set when the device joined through provisioning.
+	// EncryptionKey is its payload key, sealed with PROVISIONING_KEY_ENCRYPTION_KEY.
+	FactoryID     string     `bson:"factory_id,omitempty" json:"factory_id,omitempty"`
+	ProvisionedAt *time.Time `bson:"provisioned_at,omitempty" json:"provisioned_at,omitempty"`
+	EncryptionKey string     `bson:"encryption_key,omitempty" json:"-"`
+}
+
+// UpdateDeviceRequest replaces the name, groups, tags and location of a device. An empty
+// location_id removes the device from its location.
+type UpdateDeviceRequest struct {
+	Name       string   `json:"name"`
+	Groups     []string `json:"groups"`
+	Tags       []string `json:"tags"`
+	LocationID string   `json:"location_id"`
+}
+
+// DeviceSelector picks devices by group, tag or location. Devices must match every kind
+// of selector given, and any of the values given for a kind; a location matches the
+// devices at it and below it.
+type DeviceSelector struct {
+	Groups    []string
+	Tags      []string
+	Locations []primitive.ObjectID
+}
+
+// Empty reports whether the selector selects nothing in particular.
+func (s DeviceSelector) Empty() bool {
+	return len(s.Groups) == 0 && len(s.Tags) == 0 && len(s.Locations) == 0
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// KPI represents a key performance indicator computed for one device over one time window.
+type KPI struct {
+	DeviceID    string    `bson:"device_id" json:"device_id"`
+	TenantID    string    `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Name        string    `bson:"name" json:"name"`
+	Value       float64   `bson:"value" json:"value"`
+	Unit        string    `bson:"unit,omitempty" json:"unit,omitempty"`
+	Samples     int       `bson:"samples" json:"samples"`
+	WindowStart time.Time `bson:"window_start" json:"window_start"`
+	WindowEnd   time.Time `bson:"window_end" json:"window_end"`
+	Timestamp   time.Time `bson:"timestamp" json:"timestamp"` // Equal to WindowEnd, kept for sorting
+	ComputedAt  time.Time `bson:"computed_at" json:"computed_at"`
+}
+
+// KPIDefinition is an admin-defined KPI evaluated by the KPI engine alongside the
+// built-in ones. Either Aggregation (over Metric) or Formula must be set.
+type KPIDefinition struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name          string             `bson:"name" json:"name" binding:"required"`
+	Description   string             `bson:"description,omitempty" json:"description,omitempty"`
+	Metric        string             `bson:"metric,omitempty" json:"metric,omitempty"`
+	Aggregation   string             `bson:"aggregation,omitempty" json:"aggregation,omitempty"` // mean, min, max, pNN, uptime, message_rate, packet_loss
+	Formula       string             `bson:"formula,omitempty" json:"formula,omitempty"`         // e.g. "100 - packet_loss"
+	WindowMinutes int                `bson:"window_minutes" json:"window_minutes"`
+	DeviceIDs     []string           `bson:"device_ids,omitempty" json:"device_ids,omitempty"`       // Empty means every device
+	DevicePrefix  string             `bson:"device_prefix,omitempty" json:"device_prefix,omitempty"` // Matches device IDs starting with the prefix
+	Unit          string             `bson:"unit,omitempty" json:"unit,omitempty"`
+	Enabled       bool               `bson:"enabled" json:"enabled"`
+	CreatedBy     string             `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// DeviceKPIValue is the latest value of a KPI for one device.
+type DeviceKPIValue struct {
+	DeviceID string  `bson:"device_id" json:"device_id"`
+	Value    float64 `bson:"value" json:"value"`
+}
+
+// KPISummary aggregates one KPI across the devices of a group.
+type KPISummary struct {
+	Name         string           `bson:"name" json:"name"`
+	Group        string           `bson:"group,omitempty" json:"group,omitempty"` // Tag or location, empty when grouped by name
+	Devices      int              `bson:"devices" json:"devices"`
+	Latest       float64          `bson:"latest" json:"latest"`     // Mean of each device's latest value
+	Current      *float64         `bson:"current" json:"current"`   // Mean over the current period
+	Previous     *float64         `bson:"previous" json:"previous"` // Mean over the previous period
+	Trend        *float64         `bson:"-" json:"trend"`           // Current minus previous
+	TrendPercent *float64         `bson:"-" json:"trend_percent"`
+	Top          []DeviceKPIValue `bson:"-" json:"top"`
+	Bottom       []DeviceKPIValue `bson:"-" json:"bottom"`
+	DeviceValues []DeviceKPIValue `bson:"device_values" json:"-"`
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Location kinds, from the top of the hierarchy down.
+const (
+	LocationSite     = "site"
+	LocationBuilding = "building"
+	LocationRoom     = "room"
+)
+
+// LocationKinds lists the location kinds in hierarchy order. A location's parent is of
+// the kind before its own; sites have no parent.
+var LocationKinds = []string{LocationSite, LocationBuilding, LocationRoom}
+
+// Location is a site, building or room devices can be installed in.
+type Location struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	TenantID  string               `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Kind      string               `bson:"kind" json:"kind"`
+	Name      string               `bson:"name" json:"name"`
+	ParentID  *primitive.ObjectID  `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Ancestors []primitive.ObjectID `bson:"ancestors,omitempty" json:"ancestors,omitempty"` // From the site down to the parent
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+}
+
+// Path returns the location's ancestors followed by the location itself.
+func (l Location) Path() []primitive.ObjectID {
+	return append(append([]primitive.ObjectID{}, l.Ancestors...), l.ID)
+}
+
+// CreateLocationRequest is the payload to create a location. Sites have no parent;
+// platform users may set the tenant of a site, which its children inherit.
+type CreateLocationRequest struct {
+	Kind     string `json:"kind" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	ParentID string `json:"parent_id"`
+	TenantID string `json:"tenant_id"`
+}
+
+// RenameLocationRequest is the payload to rename a location.
+type RenameLocationRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// LocationNode is a location with the locations and devices directly below it.
+type LocationNode struct {
+	Location
+	DeviceIDs []string       `json:"device_ids"`
+	Children  []LocationNode `json:"children"`
+}
+
+// ParentKind returns the kind a location's parent must have, "" for sites, and false
+// for an unknown kind.
+func ParentKind(kind string) (string, bool) {
+	for i, k := range LocationKinds {
+		if k == kind {
+			if i == 0 {
+				return "", true
+			}
+			return LocationKinds[i-1], true
+		}
+	}
+	return "", false
+}
+
+// LocationTree nests the locations under their parents, sorted by name, and lists each
+// device under the location it is installed in. Locations whose parent is missing from
+// the list become roots.
+func LocationTree(locations []Location, devices []Device) []LocationNode {
+	byParent := map[primitive.ObjectID][]Location{}
+	known := map[primitive.ObjectID]bool{}
+	for _, l := range locations {
+		known[l.ID] = true
+	}
+	var roots []Location
+	for _, l := range locations {
+		if l.ParentID != nil && known[*l.ParentID] {
+			byParent[*l.ParentID] = append(byParent[*l.ParentID], l)
+		} else {
+			roots = append(roots, l)
+		}
+	}
+	deviceIDs := map[primitive.ObjectID][]string{}
+	for _, d := range devices {
+		if d.LocationID != nil {
+			deviceIDs[*d.LocationID] = append(deviceIDs[*d.LocationID], d.DeviceID)
+		}
+	}
+
+	var build func([]Location) []LocationNode
+	build = func(level []Location) []LocationNode {
+		sort.Slice(level, func(i, j int) bool { return level[i].Name < level[j].Name })
+		nodes := make([]LocationNode, 0, len(level))
+		for _, l := range level {
+			ids := deviceIDs[l.ID]
+			if ids == nil {
+				ids = []string{}
+			}
+			sort.Strings(ids)
+			nodes = append(nodes, LocationNode{Location: l, DeviceIDs: ids, Children: build(byParent[l.ID])})
+		}
+		return nodes
+	}
+	return build(roots)
+}
+
+// LocationLabel joins the names of the locations on the path, e.g. "Lisbon / Plant A /
+// Boiler room". Locations missing from byID are skipped.
+func LocationLabel(path []primitive.ObjectID, byID map[primitive.ObjectID]Location) string {
+	names := make([]string, 0, len(path))
+	for _, id := range path {
+		if l, ok := byID[id]; ok {
+			names = append(names, l.Name)
+		}
+	}
+	return strings.Join(names, " / ")
+}
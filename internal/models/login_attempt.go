@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LoginAttempt tracks failed logins for one username and whether it is locked out.
+type LoginAttempt struct {
+	Username     string     `bson:"_id" json:"username"`
+	Failures     int        `bson:"failures" json:"failures"`
+	FirstFailure time.Time  `bson:"first_failure" json:"first_failure"`
+	Lockouts     int        `bson:"lockouts" json:"lockouts"`
+	LockedUntil  *time.Time `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	UpdatedAt    time.Time  `bson:"updated_at" json:"updated_at"`
+}
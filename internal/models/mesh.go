@@ -0,0 +1,95 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Mesh node statuses, derived from when a node last reported and whether its chain of
+// parents reaches a root.
+const (
+	MeshOnline   = "online"
+	MeshOffline  = "offline"  // Not heard from within the node timeout
+	MeshOrphaned = "orphaned" // Reporting, but its parent is offline, unknown or not connected to a root
+)
+
+// Mesh event types.
+const (
+	MeshNodeJoined   = "node.joined"
+	MeshParentChange = "node.parent_change"
+	MeshNodeOrphaned = "node.orphaned"
+	MeshRootChange   = "root.change"
+)
+
+// MeshReport is the position in the ESP-MESH tree a node reports in the "mesh" object
+// of its readings.
+type MeshReport struct {
+	DeviceID string
+	TenantID string
+	MeshID   string
+	ParentID string // Device ID of the parent; ignored for the root, whose parent is the router
+	Layer    int    // 1 for the root
+	RSSI     int    // Signal strength of the link to the parent, in dBm
+	Time     time.Time
+}
+
+// MeshNode is the last reported position of a node in the mesh.
+type MeshNode struct {
+	DeviceID   string     `bson:"device_id" json:"device_id"`
+	TenantID   string     `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	MeshID     string     `bson:"mesh_id" json:"mesh_id,omitempty"`
+	ParentID   string     `bson:"parent_id" json:"parent_id,omitempty"`
+	Layer      int        `bson:"layer" json:"layer"`
+	RSSI       int        `bson:"rssi" json:"rssi"`
+	LastSeen   time.Time  `bson:"last_seen" json:"last_seen"`
+	ChangedAt  time.Time  `bson:"changed_at" json:"changed_at"` // When the node joined or last changed parent
+	OrphanedAt *time.Time `bson:"orphaned_at,omitempty" json:"orphaned_at,omitempty"`
+}
+
+// Root reports whether the node is the root of its mesh.
+func (n MeshNode) Root() bool {
+	return n.Layer == 1
+}
+
+// SameLink reports whether both nodes have the same place in the tree, ignoring signal
+// strength and timestamps.
+func (n MeshNode) SameLink(other MeshNode) bool {
+	return n.MeshID == other.MeshID && n.ParentID == other.ParentID && n.Layer == other.Layer
+}
+
+// MeshEvent is a change in the mesh topology, kept as its history.
+type MeshEvent struct {
+	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Time             time.Time          `bson:"time" json:"time"`
+	Type             string             `bson:"type" json:"type"`
+	TenantID         string             `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	MeshID           string             `bson:"mesh_id,omitempty" json:"mesh_id,omitempty"`
+	DeviceID         string             `bson:"device_id" json:"device_id"`
+	ParentID         string             `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	PreviousParentID string             `bson:"previous_parent_id,omitempty" json:"previous_parent_id,omitempty"`
+	PreviousRoot     string             `bson:"previous_root,omitempty" json:"previous_root,omitempty"`
+	Layer            int                `bson:"layer,omitempty" json:"layer,omitempty"`
+}
+
+// MeshTopologyNode is a node of the topology graph.
+type MeshTopologyNode struct {
+	MeshNode
+	Status string `json:"status"`
+	Root   bool   `json:"root"`
+}
+
+// MeshEdge links a node to its parent.
+type MeshEdge struct {
+	Source string `json:"source"` // Child
+	Target string `json:"target"` // Parent
+	RSSI   int    `json:"rssi"`
+}
+
+// MeshTopology is the current mesh as a graph, for visualization.
+type MeshTopology struct {
+	Nodes       []MeshTopologyNode `json:"nodes"`
+	Edges       []MeshEdge         `json:"edges"`
+	Roots       []string           `json:"roots"`
+	GeneratedAt time.Time          `json:"generated_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Organization is a tenant: a customer site whose users, devices, readings and KPIs are
+// kept apart from every other tenant's. The ID is a short slug stored as tenant_id on
+// everything the organization owns.
+type Organization struct {
+	ID        string    `bson:"_id" json:"id"`
+	Name      string    `bson:"name" json:"name"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+
+	// TopicPrefixes are the MQTT topic prefixes the organization's devices publish under,
+	// e.g. "sites/acme/". Prefixes end in "/" and never overlap another organization's.
+	// Devices first seen under a prefix are assigned to the tenant.
+	TopicPrefixes []string `bson:"topic_prefixes,omitempty" json:"topic_prefixes,omitempty"`
+}
+
+// CreateOrganizationRequest is the payload to create an organization.
+type CreateOrganizationRequest struct {
+	ID            string   `json:"id" binding:"required"`
+	Name          string   `json:"name" binding:"required"`
+	TopicPrefixes []string `json:"topic_prefixes"`
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProvisioningClaim lets the device with a factory ID join once, with the claim code
+// printed on its label, as the given device in the given tenant, groups and location.
+type ProvisioningClaim struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	FactoryID      string              `bson:"factory_id" json:"factory_id"`
+	CodeHash       string              `bson:"code_hash" json:"-"`
+	DeviceID       string              `bson:"device_id" json:"device_id"`
+	TenantID       string              `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	Groups         []string            `bson:"groups,omitempty" json:"groups,omitempty"`
+	LocationID     *primitive.ObjectID `bson:"location_id,omitempty" json:"location_id,omitempty"`
+	CreatedBy      string              `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	ExpiresAt      time.Time           `bson:"expires_at" json:"expires_at"`
+	FailedAttempts int                 `bson:"failed_attempts" json:"failed_attempts"`
+	ClaimedAt      *time.Time          `bson:"claimed_at,omitempty" json:"claimed_at,omitempty"`
+
+	// Pending is set until the claim is redeemed. A unique index over the device IDs of
+	// pending claims keeps two tenants from holding claims for the same device.
+	Pending bool `bson:"pending,omitempty" json:"-"`
+}
+
+// CreateClaimRequest is the payload to create a provisioning claim. The device ID
+// defaults to the factory ID.
+type CreateClaimRequest struct {
+	FactoryID      string   `json:"factory_id" binding:"required"`
+	DeviceID       string   `json:"device_id"`
+	Groups         []string `json:"groups"`
+	LocationID     string   `json:"location_id"`
+	TenantID       string   `json:"tenant_id"` // For platform users
+	ExpiresInHours int      `json:"expires_in_hours"`
+}
+
+// CreateClaimResponse returns the claim code, which is shown only once.
+type CreateClaimResponse struct {
+	ProvisioningClaim
+	ClaimCode string `json:"claim_code"`
+}
+
+// BootstrapRequest is what a new device presents to join.
+type BootstrapRequest struct {
+	FactoryID string `json:"factory_id" binding:"required"`
+	ClaimCode string `json:"claim_code" binding:"required"`
+}
+
+// BootstrapMQTT is how a device connects to the broker.
+type BootstrapMQTT struct {
+	URL      string `json:"url"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Topic    string `json:"topic"` // Where the device publishes its readings
+}
+
+// BootstrapBundle is the configuration a device stores after joining. Its secrets are
+// only returned once.
+type BootstrapBundle struct {
+	DeviceID      string        `json:"device_id"`
+	TenantID      string        `json:"tenant_id,omitempty"`
+	MQTT          BootstrapMQTT `json:"mqtt"`
+	EncryptionKey string        `json:"encryption_key"` // AES-256 key, base64 encoded
+	IssuedAt      time.Time     `json:"issued_at"`
+}
+
+// MQTT ACL access levels, as mosquitto-go-auth reads them.
+const (
+	MQTTRead      = 1
+	MQTTWrite     = 2
+	MQTTReadWrite = 3
+	MQTTSubscribe = 4
+)
+
+// MQTTACL grants access to a topic.
+type MQTTACL struct {
+	Topic string `bson:"topic" json:"topic"`
+	Acc   int    `bson:"acc" json:"acc"`
+}
+
+// MQTTCredential is a broker account, stored in the layout of the mosquitto-go-auth
+// MongoDB backend so the broker authenticates devices without manual ACL edits.
+type MQTTCredential struct {
+	Username     string    `bson:"username" json:"username"`
+	PasswordHash string    `bson:"password" json:"-"`
+	Superuser    bool      `bson:"superuser" json:"superuser"`
+	ACLs         []MQTTACL `bson:"acls" json:"acls"`
+	DeviceID     string    `bson:"device_id" json:"device_id"`
+	TenantID     string    `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Rollup resolutions.
+const (
+	ResolutionMinute = "minute"
+	ResolutionHour   = "hour"
+	ResolutionDay    = "day"
+)
+
+// MetricStats summarises the values of one metric within a rollup bucket.
+type MetricStats struct {
+	Count int     `bson:"count" json:"count"`
+	Sum   float64 `bson:"sum" json:"sum"`
+	Min   float64 `bson:"min" json:"min"`
+	Max   float64 `bson:"max" json:"max"`
+	Mean  float64 `bson:"mean" json:"mean"`
+}
+
+// Add folds other into s.
+func (s *MetricStats) Add(other MetricStats) {
+	if other.Count == 0 {
+		return
+	}
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.Count += other.Count
+	s.Sum += other.Sum
+	s.Mean = s.Sum / float64(s.Count)
+}
+
+// Rollup aggregates the sensor readings of one device over one time bucket.
+type Rollup struct {
+	DeviceID   string                 `bson:"device_id" json:"device_id"`
+	Resolution string                 `bson:"resolution" json:"resolution"`
+	Bucket     time.Time              `bson:"bucket" json:"bucket"`
+	Messages   int                    `bson:"messages" json:"messages"`
+	Metrics    map[string]MetricStats `bson:"metrics" json:"metrics"`
+	UpdatedAt  time.Time              `bson:"updated_at" json:"-"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Session is one issued access token. Tokens name their session in the jti claim, so
+// revoking the session invalidates the token before it expires.
+type Session struct {
+	ID         string     `bson:"_id" json:"id"`
+	Username   string     `bson:"username" json:"-"`
+	Method     string     `bson:"method" json:"method"` // password, mfa, oidc or cli (token issue)
+	ClientIP   string     `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+	UserAgent  string     `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time  `bson:"expires_at" json:"expires_at"`
+	LastSeenAt *time.Time `bson:"last_seen_at,omitempty" json:"last_seen_at,omitempty"`
+	RevokedAt  *time.Time `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+
+	// Current marks the session of the request listing the sessions.
+	Current bool `bson:"-" json:"current,omitempty"`
+}
+
+// Active reports whether the session is neither revoked nor expired at now.
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ChangePasswordRequest is the payload to change one's own password.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
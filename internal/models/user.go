@@ -13,6 +13,31 @@ type User struct {
 	Password  string             `bson:"password" json:"-"` // Do not expose password hash in JSON
 	Role      string             `bson:"role" json:"role"`
 	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+
+	// TenantID is the organization the user belongs to. Users without one are platform
+	// operators and are not limited to a tenant.
+	TenantID string `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+
+	// DeviceGroups limits which devices the user sees unless the role grants devices:all.
+	// The group "*" covers every device.
+	DeviceGroups []string `bson:"device_groups,omitempty" json:"device_groups,omitempty"`
+
+	// ServiceAccount users have no password and authenticate with API keys only.
+	ServiceAccount bool `bson:"service_account,omitempty" json:"service_account,omitempty"`
+
+	// External users are provisioned from an OIDC login and have no local password.
+	External        bool   `bson:"external,omitempty" json:"external,omitempty"`
+	ExternalIssuer  string `bson:"external_issuer,omitempty" json:"external_issuer,omitempty"`
+	ExternalSubject string `bson:"external_subject,omitempty" json:"-"`
+	Email           string `bson:"email,omitempty" json:"email,omitempty"`
+
+	// TOTP second factor. The pending secret is held until the first code confirms
+	// enrollment; recovery codes are stored as bcrypt hashes and removed when used.
+	TOTPEnabled       bool     `bson:"totp_enabled,omitempty" json:"totp_enabled,omitempty"`
+	TOTPSecret        string   `bson:"totp_secret,omitempty" json:"-"`
+	TOTPPendingSecret string   `bson:"totp_pending_secret,omitempty" json:"-"`
+	TOTPLastStep      int64    `bson:"totp_last_step,omitempty" json:"-"` // Rejects replayed codes
+	RecoveryCodes     []string `bson:"recovery_codes,omitempty" json:"-"`
 }
 
 // LoginRequest represents the payload to request a login.
@@ -21,9 +46,34 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-// LoginResponse represents the payload sent back after successful login.
+// LoginResponse represents the payload sent back after successful login. Users with a
+// second factor get a challenge token instead, to exchange at /api/login/mfa.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token          string `json:"token,omitempty"`
+	MFARequired    bool   `json:"mfa_required,omitempty"`
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
+
+// MFALoginRequest completes a login with the challenge token and a TOTP or recovery code.
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// MFACodeRequest carries a TOTP code, or a recovery code where the endpoint allows one.
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TOTPEnrollResponse holds the new secret and the otpauth:// URI to render as a QR code.
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse returns freshly generated recovery codes; they are shown only once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // AuthClaims represents JWT claims.
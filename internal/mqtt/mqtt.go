@@ -1,31 +1,206 @@
 package mqtt
 
 import (
-	"log"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
 )
 
-var mqttClient mqtt.Client
+var logger = logging.For("mqtt")
+
+var (
+	mqttClient   mqtt.Client
+	topic        string
+	cleanSession bool
+)
+
+// Config holds the broker connection settings.
+type Config struct {
+	BrokerURL            string
+	ClientID             string
+	Username             string
+	Password             string
+	Topic                string
+	QoS                  byte
+	CleanSession         bool
+	ConnectTimeout       time.Duration
+	MaxReconnectInterval time.Duration
+	TLS                  *tls.Config
+}
+
+// LoadConfig reads the MQTT settings from the environment. The broker URL is taken from
+// MQTT_BROKER_URL when set (tcp://, ssl:// or tls://), otherwise built from MQTT_BROKER and
+// MQTT_PORT, using ssl:// when MQTT_TLS is true or a CA file is configured.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		BrokerURL:            os.Getenv("MQTT_BROKER_URL"),
+		ClientID:             os.Getenv("MQTT_CLIENT_ID"),
+		Username:             os.Getenv("MQTT_USERNAME"),
+		Password:             os.Getenv("MQTT_PASSWORD"),
+		Topic:                os.Getenv("MQTT_TOPIC_SENSORS_DATA"),
+		CleanSession:         os.Getenv("MQTT_CLEAN_SESSION") != "false",
+		ConnectTimeout:       time.Duration(envInt("MQTT_CONNECT_TIMEOUT_SECONDS", 10)) * time.Second,
+		MaxReconnectInterval: time.Duration(envInt("MQTT_MAX_RECONNECT_SECONDS", 60)) * time.Second,
+	}
+
+	qos := envInt("MQTT_QOS", 0)
+	if qos < 0 || qos > 2 {
+		return Config{}, fmt.Errorf("MQTT_QOS must be 0, 1 or 2, got %d", qos)
+	}
+	cfg.QoS = byte(qos)
+
+	if cfg.ClientID == "" {
+		hostname, _ := os.Hostname()
+		cfg.ClientID = "esp32-backend-api-" + hostname
+	}
+	if !cfg.CleanSession && os.Getenv("MQTT_CLIENT_ID") == "" {
+		logger.Warn("Persistent session without MQTT_CLIENT_ID; the session is tied to the hostname", "client_id", cfg.ClientID)
+	}
+
+	useTLS := os.Getenv("MQTT_TLS") == "true" || os.Getenv("MQTT_CA_FILE") != ""
+	if cfg.BrokerURL == "" {
+		scheme := "tcp"
+		if useTLS {
+			scheme = "ssl"
+		}
+		cfg.BrokerURL = scheme + "://" + os.Getenv("MQTT_BROKER") + ":" + os.Getenv("MQTT_PORT")
+	}
+
+	if useTLS {
+		tlsConfig, err := loadTLSConfig()
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.TLS = tlsConfig
+	}
+	return cfg, nil
+}
+
+// loadTLSConfig builds the TLS settings from MQTT_CA_FILE and, for mutual TLS,
+// MQTT_CERT_FILE and MQTT_KEY_FILE.
+func loadTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: os.Getenv("MQTT_TLS_INSECURE_SKIP_VERIFY") == "true",
+	}
+
+	if caFile := os.Getenv("MQTT_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("MQTT_CA_FILE contains no valid certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certFile, keyFile := os.Getenv("MQTT_CERT_FILE"), os.Getenv("MQTT_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
 
 // InitMQTT initializes the MQTT client, connects to the broker, and subscribes to the specified topic.
+// The client reconnects with exponential backoff and resubscribes on every (re)connection, so
+// a broker that is down at startup or restarts later does not require restarting the service.
 func InitMQTT(handler mqtt.MessageHandler) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		logging.Fatal(logger, "Invalid configuration", "error", err)
+	}
+
 	opts := mqtt.NewClientOptions().
-		AddBroker("tcp://" + os.Getenv("MQTT_BROKER") + ":" + os.Getenv("MQTT_PORT")).
-		SetUsername(os.Getenv("MQTT_USERNAME")).
-		SetPassword(os.Getenv("MQTT_PASSWORD")).
-		SetDefaultPublishHandler(handler)
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(cfg.CleanSession).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(time.Second).
+		SetMaxReconnectInterval(cfg.MaxReconnectInterval).
+		SetDefaultPublishHandler(handler).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			logger.Info("Connected", "broker", cfg.BrokerURL, "client_id", cfg.ClientID)
+			subscribe(client, cfg)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("Connection lost", "error", err)
+		}).
+		SetReconnectingHandler(func(_ mqtt.Client, _ *mqtt.ClientOptions) {
+			logger.Info("Reconnecting")
+		})
+	if cfg.TLS != nil {
+		opts.SetTLSConfig(cfg.TLS)
+	}
 
+	topic = cfg.Topic + "#"
+	cleanSession = cfg.CleanSession
 	mqttClient = mqtt.NewClient(opts)
 
-	if token := mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		log.Fatalf("[MQTT] Failed to connect: %v", token.Error())
+	// With ConnectRetry the token only completes once connected, so startup waits for a
+	// bounded time and then carries on; /readyz reports the broker as down meanwhile.
+	token := mqttClient.Connect()
+	if !token.WaitTimeout(cfg.ConnectTimeout) {
+		logger.Warn("Broker not reachable, retrying in the background", "broker", cfg.BrokerURL, "waited", cfg.ConnectTimeout.String())
+		return
 	}
+	if token.Error() != nil {
+		logging.Fatal(logger, "Failed to connect", "error", token.Error())
+	}
+}
+
+func subscribe(client mqtt.Client, cfg Config) {
+	token := client.Subscribe(topic, cfg.QoS, nil)
+	if token.Wait() && token.Error() != nil {
+		logger.Error("Failed to subscribe", "topic", topic, "error", token.Error())
+		return
+	}
+	logger.Info("Subscribed", "topic", topic, "qos", cfg.QoS)
+}
+
+// Close unsubscribes from the sensor topic so the broker stops delivering, then
+// disconnects, giving in-flight message handlers up to quiesce to complete.
+// Persistent sessions keep their subscription so the broker queues QoS 1/2 messages
+// for the next instance instead of dropping them.
+func Close(quiesce time.Duration) {
+	if mqttClient == nil {
+		return
+	}
+	if cleanSession && mqttClient.IsConnectionOpen() {
+		token := mqttClient.Unsubscribe(topic)
+		if token.WaitTimeout(quiesce) && token.Error() != nil {
+			logger.Error("Failed to unsubscribe", "topic", topic, "error", token.Error())
+		}
+	}
+	mqttClient.Disconnect(uint(quiesce.Milliseconds()))
+	logger.Info("Disconnected")
+}
+
+// IsConnected reports whether the client currently has an open connection to the broker.
+func IsConnected() bool {
+	return mqttClient != nil && mqttClient.IsConnectionOpen()
+}
 
-	topic := os.Getenv("MQTT_TOPIC_SENSORS_DATA")
-	if token := mqttClient.Subscribe(topic+"#", 0, nil); token.Wait() && token.Error() != nil {
-		log.Fatalf("[MQTT] Failed to subscribe: %v", token.Error())
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
 	}
-	log.Printf("[MQTT] Subscribed to topic: %s", topic)
+	return def
 }
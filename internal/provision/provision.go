@@ -0,0 +1,230 @@
+// Package provision generates what a new device needs to join: the one-time claim code
+// printed for it, and at bootstrap its MQTT credentials and payload encryption key.
+package provision
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+// MaxFailedAttempts is how many wrong claim codes a claim takes before it is locked and
+// has to be created again.
+const MaxFailedAttempts = 5
+
+// ErrNotConfigured is returned when no key encryption key is set, so device keys could
+// not be stored safely.
+var ErrNotConfigured = errors.New("PROVISIONING_KEY_ENCRYPTION_KEY is not set")
+
+// Config holds the provisioning settings.
+type Config struct {
+	ClaimTTL  time.Duration
+	BrokerURL string // Broker address as the devices reach it
+	DataTopic string // Topic prefix devices publish under when their tenant has none
+
+	// KeyEncryptionKey seals the device encryption keys stored in the devices collection.
+	KeyEncryptionKey []byte
+}
+
+// LoadConfig reads the provisioning settings from the environment.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		ClaimTTL:  time.Duration(envInt("PROVISIONING_CLAIM_TTL_HOURS", 72)) * time.Hour,
+		BrokerURL: os.Getenv("PROVISIONING_MQTT_URL"),
+		DataTopic: os.Getenv("MQTT_TOPIC_SENSORS_DATA"),
+	}
+	if cfg.BrokerURL == "" {
+		cfg.BrokerURL = os.Getenv("MQTT_BROKER_URL")
+	}
+	if cfg.BrokerURL == "" {
+		cfg.BrokerURL = "tcp://" + os.Getenv("MQTT_BROKER") + ":" + os.Getenv("MQTT_PORT")
+	}
+	if cfg.DataTopic == "" {
+		cfg.DataTopic = "mesh/data/"
+	}
+
+	kek := os.Getenv("PROVISIONING_KEY_ENCRYPTION_KEY")
+	if kek == "" {
+		return cfg, ErrNotConfigured
+	}
+	key, err := base64.StdEncoding.DecodeString(kek)
+	if err != nil || len(key) != 32 {
+		return cfg, errors.New("PROVISIONING_KEY_ENCRYPTION_KEY must be 32 bytes, base64 encoded")
+	}
+	cfg.KeyEncryptionKey = key
+	return cfg, nil
+}
+
+// ValidDeviceID reports whether id can name a device: non-empty, and usable as an MQTT
+// topic level and username.
+func ValidDeviceID(id string) bool {
+	return id != "" && len(id) <= 64 && !strings.ContainsAny(id, "/+#$ \t\n")
+}
+
+// claimAlphabet is Crockford's base32, without the letters easily confused on a label.
+const claimAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// GenerateClaimCode returns a new claim code of the form XXXXX-XXXXX (50 random bits)
+// and the hash to store. The code is shown once, to be printed on the device's label.
+func GenerateClaimCode() (code, hash string) {
+	b := make([]byte, 10)
+	rand.Read(b)
+	var sb strings.Builder
+	for i, v := range b {
+		if i == 5 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(claimAlphabet[int(v)%len(claimAlphabet)])
+	}
+	code = sb.String()
+	return code, HashClaimCode(code)
+}
+
+// HashClaimCode hashes a claim code for storage, ignoring case, spaces and dashes so
+// codes can be typed as they read. Codes are locked after MaxFailedAttempts and expire,
+// so a fast hash is enough.
+func HashClaimCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyClaimCode compares a presented claim code with a stored hash in constant time.
+func VerifyClaimCode(code, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashClaimCode(code)), []byte(hash)) == 1
+}
+
+// Credentials are what a device gets at bootstrap.
+type Credentials struct {
+	Password      string // MQTT password, returned once
+	PasswordHash  string // For the broker's authentication plugin
+	EncryptionKey []byte // AES-256 key for the device's payloads
+}
+
+// GenerateCredentials returns a new MQTT password and encryption key.
+func GenerateCredentials() (Credentials, error) {
+	secret := make([]byte, 24)
+	key := make([]byte, 32)
+	rand.Read(secret)
+	rand.Read(key)
+
+	password := base64.RawURLEncoding.EncodeToString(secret)
+	hash, err := HashMQTTPassword(password)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{Password: password, PasswordHash: hash, EncryptionKey: key}, nil
+}
+
+// PBKDF2 parameters of mosquitto-go-auth's default hasher.
+const (
+	pbkdf2Iterations = 100000
+	pbkdf2SaltSize   = 16
+	pbkdf2KeyLength  = 64
+)
+
+// HashMQTTPassword hashes an MQTT password in the PBKDF2$sha512$iterations$salt$hash
+// format read by mosquitto-go-auth with its default settings.
+func HashMQTTPassword(password string) (string, error) {
+	salt := make([]byte, pbkdf2SaltSize)
+	rand.Read(salt)
+	return hashMQTTPassword(password, salt)
+}
+
+// VerifyMQTTPassword checks a password against a hash made by HashMQTTPassword.
+func VerifyMQTTPassword(password, hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "PBKDF2" || parts[1] != "sha512" || parts[2] != strconv.Itoa(pbkdf2Iterations) {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	expected, err := hashMQTTPassword(password, salt)
+	return err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+}
+
+func hashMQTTPassword(password string, salt []byte) (string, error) {
+	key, err := pbkdf2.Key(sha512.New, password, salt, pbkdf2Iterations, pbkdf2KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("PBKDF2$sha512$%d$%s$%s", pbkdf2Iterations,
+		base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+// ACLs returns the topics a device may use: publishing its readings to its own topic.
+func ACLs(dataTopic string) []models.MQTTACL {
+	return []models.MQTTACL{{Topic: dataTopic, Acc: models.MQTTWrite}}
+}
+
+// DataTopic returns the topic a device publishes its readings to: its ID under the
+// tenant's first topic prefix, so the readings are routed to the tenant, or under
+// fallback.
+func DataTopic(org *models.Organization, fallback, deviceID string) string {
+	prefix := fallback
+	if org != nil && len(org.TopicPrefixes) > 0 {
+		prefix = org.TopicPrefixes[0]
+	}
+	return prefix + deviceID
+}
+
+// SealKey encrypts a device key with the key encryption key using AES-256-GCM, returning
+// the nonce and ciphertext base64 encoded.
+func SealKey(kek, key []byte) (string, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, key, nil)), nil
+}
+
+// OpenKey decrypts a device key sealed with SealKey.
+func OpenKey(kek []byte, sealed string) ([]byte, error) {
+	gcm, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return nil, errors.New("malformed sealed key")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+func newGCM(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter is an in-memory set of token buckets, one per key. Each bucket holds up to
+// Burst tokens and refills at Rate tokens per second; a request spends one token.
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// PerMinute returns a limiter allowing n requests per minute with bursts of up to burst.
+func PerMinute(n, burst int) *Limiter {
+	return &Limiter{Rate: float64(n) / 60, Burst: burst, buckets: map[string]*bucket{}}
+}
+
+// Allow spends a token from key's bucket. When the bucket is empty it returns false and
+// how long until the next token is available.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	ok, _, wait := l.take(key, now)
+	return ok, wait
+}
+
+// take is Allow that also returns the tokens left after the request.
+func (l *Limiter) take(key string, now time.Time) (bool, float64, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.Rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, b.tokens, 0
+	}
+	if l.Rate <= 0 {
+		return false, b.tokens, time.Hour
+	}
+	return false, b.tokens, l.refill(1 - b.tokens)
+}
+
+// refill returns how long it takes to earn the given number of tokens.
+func (l *Limiter) refill(tokens float64) time.Duration {
+	return time.Duration(tokens / l.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely, since they behave like new ones.
+// It runs at most once a minute so Allow stays cheap.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule allows N requests per Window.
+type Rule struct {
+	N      int
+	Window time.Duration
+}
+
+// Config selects the store and limits applied by the API rate-limit middleware.
+type Config struct {
+	Enabled bool
+	Store   Store
+	Default Rule            // Per principal, across all routes
+	Routes  map[string]Rule // Per principal and route, keyed by "METHOD /path" or "/path"
+}
+
+// LoadConfig reads the API rate limits from the environment:
+//
+//	RATE_LIMIT_ENABLED  true (default) or false
+//	RATE_LIMIT_BACKEND  memory (default) or mongo
+//	RATE_LIMIT_DEFAULT  e.g. "120/1m"
+//	RATE_LIMIT_ROUTES   e.g. "GET /api/data=30/1m,/api/kpis/summary=10/1m"
+//
+// Route paths use Gin's patterns, such as /api/data/:device_id.
+func LoadConfig() (Config, error) {
+	cfg := Config{Enabled: os.Getenv("RATE_LIMIT_ENABLED") != "false", Routes: map[string]Rule{}}
+
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+		cfg.Store = NewMemoryStore()
+	case "mongo":
+		cfg.Store = MongoStore{}
+	default:
+		return Config{}, fmt.Errorf("RATE_LIMIT_BACKEND must be memory or mongo, got %q", backend)
+	}
+
+	def := os.Getenv("RATE_LIMIT_DEFAULT")
+	if def == "" {
+		def = "120/1m"
+	}
+	rule, err := ParseRule(def)
+	if err != nil {
+		return Config{}, fmt.Errorf("RATE_LIMIT_DEFAULT: %w", err)
+	}
+	cfg.Default = rule
+
+	for _, entry := range strings.Split(os.Getenv("RATE_LIMIT_ROUTES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("RATE_LIMIT_ROUTES: %q is not route=N/window", entry)
+		}
+		rule, err := ParseRule(spec)
+		if err != nil {
+			return Config{}, fmt.Errorf("RATE_LIMIT_ROUTES: %s: %w", route, err)
+		}
+		cfg.Routes[strings.Join(strings.Fields(route), " ")] = rule
+	}
+	return cfg, nil
+}
+
+// ParseRule parses "N/window", where window is a Go duration such as 1m or 1h.
+func ParseRule(s string) (Rule, error) {
+	count, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("%q is not N/window", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Rule{}, fmt.Errorf("%q: request count must be a positive integer", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Rule{}, fmt.Errorf("%q: window must be a positive duration", s)
+	}
+	return Rule{N: n, Window: d}, nil
+}
+
+// RouteRule returns the rule configured for a route, preferring a method-specific one.
+func (c Config) RouteRule(method, path string) (Rule, bool) {
+	if rule, ok := c.Routes[method+" "+path]; ok {
+		return rule, true
+	}
+	rule, ok := c.Routes[path]
+	return rule, ok
+}
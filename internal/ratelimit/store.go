@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Result is the outcome of spending one request against a limit.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the limit is fully available again
+	RetryAfter time.Duration // Until the next request is allowed, when denied
+}
+
+// Store counts requests per key against a limit of n requests per window.
+type Store interface {
+	Take(ctx context.Context, key string, n int, window time.Duration, now time.Time) (Result, error)
+}
+
+// MemoryStore keeps token buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{limiters: map[string]*Limiter{}}
+}
+
+// Take spends a token from key's bucket, which holds n tokens and refills over window.
+func (s *MemoryStore) Take(_ context.Context, key string, n int, window time.Duration, now time.Time) (Result, error) {
+	s.mutex.Lock()
+	id := fmt.Sprintf("%d/%s", n, window)
+	limiter, ok := s.limiters[id]
+	if !ok {
+		limiter = &Limiter{Rate: float64(n) / window.Seconds(), Burst: n, buckets: map[string]*bucket{}}
+		s.limiters[id] = limiter
+	}
+	s.mutex.Unlock()
+
+	allowed, tokens, wait := limiter.take(key, now)
+	return Result{
+		Allowed:    allowed,
+		Limit:      n,
+		Remaining:  int(math.Floor(tokens)),
+		Reset:      limiter.refill(float64(n) - tokens),
+		RetryAfter: wait,
+	}, nil
+}
+
+// MongoStore counts requests in fixed windows in MongoDB, so every instance behind a
+// load balancer shares the same limits. Counters expire through a TTL index on expires_at.
+type MongoStore struct{}
+
+func rateLimitsCollectionName() string {
+	return db.CollectionName("MONGO_RATE_LIMITS_COLLECTION", "rate_limits")
+}
+
+// Take increments key's counter for the current window.
+func (MongoStore) Take(ctx context.Context, key string, n int, window time.Duration, now time.Time) (Result, error) {
+	start := now.Truncate(window)
+	end := start.Add(window)
+
+	coll := db.GetMongoClient().Database(db.DBName).Collection(rateLimitsCollectionName())
+	update := bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expires_at": end},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	id := fmt.Sprintf("%s|%d", key, start.Unix())
+	if err := coll.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&counter); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   counter.Count <= n,
+		Limit:     n,
+		Remaining: max(n-counter.Count, 0),
+		Reset:     end.Sub(now),
+	}
+	if !res.Allowed {
+		res.RetryAfter = res.Reset
+	}
+	return res, nil
+}
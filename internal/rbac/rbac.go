@@ -0,0 +1,199 @@
+package rbac
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Permission names an action on a kind of resource.
+type Permission string
+
+const (
+	DataRead     Permission = "data:read"
+	DataExport   Permission = "data:export"
+	DevicesRead  Permission = "devices:read"
+	DevicesWrite Permission = "devices:write"
+	DevicesAll   Permission = "devices:all" // Not limited to the user's device groups
+	KPIsRead     Permission = "kpis:read"
+	KPIsWrite    Permission = "kpis:write"
+	UsersWrite   Permission = "users:write"
+	AuditRead    Permission = "audit:read"
+)
+
+// AllGroups in a user's device groups grants access to every device.
+const AllGroups = "*"
+
+// builtinRoles are always defined. RBAC_ROLES can add roles or replace these.
+var builtinRoles = map[string][]Permission{
+	"admin": {DataRead, DataExport, DevicesRead, DevicesWrite, DevicesAll, KPIsRead, KPIsWrite, UsersWrite, AuditRead},
+	"user":  {DataRead, DevicesRead, KPIsRead},
+}
+
+var (
+	rolesOnce sync.Once
+	roles     map[string]map[Permission]bool
+	rolesErr  error
+)
+
+// LoadRoles parses RBAC_ROLES, e.g. "operator=data:read|devices:read|devices:write;auditor=data:export",
+// on top of the built-in admin and user roles. It is called once at startup so a typo
+// fails fast instead of silently denying requests.
+func LoadRoles() error {
+	rolesOnce.Do(func() {
+		roles, rolesErr = parseRoles(os.Getenv("RBAC_ROLES"))
+	})
+	return rolesErr
+}
+
+func parseRoles(spec string) (map[string]map[Permission]bool, error) {
+	parsed := map[string]map[Permission]bool{}
+	for role, perms := range builtinRoles {
+		parsed[role] = toSet(perms)
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		role, list, ok := strings.Cut(entry, "=")
+		role = strings.TrimSpace(role)
+		if !ok || role == "" {
+			return nil, fmt.Errorf("RBAC_ROLES: %q is not role=permission|permission", entry)
+		}
+		var perms []Permission
+		for _, p := range strings.Split(list, "|") {
+			perm := Permission(strings.TrimSpace(p))
+			if !IsPermission(string(perm)) {
+				return nil, fmt.Errorf("RBAC_ROLES: role %s has unknown permission %q", role, perm)
+			}
+			perms = append(perms, perm)
+		}
+		parsed[role] = toSet(perms)
+	}
+	return parsed, nil
+}
+
+func toSet(perms []Permission) map[Permission]bool {
+	set := make(map[Permission]bool, len(perms))
+	for _, p := range perms {
+		set[p] = true
+	}
+	return set
+}
+
+// Has reports whether the role grants the permission. Unknown roles have no permissions.
+func Has(role string, perm Permission) bool {
+	if LoadRoles() != nil {
+		return false
+	}
+	return roles[role][perm]
+}
+
+// Permissions lists the permissions the role grants in alphabetical order.
+func Permissions(role string) []Permission {
+	if LoadRoles() != nil {
+		return nil
+	}
+	perms := make([]Permission, 0, len(roles[role]))
+	for perm := range roles[role] {
+		perms = append(perms, perm)
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
+
+// IsPermission reports whether p names a known permission.
+func IsPermission(p string) bool {
+	for _, perms := range builtinRoles {
+		for _, perm := range perms {
+			if string(perm) == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsRole reports whether the role is defined.
+func IsRole(role string) bool {
+	if LoadRoles() != nil {
+		return false
+	}
+	_, ok := roles[role]
+	return ok
+}
+
+// Roles lists the defined roles in alphabetical order.
+func Roles() []string {
+	if LoadRoles() != nil {
+		return nil
+	}
+	names := make([]string, 0, len(roles))
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scope is the set of devices a request may see. A scope with a TenantID is further
+// limited to documents of that tenant, so readings stored before a device joined the
+// tenant, which have no tenant_id or another one, stay hidden from it.
+type Scope struct {
+	All       bool
+	DeviceIDs []string
+	TenantID  string
+}
+
+// Allows reports whether the device is in scope.
+func (s Scope) Allows(deviceID string) bool {
+	if s.All {
+		return true
+	}
+	for _, id := range s.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply restricts a MongoDB filter on device_id to the scope. A filter already naming a
+// device outside the scope is changed to match nothing.
+func (s Scope) Apply(filter bson.M) bson.M {
+	if s.TenantID != "" {
+		filter["tenant_id"] = s.TenantID
+	}
+	if s.All {
+		return filter
+	}
+	if id, ok := filter["device_id"].(string); ok {
+		if !s.Allows(id) {
+			filter["device_id"] = bson.M{"$in": bson.A{}}
+		}
+		return filter
+	}
+	ids := make(bson.A, len(s.DeviceIDs))
+	for i, id := range s.DeviceIDs {
+		ids[i] = id
+	}
+	filter["device_id"] = bson.M{"$in": ids}
+	return filter
+}
+
+// Narrow returns the scope limited to the given devices.
+func (s Scope) Narrow(deviceIDs []string) Scope {
+	narrowed := Scope{DeviceIDs: []string{}, TenantID: s.TenantID}
+	for _, id := range deviceIDs {
+		if s.Allows(id) {
+			narrowed.DeviceIDs = append(narrowed.DeviceIDs, id)
+		}
+	}
+	return narrowed
+}
@@ -0,0 +1,105 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/kpi"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Raw data is only aggregated on the fly for short ranges; anything longer is served
+// from the rollup collections.
+const (
+	maxMinuteRange = 6 * time.Hour
+	maxHourRange   = 31 * day
+)
+
+// ErrResolutionUnavailable is returned when the requested resolution has already
+// expired for the requested range.
+var ErrResolutionUnavailable = errors.New("resolution not retained for the requested range")
+
+// ChooseResolution picks the finest resolution that is still retained for the range
+// and small enough to return.
+func ChooseResolution(cfg Config, from, to, now time.Time) string {
+	span := to.Sub(from)
+	if span <= maxMinuteRange && retained(cfg.Raw, from, now) {
+		return models.ResolutionMinute
+	}
+	if span <= maxHourRange && retained(cfg.Hourly, from, now) {
+		return models.ResolutionHour
+	}
+	return models.ResolutionDay
+}
+
+// Query returns the rollups of one device between from and to at the given resolution,
+// or at the one picked by ChooseResolution when resolution is empty. The bucket still in
+// progress, which the rollup job has not written yet, is computed from raw data.
+func Query(ctx context.Context, cfg Config, deviceID string, from, to time.Time, resolution string) (string, []models.Rollup, error) {
+	now := time.Now().UTC()
+	if resolution == "" {
+		resolution = ChooseResolution(cfg, from, to, now)
+	}
+
+	var retention, size time.Duration
+	switch resolution {
+	case models.ResolutionMinute:
+		retention, size = cfg.Raw, time.Minute
+	case models.ResolutionHour:
+		retention, size = cfg.Hourly, time.Hour
+	case models.ResolutionDay:
+		retention, size = cfg.Daily, day
+	default:
+		return "", nil, errors.New("unknown resolution")
+	}
+	if !retained(retention, from, now) {
+		return resolution, nil, ErrResolutionUnavailable
+	}
+
+	filter := bson.M{"device_id": deviceID}
+	if resolution == models.ResolutionMinute {
+		rollups, err := RawRollups(ctx, filter, kpi.Window{Start: from, End: to}, size, resolution)
+		return resolution, rollups, err
+	}
+
+	// Rollups cover whole buckets, so the last bucket written is the one before the
+	// bucket that contains now.
+	stored := to
+	if current := now.Truncate(size); stored.After(current) {
+		stored = current
+	}
+
+	var rollups []models.Rollup
+	if from.Before(stored) {
+		query := bson.M{"device_id": deviceID, "bucket": bson.M{"$gte": from.Truncate(size), "$lt": stored}}
+		opts := options.Find().SetSort(bson.D{{Key: "bucket", Value: 1}})
+		cursor, err := RollupCollection(resolution).Find(ctx, query, opts)
+		if err != nil {
+			return resolution, nil, err
+		}
+		defer cursor.Close(ctx)
+		if err := cursor.All(ctx, &rollups); err != nil {
+			return resolution, nil, err
+		}
+	}
+
+	tailStart := stored
+	if from.After(tailStart) {
+		tailStart = from
+	}
+	if to.After(tailStart) && retained(cfg.Raw, tailStart, now) {
+		tail, err := RawRollups(ctx, filter, kpi.Window{Start: tailStart, End: to}, size, resolution)
+		if err != nil {
+			return resolution, nil, err
+		}
+		rollups = append(rollups, tail...)
+	}
+	return resolution, rollups, nil
+}
+
+func retained(retention time.Duration, from, now time.Time) bool {
+	return retention <= 0 || !from.Before(now.Add(-retention))
+}
@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var logger = logging.For("retention")
+
+const day = 24 * time.Hour
+
+// Config holds how long each collection keeps its documents. A zero duration keeps
+// documents forever.
+type Config struct {
+	Raw               time.Duration
+	Hourly            time.Duration
+	Daily             time.Duration
+	KPIs              time.Duration
+	MeshEvents        time.Duration
+	RollupInterval    time.Duration
+	RollupLookback    time.Duration // How far back the first hourly rollup run reads raw data
+	DailyLookbackDays int
+}
+
+// LoadConfig reads the retention settings from the environment.
+func LoadConfig() Config {
+	return Config{
+		Raw:               time.Duration(envInt("RETENTION_RAW_DAYS", 30)) * day,
+		Hourly:            time.Duration(envInt("RETENTION_HOURLY_DAYS", 365)) * day,
+		Daily:             time.Duration(envInt("RETENTION_DAILY_DAYS", 0)) * day,
+		KPIs:              time.Duration(envInt("RETENTION_KPIS_DAYS", 0)) * day,
+		MeshEvents:        time.Duration(envInt("RETENTION_MESH_EVENTS_DAYS", 90)) * day,
+		RollupInterval:    time.Duration(envInt("ROLLUP_INTERVAL_MINUTES", 15)) * time.Minute,
+		RollupLookback:    time.Duration(envInt("ROLLUP_LOOKBACK_HOURS", 48)) * time.Hour,
+		DailyLookbackDays: envInt("ROLLUP_DAILY_LOOKBACK_DAYS", 3),
+	}
+}
+
+// TTLIndexName is the name of the TTL index managed on each collection.
+const TTLIndexName = "retention_ttl"
+
+// EnsureTTLIndexes creates, updates or drops the TTL indexes so they match cfg.
+func EnsureTTLIndexes(ctx context.Context, cfg Config) error {
+	targets := []struct {
+		coll  *mongo.Collection
+		field string
+		ttl   time.Duration
+	}{
+		{db.SensorsCollection(), "timestamp", cfg.Raw},
+		{RollupCollection(models.ResolutionHour), "bucket", cfg.Hourly},
+		{RollupCollection(models.ResolutionDay), "bucket", cfg.Daily},
+		{db.KPIsCollection(), "timestamp", cfg.KPIs},
+		{db.MeshEventsCollection(), "time", cfg.MeshEvents},
+	}
+
+	for _, t := range targets {
+		if err := ensureTTLIndex(ctx, t.coll, t.field, t.ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ensureTTLIndex(ctx context.Context, coll *mongo.Collection, field string, ttl time.Duration) error {
+	indexes := coll.Indexes()
+	if ttl <= 0 {
+		if _, err := indexes.DropOne(ctx, TTLIndexName); err != nil && !isIndexNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	seconds := int32(ttl.Seconds())
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetName(TTLIndexName).SetExpireAfterSeconds(seconds),
+	}
+	_, err := indexes.CreateOne(ctx, model)
+	if err == nil {
+		logger.Info("TTL index created", "collection", coll.Name(), "field", field, "ttl", ttl.String())
+		return nil
+	}
+	if !isIndexOptionsConflict(err) {
+		return err
+	}
+
+	// The index exists with another TTL: change it in place instead of rebuilding it.
+	cmd := bson.D{
+		{Key: "collMod", Value: coll.Name()},
+		{Key: "index", Value: bson.M{"name": TTLIndexName, "expireAfterSeconds": seconds}},
+	}
+	if err := coll.Database().RunCommand(ctx, cmd).Err(); err != nil {
+		return err
+	}
+	logger.Info("TTL index updated", "collection", coll.Name(), "field", field, "ttl", ttl.String())
+	return nil
+}
+
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 27 || cmdErr.Code == 26) // IndexNotFound, NamespaceNotFound
+}
+
+func isIndexOptionsConflict(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 85 || cmdErr.Code == 86) // IndexOptionsConflict, IndexKeySpecsConflict
+}
+
+// RollupCollection returns the collection holding rollups of the given resolution.
+func RollupCollection(resolution string) *mongo.Collection {
+	key, name := "MONGO_ROLLUPS_HOURLY_COLLECTION", "sensor_rollups_hourly"
+	if resolution == models.ResolutionDay {
+		key, name = "MONGO_ROLLUPS_DAILY_COLLECTION", "sensor_rollups_daily"
+	}
+	return db.GetMongoClient().Database(db.DBName).Collection(db.CollectionName(key, name))
+}
+
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
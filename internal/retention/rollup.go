@@ -0,0 +1,317 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/jobs"
+	"github.com/rednexx46/esp32-backend-api/internal/kpi"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RegisterJob registers the rollup job with the scheduler, unless ROLLUP_ENABLED is
+// set to "false".
+func RegisterJob(cfg Config) {
+	if os.Getenv("ROLLUP_ENABLED") == "false" {
+		logger.Info("Rollups disabled")
+		return
+	}
+
+	jobs.Register(jobs.Job{
+		Name:     "rollup",
+		Interval: cfg.RollupInterval,
+		Timeout:  cfg.RollupInterval,
+		Run: func(ctx context.Context) error {
+			return RunRollups(ctx, cfg, time.Now())
+		},
+	})
+}
+
+// insertOverlap is how far before the high-water mark each run starts reading, so
+// readings whose _id was generated by a writer whose clock is up to this much behind
+// are not missed. Recomputing a bucket replaces it, so reading them twice is harmless.
+const insertOverlap = 5 * time.Minute
+
+// rollupState is the high-water mark of the hourly rollups: every reading inserted
+// before ProcessedUntil is reflected in them.
+type rollupState struct {
+	ID             string    `bson:"_id"`
+	ProcessedUntil time.Time `bson:"processed_until"`
+}
+
+const hourlyStateID = "hourly"
+
+func rollupStateCollection() *mongo.Collection {
+	return db.GetMongoClient().Database(db.DBName).Collection(db.CollectionName("MONGO_ROLLUP_STATE_COLLECTION", "rollup_state"))
+}
+
+// RunRollups recomputes the hourly rollups of the device hours that received readings
+// since the previous run, found from the insertion time in the readings' _id, and then
+// the daily rollups for the last cfg.DailyLookbackDays completed days from the hourly
+// ones. Only readings inserted before the current hour are read, and the first run
+// starts cfg.RollupLookback back. Raw data must still exist for the hours being
+// recomputed, which holds as long as readings arrive well within cfg.Raw.
+//
+// Readings must have the ObjectID _id the MongoDB drivers generate, from a clock at
+// most insertOverlap behind. Readings with another kind of _id are never found this
+// way; a warning is logged when the period has any.
+func RunRollups(ctx context.Context, cfg Config, now time.Time) error {
+	until := now.UTC().Truncate(time.Hour)
+	var state rollupState
+	err := rollupStateCollection().FindOne(ctx, bson.M{"_id": hourlyStateID}).Decode(&state)
+	from := state.ProcessedUntil.Add(-insertOverlap)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		from = until.Add(-cfg.RollupLookback)
+	} else if err != nil {
+		return fmt.Errorf("rollup state: %w", err)
+	}
+
+	if from.Before(until) {
+		touched, err := insertedBetween(ctx, from, until)
+		if err != nil {
+			return fmt.Errorf("hourly rollup targets: %w", err)
+		}
+		warnUntracked(ctx, from, until)
+		for _, hour := range touched.Hours() {
+			w := kpi.Window{Start: hour, End: hour.Add(time.Hour)}
+			if err := rollupHour(ctx, w, touched.Devices(hour)); err != nil {
+				return fmt.Errorf("hourly rollup %s: %w", w.Start.Format(time.RFC3339), err)
+			}
+		}
+
+		// $max keeps the mark from moving back when an older run finishes last
+		_, err = rollupStateCollection().UpdateOne(ctx, bson.M{"_id": hourlyStateID},
+			bson.M{"$max": bson.M{"processed_until": until}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("rollup state: %w", err)
+		}
+	}
+
+	for _, w := range kpi.CompletedWindows(now, day, cfg.DailyLookbackDays) {
+		if err := rollupDay(ctx, w); err != nil {
+			return fmt.Errorf("daily rollup %s: %w", w.Start.Format(time.RFC3339), err)
+		}
+	}
+	return nil
+}
+
+// insertedBetween returns the devices with readings inserted between from and until,
+// by the hour of the readings' timestamps. Only device_id and timestamp are read, so
+// payloads are not decrypted.
+func insertedBetween(ctx context.Context, from, until time.Time) (TouchedHours, error) {
+	filter := bson.M{"_id": bson.M{
+		"$gte": primitive.NewObjectIDFromTimestamp(from),
+		"$lt":  primitive.NewObjectIDFromTimestamp(until),
+	}}
+	opts := options.Find().SetProjection(bson.M{"device_id": 1, "timestamp": 1})
+	cursor, err := db.SensorsCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	touched := TouchedHours{}
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err == nil {
+			touched.Add(doc)
+		}
+	}
+	return touched, cursor.Err()
+}
+
+// warnUntracked logs a warning when readings timestamped between from and until have
+// an _id that is not an ObjectID, as insertedBetween cannot find them.
+func warnUntracked(ctx context.Context, from, until time.Time) {
+	filter := bson.M{
+		"timestamp": bson.M{"$gte": from, "$lt": until},
+		"_id":       bson.M{"$not": bson.M{"$type": "objectId"}},
+	}
+	n, err := db.SensorsCollection().CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		logger.Warn("Failed to look for readings without an ObjectID _id", "error", err)
+		return
+	}
+	if n > 0 {
+		logger.Warn("Readings without an ObjectID _id are not rolled up", "from", from, "until", until)
+	}
+}
+
+// TouchedHours holds the devices with readings in each hour.
+type TouchedHours map[time.Time]map[string]bool
+
+// Add records the reading in a sensor document. Documents without a device or a
+// timestamp are skipped.
+func (t TouchedHours) Add(doc bson.M) {
+	deviceID, sample, ok := kpi.SampleFromDocument(doc)
+	if !ok {
+		return
+	}
+	hour := sample.Timestamp.UTC().Truncate(time.Hour)
+	if t[hour] == nil {
+		t[hour] = map[string]bool{}
+	}
+	t[hour][deviceID] = true
+}
+
+// Hours returns the hours with readings, oldest first.
+func (t TouchedHours) Hours() []time.Time {
+	hours := make([]time.Time, 0, len(t))
+	for hour := range t {
+		hours = append(hours, hour)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+	return hours
+}
+
+// Devices returns the devices with readings in the hour, sorted.
+func (t TouchedHours) Devices(hour time.Time) []string {
+	devices := make([]string, 0, len(t[hour]))
+	for deviceID := range t[hour] {
+		devices = append(devices, deviceID)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// rollupHour recomputes the hourly rollups of the devices in w from raw data.
+func rollupHour(ctx context.Context, w kpi.Window, deviceIDs []string) error {
+	rollups, err := RawRollups(ctx, bson.M{"device_id": bson.M{"$in": deviceIDs}}, w, time.Hour, models.ResolutionHour)
+	if err != nil {
+		return err
+	}
+	return saveRollups(ctx, RollupCollection(models.ResolutionHour), rollups)
+}
+
+func rollupDay(ctx context.Context, w kpi.Window) error {
+	filter := bson.M{"bucket": bson.M{"$gte": w.Start, "$lt": w.End}}
+	cursor, err := RollupCollection(models.ResolutionHour).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	byDevice := map[string]*models.Rollup{}
+	for cursor.Next(ctx) {
+		var hourly models.Rollup
+		if err := cursor.Decode(&hourly); err != nil {
+			continue
+		}
+		daily, ok := byDevice[hourly.DeviceID]
+		if !ok {
+			daily = newRollup(hourly.DeviceID, models.ResolutionDay, w.Start)
+			byDevice[hourly.DeviceID] = daily
+		}
+		merge(daily, hourly)
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	rollups := make([]models.Rollup, 0, len(byDevice))
+	for _, r := range byDevice {
+		rollups = append(rollups, *r)
+	}
+	return saveRollups(ctx, RollupCollection(models.ResolutionDay), rollups)
+}
+
+// RawRollups aggregates the raw sensor documents matching filter within w into
+// buckets of the given size. Payloads are decoded in Go because they may be
+// encrypted, which rules out a server-side aggregation pipeline.
+func RawRollups(ctx context.Context, filter bson.M, w kpi.Window, size time.Duration, resolution string) ([]models.Rollup, error) {
+	query := bson.M{"timestamp": bson.M{"$gte": w.Start, "$lt": w.End}}
+	for k, v := range filter {
+		query[k] = v
+	}
+
+	cursor, err := db.SensorsCollection().Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	type key struct {
+		device string
+		bucket time.Time
+	}
+	buckets := map[key]*models.Rollup{}
+	var order []key
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		deviceID, sample, ok := kpi.SampleFromDocument(doc)
+		if !ok {
+			continue
+		}
+
+		k := key{deviceID, sample.Timestamp.UTC().Truncate(size)}
+		r, ok := buckets[k]
+		if !ok {
+			r = newRollup(deviceID, resolution, k.bucket)
+			buckets[k] = r
+			order = append(order, k)
+		}
+		r.Messages++
+		for metric, v := range sample.Values {
+			stats := r.Metrics[metric]
+			stats.Add(models.MetricStats{Count: 1, Sum: v, Min: v, Max: v, Mean: v})
+			r.Metrics[metric] = stats
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	rollups := make([]models.Rollup, 0, len(order))
+	for _, k := range order {
+		rollups = append(rollups, *buckets[k])
+	}
+	return rollups, nil
+}
+
+func newRollup(deviceID, resolution string, bucket time.Time) *models.Rollup {
+	return &models.Rollup{
+		DeviceID:   deviceID,
+		Resolution: resolution,
+		Bucket:     bucket,
+		Metrics:    map[string]models.MetricStats{},
+	}
+}
+
+func merge(dst *models.Rollup, src models.Rollup) {
+	dst.Messages += src.Messages
+	for metric, stats := range src.Metrics {
+		merged := dst.Metrics[metric]
+		merged.Add(stats)
+		dst.Metrics[metric] = merged
+	}
+}
+
+// saveRollups upserts on device and bucket, so recomputing a bucket replaces it.
+func saveRollups(ctx context.Context, coll *mongo.Collection, rollups []models.Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	writes := make([]mongo.WriteModel, 0, len(rollups))
+	for _, r := range rollups {
+		r.UpdatedAt = now
+		filter := bson.M{"device_id": r.DeviceID, "bucket": r.Bucket}
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(r).SetUpsert(true))
+	}
+
+	_, err := coll.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
@@ -0,0 +1,254 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrDisabled is returned when OIDC login is not configured.
+var ErrDisabled = errors.New("OIDC login is disabled")
+
+// ErrNoRole is returned when none of the user's IdP groups maps to a local role.
+var ErrNoRole = errors.New("no local role for the user's groups")
+
+// ErrNoTenant is returned when OIDC_TENANT_CLAIM is set but the user has no tenant.
+var ErrNoTenant = errors.New("no tenant for the user")
+
+// RoleMapping maps one IdP group to a local role.
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+// Config describes the OpenID Connect provider and how its users map to local accounts.
+type Config struct {
+	Enabled           bool
+	IssuerURL         string
+	ClientID          string
+	ClientSecret      string // Optional; public clients rely on PKCE alone
+	RedirectURL       string
+	Scopes            []string
+	UsernameClaim     string
+	GroupsClaim       string
+	RoleMappings      []RoleMapping // First match wins, so list the most privileged first
+	DefaultRole       string        // For users in no mapped group; empty denies them
+	TenantClaim       string        // Claim naming the user's organization; empty makes OIDC users platform users
+	DefaultTenant     string        // For users without the tenant claim
+	PostLoginRedirect string        // Optional frontend URL receiving the token in the fragment
+}
+
+// LoadConfig reads the OIDC settings from the environment.
+func LoadConfig() (Config, error) {
+	cfg := Config{
+		Enabled:           os.Getenv("OIDC_ENABLED") == "true",
+		IssuerURL:         os.Getenv("OIDC_ISSUER_URL"),
+		ClientID:          os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:      os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:       os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:            []string{oidc.ScopeOpenID, "profile", "email"},
+		UsernameClaim:     envOr("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:       envOr("OIDC_GROUPS_CLAIM", "groups"),
+		DefaultRole:       os.Getenv("OIDC_DEFAULT_ROLE"),
+		TenantClaim:       os.Getenv("OIDC_TENANT_CLAIM"),
+		DefaultTenant:     os.Getenv("OIDC_DEFAULT_TENANT"),
+		PostLoginRedirect: os.Getenv("OIDC_POST_LOGIN_REDIRECT"),
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if extra := os.Getenv("OIDC_SCOPES"); extra != "" {
+		cfg.Scopes = append([]string{oidc.ScopeOpenID}, strings.Fields(strings.ReplaceAll(extra, ",", " "))...)
+	}
+	for _, entry := range strings.Split(os.Getenv("OIDC_ROLE_MAPPING"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(entry, "=")
+		if !ok || group == "" || role == "" {
+			return Config{}, fmt.Errorf("OIDC_ROLE_MAPPING: %q is not group=role", entry)
+		}
+		cfg.RoleMappings = append(cfg.RoleMappings, RoleMapping{Group: group, Role: role})
+	}
+
+	if cfg.IssuerURL == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return Config{}, errors.New("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ENABLED=true")
+	}
+	return cfg, nil
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Role returns the local role for a user in the given IdP groups.
+func (c Config) Role(groups []string) (string, error) {
+	for _, m := range c.RoleMappings {
+		for _, g := range groups {
+			if g == m.Group {
+				return m.Role, nil
+			}
+		}
+	}
+	if c.DefaultRole != "" {
+		return c.DefaultRole, nil
+	}
+	return "", ErrNoRole
+}
+
+// Tenant returns the organization of a user whose tenant claim has the given value.
+// Without OIDC_TENANT_CLAIM or OIDC_DEFAULT_TENANT every user is a platform user; with
+// OIDC_TENANT_CLAIM, a user with neither the claim nor a default is denied rather than
+// given access to every tenant.
+func (c Config) Tenant(claimed string) (string, error) {
+	if claimed != "" && c.TenantClaim != "" {
+		return claimed, nil
+	}
+	if c.DefaultTenant != "" {
+		return c.DefaultTenant, nil
+	}
+	if c.TenantClaim != "" {
+		return "", ErrNoTenant
+	}
+	return "", nil
+}
+
+// Identity is the verified user returned by the IdP.
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Groups   []string
+	Tenant   string // Value of the tenant claim, if configured
+}
+
+// Client runs the authorization-code flow against one provider. Discovery happens on
+// first use, so the API starts even while the IdP is unreachable.
+type Client struct {
+	cfg Config
+
+	mutex    sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewClient returns a client for the configured provider.
+func NewClient(cfg Config) *Client {
+	return &Client{cfg: cfg}
+}
+
+// Config returns the client's settings.
+func (c *Client) Config() Config {
+	return c.cfg
+}
+
+func (c *Client) discover(ctx context.Context) (*oidc.Provider, error) {
+	if !c.cfg.Enabled {
+		return nil, ErrDisabled
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	provider, err := oidc.NewProvider(ctx, c.cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	c.provider = provider
+	return provider, nil
+}
+
+func (c *Client) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		RedirectURL:  c.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       c.cfg.Scopes,
+	}
+}
+
+// AuthCodeURL returns the IdP URL that starts a login bound to state, nonce and the
+// PKCE verifier, which the caller keeps until the callback.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.oauth2Config(provider).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	), nil
+}
+
+// Exchange redeems the authorization code, verifies the ID token's signature, audience,
+// expiry and nonce, and returns the identity it asserts.
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	token, err := c.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("code exchange failed: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, errors.New("ID token nonce mismatch")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	return c.identity(idToken.Issuer, idToken.Subject, claims)
+}
+
+func (c *Client) identity(issuer, subject string, claims map[string]any) (Identity, error) {
+	id := Identity{Issuer: issuer, Subject: subject}
+	id.Username, _ = claims[c.cfg.UsernameClaim].(string)
+	id.Email, _ = claims["email"].(string)
+	if c.cfg.TenantClaim != "" {
+		id.Tenant, _ = claims[c.cfg.TenantClaim].(string)
+	}
+	if id.Username == "" {
+		id.Username = id.Email
+	}
+	if id.Username == "" {
+		return Identity{}, fmt.Errorf("ID token has no %s or email claim", c.cfg.UsernameClaim)
+	}
+
+	switch groups := claims[c.cfg.GroupsClaim].(type) {
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = []string{groups}
+	}
+	return id, nil
+}
@@ -0,0 +1,70 @@
+package sso
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+// FlowTTL bounds how long a user may take at the IdP before the login must restart.
+const FlowTTL = 10 * time.Minute
+
+// Flow holds the per-login secrets that must survive the round trip to the IdP. It is
+// kept in a signed, HttpOnly cookie, so any API instance can complete the login.
+type Flow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// NewFlow returns fresh random values for a login.
+func NewFlow(now time.Time) Flow {
+	return Flow{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: oauth2.GenerateVerifier(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(FlowTTL)),
+		},
+	}
+}
+
+// errNoSecret guards against sealing with an empty key, which anyone could forge.
+var errNoSecret = errors.New("no flow cookie secret")
+
+// Seal signs the flow for storage in a cookie.
+func (f Flow) Seal(secret []byte) (string, error) {
+	if len(secret) == 0 {
+		return "", errNoSecret
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, f).SignedString(secret)
+}
+
+// OpenFlow verifies a sealed flow and checks that it belongs to the returned state.
+func OpenFlow(sealed, state string, secret []byte) (Flow, error) {
+	if len(secret) == 0 {
+		return Flow{}, errNoSecret
+	}
+	var f Flow
+	_, err := jwt.ParseWithClaims(sealed, &f, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return Flow{}, err
+	}
+	if f.State == "" || f.State != state {
+		return Flow{}, errors.New("state mismatch")
+	}
+	return f, nil
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,192 @@
+// Package tenant maps MQTT topics to the organizations that own them and assigns the
+// devices publishing under an organization's topic prefix to that organization.
+package tenant
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+)
+
+var logger = logging.For("tenant")
+
+// RefreshInterval is how long topic prefixes are cached before they are read again, so
+// new organizations start receiving data within a minute without a restart.
+const RefreshInterval = time.Minute
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// ValidID reports whether id can name an organization: a short lowercase slug, safe in
+// topics, logs and filenames.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
+
+// ValidPrefix reports whether prefix can be a topic prefix: whole topic levels ending in
+// "/", so "sites/acme/" never matches "sites/acme-lab/...", and no MQTT wildcards.
+func ValidPrefix(prefix string) bool {
+	return len(prefix) > 1 && strings.HasSuffix(prefix, "/") && !strings.ContainsAny(prefix, "+#")
+}
+
+// levels returns the prefix ending in "/", so prefixes stored before ValidPrefix was
+// enforced still match whole topic levels only.
+func levels(prefix string) string {
+	if strings.HasSuffix(prefix, "/") {
+		return prefix
+	}
+	return prefix + "/"
+}
+
+// Match returns the organization whose topic prefix is the longest prefix of the topic,
+// or "" if none matches.
+func Match(orgs []models.Organization, topic string) string {
+	tenantID, _ := match(orgs, topic)
+	return tenantID
+}
+
+// DeviceLevel returns the topic level right after the matching topic prefix, which is
+// where a device publishes its own ID, e.g. "esp32-01" for "sites/acme/esp32-01/data".
+// It returns "" if no prefix matches.
+func DeviceLevel(orgs []models.Organization, topic string) string {
+	_, prefix := match(orgs, topic)
+	if prefix == "" {
+		return ""
+	}
+	level, _, _ := strings.Cut(strings.TrimPrefix(topic, prefix), "/")
+	return level
+}
+
+// match returns the organization whose topic prefix is the longest prefix of the topic
+// and that prefix ending in "/", or "" and "" if none matches.
+func match(orgs []models.Organization, topic string) (string, string) {
+	var tenantID, matched string
+	for _, org := range orgs {
+		for _, prefix := range org.TopicPrefixes {
+			if prefix != "" && len(levels(prefix)) > len(matched) && strings.HasPrefix(topic, levels(prefix)) {
+				tenantID, matched = org.ID, levels(prefix)
+			}
+		}
+	}
+	return tenantID, matched
+}
+
+// PrefixOwner returns the organization other than exclude whose topic prefixes overlap
+// the prefix, or "" if none does. Prefixes overlap when one contains the other, so each
+// topic belongs to at most one organization.
+func PrefixOwner(orgs []models.Organization, prefix, exclude string) string {
+	prefix = levels(prefix)
+	for _, org := range orgs {
+		if org.ID == exclude {
+			continue
+		}
+		for _, p := range org.TopicPrefixes {
+			p = levels(p)
+			if strings.HasPrefix(p, prefix) || strings.HasPrefix(prefix, p) {
+				return org.ID
+			}
+		}
+	}
+	return ""
+}
+
+// Router resolves the tenant of incoming MQTT messages.
+type Router struct {
+	loadOrgs    func(ctx context.Context) ([]models.Organization, error)
+	claimDevice func(ctx context.Context, deviceID, tenantID string) (string, error)
+
+	mu        sync.Mutex
+	orgs      []models.Organization
+	loadedAt  time.Time
+	reloading bool
+	devices   map[string]string // Device ID to the tenant it belongs to
+
+	firstLoad sync.Once
+	loaded    chan struct{} // Closed once the organizations have been loaded, or failed to, once
+}
+
+// NewRouter returns a Router reading organizations with loadOrgs and assigning devices
+// with claimDevice, which returns the tenant the device belongs to afterwards.
+func NewRouter(
+	loadOrgs func(ctx context.Context) ([]models.Organization, error),
+	claimDevice func(ctx context.Context, deviceID, tenantID string) (string, error),
+) *Router {
+	return &Router{loadOrgs: loadOrgs, claimDevice: claimDevice, devices: map[string]string{}, loaded: make(chan struct{})}
+}
+
+// TenantForTopic returns the tenant owning the topic, or "" if no prefix matches. When the
+// organizations cannot be reloaded, the previously loaded prefixes keep being used. One
+// caller reloads them while the others keep matching against the cached prefixes; only
+// the very first load is waited for.
+func (r *Router) TenantForTopic(ctx context.Context, topic string) string {
+	r.mu.Lock()
+	reload := !r.reloading && time.Since(r.loadedAt) >= RefreshInterval
+	r.reloading = r.reloading || reload
+	r.mu.Unlock()
+
+	if reload {
+		orgs, err := r.loadOrgs(ctx)
+		r.mu.Lock()
+		if err != nil {
+			logger.Warn("Failed to reload organizations, using cached topic prefixes", "error", err)
+		} else {
+			r.orgs = orgs
+		}
+		r.loadedAt = time.Now()
+		r.reloading = false
+		r.mu.Unlock()
+		r.firstLoad.Do(func() { close(r.loaded) })
+	}
+
+	select {
+	case <-r.loaded:
+	case <-ctx.Done():
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return Match(r.orgs, topic)
+}
+
+// ClaimDevice assigns a device publishing under the tenant's topic prefix to the tenant
+// unless it already belongs to one. Only the device named by the topic level after the
+// prefix is assigned, as the broker ties that level to the publishing device; a
+// device_id in the payload alone is never trusted, and devices published by others,
+// such as mesh nodes forwarded by their root, must be provisioned. A device publishing
+// under another tenant's prefix is not moved; it is logged, as it is either
+// misconfigured or impersonating the device. Devices are looked up once per process.
+func (r *Router) ClaimDevice(ctx context.Context, topic, deviceID, tenantID string) {
+	if deviceID == "" || tenantID == "" {
+		return
+	}
+
+	r.mu.Lock()
+	_, known := r.devices[deviceID]
+	level := DeviceLevel(r.orgs, topic)
+	r.mu.Unlock()
+	if known {
+		return
+	}
+	if level != deviceID {
+		logger.Debug("Device ID is not the topic's device level, not assigning it", "device_id", deviceID,
+			"topic", topic, "tenant_id", tenantID)
+		return
+	}
+
+	owner, err := r.claimDevice(ctx, deviceID, tenantID)
+	if err != nil {
+		logger.Warn("Failed to assign device to tenant", "device_id", deviceID, "tenant_id", tenantID, "error", err)
+		return
+	}
+	r.mu.Lock()
+	r.devices[deviceID] = owner
+	r.mu.Unlock()
+
+	if owner != tenantID {
+		logger.Warn("Device published under another tenant's topic prefix", "device_id", deviceID,
+			"tenant_id", owner, "topic_tenant_id", tenantID)
+	}
+}
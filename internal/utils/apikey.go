@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix starts every API key, so keys are recognisable in headers and secret scanners.
+const APIKeyPrefix = "esp32_"
+
+// GenerateAPIKey returns a new API key of the form esp32_<id>_<secret>, together with
+// its lookup ID and the hash to store. The key itself is shown once and never stored.
+func GenerateAPIKey() (key, id, hash string) {
+	idBytes := make([]byte, 6)
+	secret := make([]byte, 24)
+	rand.Read(idBytes)
+	rand.Read(secret)
+
+	id = hex.EncodeToString(idBytes)
+	key = APIKeyPrefix + id + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, id, HashAPIKey(key)
+}
+
+// ParseAPIKey returns the lookup ID of a key, or false if it is not an API key.
+func ParseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(id) != 12 || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// HashAPIKey hashes a key for storage. Keys carry 192 random bits, so a fast hash is
+// enough; bcrypt would only slow down every authenticated request.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAPIKey compares a presented key with a stored hash in constant time.
+func VerifyAPIKey(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKey(key)), []byte(hash)) == 1
+}
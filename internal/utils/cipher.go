@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/rednexx46/esp32-backend-api/internal/metrics"
+)
+
+// DecryptPayloadIfNeeded sends the payload to the cipher API when ENCRYPTION is enabled
+// and returns the decrypted value, or the payload unchanged otherwise.
+func DecryptPayloadIfNeeded(payload string) string {
+	if os.Getenv("ENCRYPTION") != "true" {
+		return payload
+	}
+
+	cipherURL := os.Getenv("ENCRYPT_API_URL")
+	reqBody, _ := json.Marshal(map[string]string{
+		"payload": payload,
+	})
+
+	resp, err := http.Post(cipherURL+"decrypt", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		metrics.DecryptCalled(false)
+		return "decryption_failed"
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		metrics.DecryptCalled(false)
+		return "decryption_failed"
+	}
+
+	var res map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		metrics.DecryptCalled(false)
+		return "decryption_error"
+	}
+	metrics.DecryptCalled(true)
+	return res["decrypted"]
+}
+
+// PingCipherAPI checks that the cipher API answers HTTP requests.
+func PingCipherAPI(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, os.Getenv("ENCRYPT_API_URL"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("cipher API returned %d", resp.StatusCode)
+	}
+	return nil
+}
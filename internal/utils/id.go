@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// NewID returns a random 128-bit identifier as 32 hex characters, used for request and message IDs.
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,6 +1,14 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,3 +26,53 @@ func ComparePassword(hashedPassword, plainPassword string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(plainPassword))
 	return err == nil
 }
+
+// PasswordPolicy is the minimum strength of passwords set through the API or CLI.
+type PasswordPolicy struct {
+	MinLength  int // PASSWORD_MIN_LENGTH, default 10
+	MinClasses int // PASSWORD_MIN_CLASSES of lower, upper, digit and symbol, default 3
+}
+
+// LoadPasswordPolicy reads the password policy from the environment.
+func LoadPasswordPolicy() PasswordPolicy {
+	p := PasswordPolicy{MinLength: 10, MinClasses: 3}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH")); err == nil && v > 0 {
+		p.MinLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("PASSWORD_MIN_CLASSES")); err == nil && v >= 0 && v <= 4 {
+		p.MinClasses = v
+	}
+	return p
+}
+
+// Check returns why the password is too weak for the user, or nil.
+func (p PasswordPolicy) Check(username, password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	// bcrypt ignores everything after 72 bytes
+	if len(password) > 72 {
+		return errors.New("password must be at most 72 bytes")
+	}
+	if username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if lower+upper+digit+symbol < p.MinClasses {
+		return fmt.Errorf("password must mix at least %d of lowercase, uppercase, digits and symbols", p.MinClasses)
+	}
+	return nil
+}
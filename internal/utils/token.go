@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+)
+
+// TokenTTL returns the configured access token lifetime (TOKEN_TTL_MINUTES, default 30).
+func TokenTTL() time.Duration {
+	ttl := 30
+	if v, err := strconv.Atoi(os.Getenv("TOKEN_TTL_MINUTES")); err == nil {
+		ttl = v
+	}
+	return time.Duration(ttl) * time.Minute
+}
+
+// TokenAudience is the aud claim of access tokens (JWT_AUDIENCE, default esp32-backend-api).
+// Other services verifying tokens through the JWKS should require it.
+func TokenAudience() string {
+	if v := os.Getenv("JWT_AUDIENCE"); v != "" {
+		return v
+	}
+	return "esp32-backend-api"
+}
+
+// AccessClaims are the claims of an access token.
+type AccessClaims struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	TenantID string `json:"tenant_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateToken signs a JWT for the given user and role that expires after ttl. The token
+// belongs to no session, so it cannot be revoked; logins use GenerateSessionToken.
+func GenerateToken(username, role string, ttl time.Duration) (string, error) {
+	return GenerateSessionToken(username, role, "", "", ttl)
+}
+
+// GenerateSessionToken is GenerateToken for a token carrying the user's tenant, if any,
+// and naming its session in the jti claim.
+func GenerateSessionToken(username, role, tenantID, sessionID string, ttl time.Duration) (string, error) {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return keys.Sign(AccessClaims{
+		Username: username,
+		Role:     role,
+		TenantID: tenantID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			Issuer:    os.Getenv("JWT_ISSUER"),
+			Subject:   username,
+			Audience:  jwt.ClaimStrings{TokenAudience()},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+// ParseToken verifies an access token's signature, algorithm, expiry and audience and
+// returns its claims. Tokens issued before audiences were added carry none and are
+// still accepted until they expire.
+func ParseToken(tokenString string) (*AccessClaims, error) {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		return nil, err
+	}
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		opts = append(opts, jwt.WithIssuer(iss))
+	}
+
+	var claims AccessClaims
+	if err := keys.Parse(tokenString, &claims, opts...); err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) > 0 && !slices.Contains(claims.Audience, TokenAudience()) {
+		return nil, errors.New("token is not for this audience")
+	}
+	if claims.Username == "" || claims.Role == "" {
+		return nil, errors.New("token has no username or role")
+	}
+	return &claims, nil
+}
+
+// MFAChallengeTTL is how long a user has to enter the second factor after the password.
+const MFAChallengeTTL = 5 * time.Minute
+
+// GenerateMFAChallenge signs a short-lived token proving the user's password was correct.
+// It uses the internal state secret, so a challenge can never pass for an access token.
+func GenerateMFAChallenge(username string) (string, error) {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		return "", err
+	}
+	claims := jwt.RegisteredClaims{
+		Subject:   username,
+		Audience:  jwt.ClaimStrings{"mfa"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(MFAChallengeTTL)),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keys.StateSecret())
+}
+
+// ParseMFAChallenge verifies a challenge token and returns the username it was issued for.
+func ParseMFAChallenge(tokenString string) (string, error) {
+	keys, err := jwtkeys.Current()
+	if err != nil {
+		return "", err
+	}
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return keys.StateSecret(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience("mfa"), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", err
+	}
+	if claims.Subject == "" {
+		return "", errors.New("challenge token has no subject")
+	}
+	return claims.Subject, nil
+}
@@ -1,67 +1,210 @@
 package ws
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/metrics"
+	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
 )
 
+var logger = logging.For("ws")
+
+// Client is a WebSocket connection. Clients of a tenant only receive that tenant's
+// messages; platform clients, with no tenant, receive every message. Clients limited to
+// device groups only receive messages of devices in Scope, which is resolved when the
+// connection opens.
 type Client struct {
-	Conn *websocket.Conn
+	Conn      *websocket.Conn
+	TenantID  string
+	Username  string
+	SessionID string // Empty for tokens issued without a session
+	// AllDevices is set when the client may see every device of its tenant, so devices
+	// that join the tenant later are streamed too.
+	AllDevices bool
+	Scope      rbac.Scope
+
+	token string // Re-checked every SessionCheckInterval
+}
+
+// SessionCheckInterval is how often the token of every client is checked again, so
+// streams close once their token expires or their session is revoked elsewhere, such
+// as on another instance or with the CLI.
+const SessionCheckInterval = time.Minute
+
+type message struct {
+	tenantID string
+	deviceID string
+	data     []byte
 }
 
 var (
-	clients   = make(map[*Client]bool)
-	mutex     sync.Mutex
-	broadcast = make(chan []byte)
-	upgrader  = websocket.Upgrader{
+	clients    = make(map[*Client]bool)
+	mutex      sync.Mutex
+	broadcast  = make(chan message)
+	hubRunning atomic.Bool
+	upgrader   = websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool { return true },
 	}
 )
 
-// StartHub initializes the broadcast loop
-func StartHub() {
-	go func() {
-		for msg := range broadcast {
+// StartHub runs the broadcast loop until ctx is cancelled, then closes every client
+// connection with a close frame. It blocks, so callers run it in a goroutine.
+func StartHub(ctx context.Context) {
+	hubRunning.Store(true)
+	defer hubRunning.Store(false)
+	defer closeClients()
+	go watchSessions(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-broadcast:
 			mutex.Lock()
 			for client := range clients {
-				err := client.Conn.WriteMessage(websocket.TextMessage, msg)
+				if client.TenantID != "" && client.TenantID != msg.tenantID {
+					continue
+				}
+				if !client.AllDevices && !client.Scope.Allows(msg.deviceID) {
+					continue
+				}
+				err := client.Conn.WriteMessage(websocket.TextMessage, msg.data)
 				if err != nil {
+					logger.Info("Client dropped", "remote_addr", client.Conn.RemoteAddr().String(), "error", err)
+					metrics.WSMessageDropped()
+					metrics.WSClientDisconnected()
 					client.Conn.Close()
 					delete(clients, client)
 				}
 			}
 			mutex.Unlock()
 		}
-	}()
+	}
+}
+
+// closeClients tells every client the server is going away and drops the connections.
+func closeClients() {
+	closeWhere(websocket.CloseGoingAway, "server shutting down", func(*Client) bool { return true })
+}
+
+// CloseSession closes the streams opened with the session, once it has been revoked.
+func CloseSession(sessionID string) {
+	closeWhere(websocket.ClosePolicyViolation, "session revoked", func(c *Client) bool {
+		return sessionID != "" && c.SessionID == sessionID
+	})
+}
+
+// CloseUserSessions closes the user's streams opened with any session but keep, once
+// those sessions have been revoked.
+func CloseUserSessions(username, keep string) {
+	closeWhere(websocket.ClosePolicyViolation, "session revoked", func(c *Client) bool {
+		return c.Username == username && c.SessionID != "" && c.SessionID != keep
+	})
 }
 
-// AddClient adds a new client connection to the list
-func AddClient(conn *websocket.Conn) {
-	client := &Client{Conn: conn}
+// closeWhere sends a close frame to the matching clients and drops their connections.
+func closeWhere(code int, reason string, match func(*Client) bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	msg := websocket.FormatCloseMessage(code, reason)
+	deadline := time.Now().Add(time.Second)
+	for client := range clients {
+		if !match(client) {
+			continue
+		}
+		client.Conn.WriteControl(websocket.CloseMessage, msg, deadline)
+		client.Conn.Close()
+		delete(clients, client)
+		metrics.WSClientDisconnected()
+	}
+}
+
+// watchSessions closes the clients whose token no longer authenticates, every
+// SessionCheckInterval until ctx is cancelled. Clients are kept when the check itself
+// fails, e.g. while MongoDB is unavailable.
+func watchSessions(ctx context.Context) {
+	ticker := time.NewTicker(SessionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Checks go to MongoDB, so they run without holding the lock
+		mutex.Lock()
+		current := make([]*Client, 0, len(clients))
+		for client := range clients {
+			current = append(current, client)
+		}
+		mutex.Unlock()
+
+		for _, client := range current {
+			_, err := middleware.AuthenticateToken(ctx, client.token)
+			switch {
+			case errors.Is(err, middleware.ErrInvalidToken), errors.Is(err, middleware.ErrSessionRevoked):
+				logger.Info("Closing client whose session ended", "username", client.Username, "session_id", client.SessionID)
+				closeWhere(websocket.ClosePolicyViolation, "session ended", func(c *Client) bool { return c == client })
+			case err != nil && ctx.Err() == nil:
+				logger.Warn("Failed to check client session", "username", client.Username, "error", err)
+			}
+		}
+	}
+}
+
+// HubRunning reports whether the broadcast loop is running.
+func HubRunning() bool {
+	return hubRunning.Load()
+}
+
+// AddClient adds a new client connection to the list. Its token is checked again every
+// SessionCheckInterval.
+func AddClient(client *Client, token string) {
+	client.token = token
 	mutex.Lock()
 	clients[client] = true
 	mutex.Unlock()
+	metrics.WSClientConnected()
+	logger.Info("Client connected", "remote_addr", client.Conn.RemoteAddr().String(), "tenant_id", client.TenantID,
+		"username", client.Username)
 }
 
-// Broadcast sends a message to all connected clients
-func Broadcast(msg []byte) {
-	broadcast <- msg
+// Broadcast sends a message of the tenant's device to the clients allowed to see the
+// device. Messages with no tenant only reach platform clients. Messages are dropped while the hub is not
+// running, so publishers never block during shutdown.
+func Broadcast(tenantID, deviceID string, msg []byte) {
+	if !hubRunning.Load() {
+		return
+	}
+	select {
+	case broadcast <- message{tenantID: tenantID, deviceID: deviceID, data: msg}:
+	case <-time.After(time.Second):
+		metrics.WSMessageDropped()
+	}
 }
 
 // LiveDataWebSocket godoc
 // @Summary      WebSocket real-time data stream
-// @Description  Opens a WebSocket connection to receive real-time sensor data pushed from the backend. Requires a valid JWT token in the Authorization header.
+// @Description  Opens a WebSocket connection to receive real-time sensor data pushed from the backend. Requires a valid JWT token in the Authorization header and the data:read permission. Users of an organization only receive their organization's data, and users limited to device groups only receive data of devices in their groups.
 // @Tags         websocket
 // @Produce      json
 // @Security     BearerAuth
 // @Success      101  {string}  string  "Switching Protocols"
 // @Failure      401  {object}  map[string]string "Unauthorized"
+// @Failure      403  {object}  map[string]string "Forbidden"
 // @Failure      500  {object}  map[string]string "Internal Server Error"
 // @Router       /ws/live-data [get]
 func LiveDataWebSocket(c *gin.Context) {
@@ -71,16 +214,22 @@ func LiveDataWebSocket(c *gin.Context) {
 		return
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-	secret := []byte(os.Getenv("JWT_SECRET"))
-
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
-	if err != nil || !token.Valid {
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	claims, err := middleware.AuthenticateToken(c.Request.Context(), token)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 		return
 	}
+	if !rbac.Has(claims.Role, rbac.DataRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission " + string(rbac.DataRead)})
+		return
+	}
+
+	scope, allDevices, err := middleware.ResolveScope(c.Request.Context(), claims.Username, claims.Role, claims.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve device access"})
+		return
+	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -88,5 +237,6 @@ func LiveDataWebSocket(c *gin.Context) {
 		return
 	}
 
-	AddClient(conn)
+	AddClient(&Client{Conn: conn, TenantID: claims.TenantID, Username: claims.Username, SessionID: claims.ID,
+		AllDevices: allDevices, Scope: scope}, token)
 }
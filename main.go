@@ -1,16 +1,39 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "github.com/rednexx46/esp32-backend-api/docs"
 	"github.com/rednexx46/esp32-backend-api/internal/db"
 	"github.com/rednexx46/esp32-backend-api/internal/handlers"
+	"github.com/rednexx46/esp32-backend-api/internal/health"
+	"github.com/rednexx46/esp32-backend-api/internal/jobs"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+	"github.com/rednexx46/esp32-backend-api/internal/kpi"
+	"github.com/rednexx46/esp32-backend-api/internal/logging"
+	"github.com/rednexx46/esp32-backend-api/internal/mesh"
+	"github.com/rednexx46/esp32-backend-api/internal/metrics"
 	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/migrate"
 	"github.com/rednexx46/esp32-backend-api/internal/mqtt"
+	"github.com/rednexx46/esp32-backend-api/internal/ratelimit"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/retention"
+	"github.com/rednexx46/esp32-backend-api/internal/sso"
+	"github.com/rednexx46/esp32-backend-api/internal/tenant"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
 	"github.com/rednexx46/esp32-backend-api/internal/ws"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -18,6 +41,17 @@ import (
 	mqttLib "github.com/eclipse/paho.mqtt.golang"
 )
 
+var (
+	logger       = logging.For("server")
+	ingestLogger = logging.For("mqtt")
+
+	// tenantRouter maps MQTT topic prefixes to the organizations owning them
+	tenantRouter = tenant.NewRouter(db.ListOrganizations, db.ClaimDeviceForTenant)
+
+	// meshTracker records the mesh positions nodes report with their readings
+	meshTracker = mesh.NewTracker(mesh.MongoStore{})
+)
+
 // @title           ESP32 Backend API
 // @version         1.0
 // @description     REST API for handling sensor data, KPIs, and user authentication in an IoT ESP32 system.
@@ -34,32 +68,111 @@ import (
 // @in header
 // @name Authorization
 func main() {
-	// Load .env file if exists
-	if err := godotenv.Load(); err != nil {
-		log.Println("[ENV] .env file not found, using environment variables")
+	// Load .env file if exists; logging is configured afterwards so LOG_* can come from it
+	envErr := godotenv.Load()
+	logging.Setup()
+	if envErr != nil {
+		logger.Info(".env file not found, using environment variables")
 	}
 
+	os.Exit(runCommand(os.Args[1:]))
+}
+
+// serve runs the HTTP server together with the MQTT ingestion and background jobs
+// until SIGINT or SIGTERM, then shuts everything down in dependency order.
+func serve() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Background work stops when appCtx is cancelled, after HTTP and MQTT have drained
+	appCtx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
+
 	// Initialize MongoDB
 	db.InitDB()
 
+	// Apply pending migrations. Later code relies on their indexes, so the server does
+	// not start without them.
+	if os.Getenv("MIGRATE_ON_STARTUP") != "false" {
+		if _, err := migrate.Up(context.Background()); err != nil {
+			logging.Fatal(logger, "Migrations failed", "error", err)
+		}
+	}
+
+	handlers.InitCollections()
+
 	// Seed Admin User
 	db.SeedAdminUser()
 
 	// Start WebSocket hub
-	go ws.StartHub()
+	hubDone := make(chan struct{})
+	go func() {
+		ws.StartHub(appCtx)
+		close(hubDone)
+	}()
+
+	// Enforce retention
+	retentionCfg := retention.LoadConfig()
+	if err := retention.EnsureTTLIndexes(context.Background(), retentionCfg); err != nil {
+		logger.Error("Failed to apply TTL indexes", "error", err)
+	}
+
+	// Start background jobs
+	kpi.RegisterJob()
+	retention.RegisterJob(retentionCfg)
+	mesh.RegisterJob(meshTracker, mesh.LoadConfig())
+	jobs.Start(appCtx)
 
 	// Initialize MQTT client and subscribe to topic
-	mqtt.InitMQTT(func(client mqttLib.Client, msg mqttLib.Message) {
-		log.Printf("[MQTT] Received: %s", msg.Payload())
-		ws.Broadcast(msg.Payload())
-	})
+	mqtt.InitMQTT(handleSensorMessage)
+
+	// Readiness checks
+	registerHealthChecks()
+
+	if err := rbac.LoadRoles(); err != nil {
+		logging.Fatal(logger, "Invalid role configuration", "error", err)
+	}
+
+	if err := jwtkeys.Load(); err != nil {
+		logging.Fatal(logger, "Invalid token signing keys", "error", err)
+	}
+	if keys, _ := jwtkeys.Current(); keys.SigningKeyID() == "" {
+		logger.Warn("Signing tokens with HS256 and JWT_SECRET; set JWT_KEYS_DIR to publish verification keys")
+	} else {
+		logger.Info("Signing tokens", "kid", keys.SigningKeyID())
+	}
+
+	ssoCfg, err := sso.LoadConfig()
+	if err != nil {
+		logging.Fatal(logger, "Invalid OIDC configuration", "error", err)
+	}
+	handlers.InitSSO(sso.NewClient(ssoCfg))
+
+	rateLimitCfg, err := ratelimit.LoadConfig()
+	if err != nil {
+		logging.Fatal(logger, "Invalid rate limit configuration", "error", err)
+	}
 
 	// Setup Gin router
-	r := gin.Default()
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		logging.Fatal(logger, "Invalid TRUSTED_PROXIES", "error", err)
+	}
+	r.Use(middleware.RequestID(), middleware.AccessLog(), gin.Recovery(), metrics.GinMiddleware())
+
+	// Health probes
+	r.GET("/healthz", handlers.Healthz)
+	r.GET("/readyz", handlers.Readyz)
+
+	// Prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Swagger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Public keys verifying access tokens
+	r.GET("/.well-known/jwks.json", handlers.JWKS)
+
 	// WebSocket live endpoint
 	r.GET("/ws/live-data", ws.LiveDataWebSocket)
 
@@ -67,24 +180,71 @@ func main() {
 	public := r.Group("/api")
 	{
 		public.POST("/login", handlers.LoginHandler)
-		public.POST("/logout", handlers.LogoutHandler)
+		public.POST("/login/mfa", handlers.MFALoginHandler)
+		public.GET("/auth/oidc/login", handlers.OIDCLogin)
+		public.GET("/auth/oidc/callback", handlers.OIDCCallback)
+
+		// New devices authenticate with their claim code, so this is limited per client IP
+		public.POST("/provisioning/bootstrap", middleware.RateLimit(rateLimitCfg), handlers.BootstrapDevice)
 	}
 
 	// Protected routes
 	protected := public.Group("/")
-	protected.Use(middleware.JWTMiddleware())
+	protected.Use(middleware.JWTMiddleware(), middleware.RateLimit(rateLimitCfg))
 	{
+		protected.POST("/logout", handlers.LogoutHandler)
 		protected.GET("/profile", handlers.GetProfile)
+		protected.POST("/profile/password", handlers.ChangePassword)
+		protected.GET("/sessions", handlers.ListSessions)
+		protected.DELETE("/sessions/:id", handlers.RevokeSession)
+		protected.POST("/mfa/totp/enroll", handlers.EnrollTOTP)
+		protected.POST("/mfa/totp/verify", handlers.VerifyTOTP)
+		protected.POST("/mfa/totp/disable", handlers.DisableTOTP)
+		protected.POST("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes)
 
-		admin := protected.Group("/")
-		admin.Use(middleware.AdminOnly())
-		{
-			admin.GET("/data/:device_id", handlers.GetSensorDataByDevice)
-			admin.GET("/data", handlers.GetAllSensorData)
-			admin.GET("/devices", handlers.GetActiveDevices)
-			admin.GET("/kpis", handlers.GetAllKPIs)
-			admin.GET("/kpis/device/:device_id", handlers.GetKPIsByDevice)
-		}
+		// Routes are granted by permission; data, device and KPI reads are further
+		// limited to the devices in the user's tenant and groups, and may be narrowed
+		// further with group=, tag= and location= selectors
+		can := middleware.RequirePermission
+		scoped := middleware.DeviceScope()
+		selected := middleware.SelectDevices()
+
+		protected.GET("/data/export", can(rbac.DataExport), scoped, selected, handlers.ExportSensorData)
+		protected.GET("/data/aggregate", can(rbac.DataRead), scoped, handlers.GetAggregatedSensorData)
+		protected.GET("/data/:device_id", can(rbac.DataRead), scoped, handlers.GetSensorDataByDevice)
+		protected.GET("/data", can(rbac.DataRead), scoped, selected, handlers.GetAllSensorData)
+		protected.GET("/devices", can(rbac.DevicesRead), scoped, selected, handlers.GetActiveDevices)
+		protected.GET("/devices/registry", can(rbac.DevicesRead), scoped, selected, handlers.ListDeviceRegistry)
+		protected.GET("/devices/:device_id", can(rbac.DevicesRead), scoped, handlers.GetDevice)
+		protected.PUT("/devices/:device_id", can(rbac.DevicesWrite), scoped, handlers.UpdateDevice)
+		protected.GET("/locations", can(rbac.DevicesRead), scoped, handlers.GetLocationTree)
+		protected.GET("/mesh/topology", can(rbac.DevicesRead), scoped, selected, handlers.GetMeshTopology)
+		protected.GET("/mesh/events", can(rbac.DevicesRead), scoped, selected, handlers.ListMeshEvents)
+		protected.GET("/provisioning/claims", can(rbac.DevicesWrite), handlers.ListProvisioningClaims)
+		protected.POST("/provisioning/claims", can(rbac.DevicesWrite), handlers.CreateProvisioningClaim)
+		protected.DELETE("/provisioning/claims/:factory_id", can(rbac.DevicesWrite), handlers.DeleteProvisioningClaim)
+		protected.POST("/locations", can(rbac.DevicesWrite), handlers.CreateLocation)
+		protected.PUT("/locations/:id", can(rbac.DevicesWrite), handlers.RenameLocation)
+		protected.DELETE("/locations/:id", can(rbac.DevicesWrite), handlers.DeleteLocation)
+		protected.GET("/kpis", can(rbac.KPIsRead), scoped, selected, handlers.GetAllKPIs)
+		protected.GET("/kpis/export", can(rbac.DataExport), scoped, selected, handlers.ExportKPIs)
+		protected.GET("/kpis/summary", can(rbac.KPIsRead), scoped, selected, handlers.GetKPISummary)
+		protected.GET("/kpis/definitions", can(rbac.KPIsRead), handlers.ListKPIDefinitions)
+		protected.POST("/kpis/definitions", can(rbac.KPIsWrite), handlers.CreateKPIDefinition)
+		protected.POST("/kpis/definitions/preview", can(rbac.KPIsWrite), handlers.PreviewKPIDefinition)
+		protected.PUT("/kpis/definitions/:id", can(rbac.KPIsWrite), handlers.UpdateKPIDefinition)
+		protected.DELETE("/kpis/definitions/:id", can(rbac.KPIsWrite), handlers.DeleteKPIDefinition)
+		protected.GET("/kpis/device/:device_id", can(rbac.KPIsRead), scoped, handlers.GetKPIsByDevice)
+		protected.POST("/users/:username/unlock", can(rbac.UsersWrite), handlers.UnlockUser)
+		protected.POST("/users/:username/mfa/reset", can(rbac.UsersWrite), handlers.ResetUserMFA)
+		protected.POST("/service-accounts", can(rbac.UsersWrite), handlers.CreateServiceAccount)
+		protected.GET("/api-keys", can(rbac.UsersWrite), handlers.ListAPIKeys)
+		protected.POST("/api-keys", can(rbac.UsersWrite), handlers.CreateAPIKey)
+		protected.DELETE("/api-keys/:id", can(rbac.UsersWrite), handlers.RevokeAPIKey)
+		protected.GET("/audit", can(rbac.AuditRead), handlers.ListAuditEvents)
+		protected.GET("/audit/export", can(rbac.AuditRead), handlers.ExportAuditEvents)
+		protected.GET("/organizations", handlers.ListOrganizations)
+		protected.POST("/organizations", can(rbac.UsersWrite), handlers.CreateOrganization)
 	}
 
 	// Start server
@@ -92,8 +252,156 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	log.Printf("[SERVER] Listening on port %s...", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("[SERVER] Failed to start: %v", err)
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		logger.Info("Listening", "port", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal(logger, "Failed to start", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	logger.Info("Shutting down")
+	shutdown(srv, cancelApp, hubDone)
+}
+
+// shutdown drains the service within SHUTDOWN_TIMEOUT_SECONDS (default 15): first stop
+// accepting requests, then stop MQTT intake, then let the hub and jobs finish, and
+// finally release MongoDB once nothing else can write to it.
+func shutdown(srv *http.Server, cancelApp context.CancelFunc, hubDone <-chan struct{}) {
+	timeout := 15 * time.Second
+	if v, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS")); err == nil && v > 0 {
+		timeout = time.Duration(v) * time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// WebSocket connections are hijacked, so Shutdown does not wait for them; the hub closes them below
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Warn("HTTP shutdown incomplete", "error", err)
+	}
+
+	mqtt.Close(timeout / 3)
+
+	cancelApp()
+	select {
+	case <-hubDone:
+	case <-ctx.Done():
+		logger.Warn("WebSocket hub did not stop before the deadline")
+	}
+	if err := jobs.Wait(ctx); err != nil {
+		logger.Warn("Jobs still running at shutdown", "error", err)
+	}
+
+	// Disconnect gets its own short budget so the pool is released even when the steps above used up the deadline
+	dbCtx, dbCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer dbCancel()
+	if err := db.Disconnect(dbCtx); err != nil {
+		logger.Error("MongoDB disconnect failed", "error", err)
+	}
+	logger.Info("Shutdown complete")
+}
+
+// handleSensorMessage forwards a sensor reading from MQTT to the WebSocket clients.
+// Each reading gets a message ID, kept from the payload when the device or gateway already
+// set one, which is logged and added to JSON payloads so a reading can be traced from
+// the broker to the clients and to whatever stores the stream. Readings under a tenant's
+// topic prefix are tagged with the tenant and only reach that tenant's clients. The mesh
+// position a reading reports, if any, updates the mesh topology.
+func handleSensorMessage(_ mqttLib.Client, msg mqttLib.Message) {
+	topic := msg.Topic()
+	payload := msg.Payload()
+	metrics.MQTTMessageReceived(topic)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tenantID := tenantRouter.TenantForTopic(ctx, topic)
+
+	id, deviceID, payload, err := tagMessage(payload, tenantID)
+	if err != nil {
+		metrics.MQTTDecodeFailed(topic)
+		ingestLogger.Warn("Payload is not a JSON object, forwarding as is", "message_id", id, "topic", topic, "error", err)
+	}
+	ingestLogger.Debug("Message received", "message_id", id, "topic", topic, "tenant_id", tenantID,
+		"mqtt_id", msg.MessageID(), "bytes", len(payload))
+	tenantRouter.ClaimDevice(ctx, topic, deviceID, tenantID)
+	if report, ok := mesh.ParseReport(deviceID, payload, time.Now().UTC()); ok {
+		report.TenantID = tenantID
+		meshTracker.Observe(ctx, report)
+	}
+
+	ws.Broadcast(tenantID, deviceID, payload)
+	ingestLogger.Debug("Message broadcast", "message_id", id)
+}
+
+// trustedProxies returns the comma-separated IPs and CIDRs in TRUSTED_PROXIES. Only
+// these may set X-Forwarded-For, which decides the client IP that every handler and
+// middleware sees. Unset, no proxy is trusted and the peer address is used.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// tagMessage returns the payload's message_id and device_id, assigning a new message ID
+// to JSON objects that lack it and setting their tenant_id to the topic's tenant. A
+// tenant_id sent by the device is never trusted. Other payloads get an ID for logging
+// only and are returned unchanged.
+func tagMessage(payload []byte, tenantID string) (string, string, []byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return utils.NewID(), "", payload, err
+	}
+	var id, deviceID, sentTenant string
+	json.Unmarshal(doc["device_id"], &deviceID)
+	json.Unmarshal(doc["tenant_id"], &sentTenant)
+
+	hasID := json.Unmarshal(doc["message_id"], &id) == nil && id != ""
+	_, hasTenant := doc["tenant_id"]
+	if hasID && sentTenant == tenantID && (hasTenant || tenantID == "") {
+		return id, deviceID, payload, nil
+	}
+
+	if !hasID {
+		id = utils.NewID()
+		doc["message_id"], _ = json.Marshal(id)
+	}
+	if tenantID == "" {
+		delete(doc, "tenant_id")
+	} else {
+		doc["tenant_id"], _ = json.Marshal(tenantID)
+	}
+	tagged, err := json.Marshal(doc)
+	if err != nil {
+		return id, deviceID, payload, err
+	}
+	return id, deviceID, tagged, nil
+}
+
+// registerHealthChecks wires every runtime dependency into the /readyz report.
+func registerHealthChecks() {
+	health.Register("mongodb", db.Ping)
+	health.Register("mqtt", func(ctx context.Context) error {
+		if !mqtt.IsConnected() {
+			return errors.New("not connected to broker")
+		}
+		return nil
+	})
+	health.Register("websocket_hub", func(ctx context.Context) error {
+		if !ws.HubRunning() {
+			return errors.New("broadcast loop not running")
+		}
+		return nil
+	})
+	health.Register("cipher_api", func(ctx context.Context) error {
+		if os.Getenv("ENCRYPTION") != "true" {
+			return health.ErrDisabled
+		}
+		return utils.PingCipherAPI(ctx)
+	})
 }
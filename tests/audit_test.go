@@ -0,0 +1,62 @@
+package handlers_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/audit"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := models.User{Username: "alice", Password: "old-hash", Role: "user"}
+	after := models.User{Username: "alice", Password: "new-hash", Role: "admin", DeviceGroups: []string{"line-1"}}
+
+	changes := audit.Diff(before, after)
+	assert.Equal(t, []models.AuditChange{
+		{Field: "device_groups", After: []any{"line-1"}},
+		{Field: "role", Before: "user", After: "admin"},
+	}, changes, "fields hidden from JSON, like the password hash, are never recorded")
+
+	created := audit.Diff(nil, models.KPIDefinition{Name: "uptime"})
+	assert.Contains(t, created, models.AuditChange{Field: "name", After: "uptime"})
+	assert.Empty(t, audit.Diff((*models.User)(nil), (*models.User)(nil)))
+}
+
+func TestAuditQueryFilter(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := audit.Query{Actor: "alice", Action: "auth.*", From: from}.Filter()
+
+	assert.Equal(t, "alice", filter["actor"])
+	assert.Equal(t, bson.M{"$regex": `^auth\.`}, filter["action"])
+	assert.Equal(t, bson.M{"$gte": from}, filter["time"])
+	assert.Equal(t, "user.create", audit.Query{Action: "user.create"}.Filter()["action"])
+}
+
+func TestAuditCSV(t *testing.T) {
+	event := models.AuditEvent{
+		Time:    time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		Action:  audit.Login,
+		Outcome: audit.Failure,
+		Actor:   "=HYPERLINK(\"http://evil\")",
+		Details: map[string]any{"reason": "invalid_credentials"},
+	}
+	doc, err := bson.Marshal(event)
+	require.NoError(t, err)
+	cursor, err := mongo.NewCursorFromDocuments([]any{doc}, nil, nil)
+	require.NoError(t, err)
+
+	var out strings.Builder
+	require.NoError(t, audit.WriteCSV(context.Background(), &out, cursor))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "time,action,outcome,actor"))
+	assert.Contains(t, lines[1], `'=HYPERLINK`, "formulas are neutralised")
+	assert.Contains(t, lines[1], `""reason"":""invalid_credentials""`)
+}
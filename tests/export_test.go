@@ -0,0 +1,55 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/export"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func exportCSV(t *testing.T, columns []string, docs ...interface{}) [][]string {
+	t.Helper()
+	cursor, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, export.Write(context.Background(), &buf, cursor, export.FormatCSV, columns, false))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	return rows
+}
+
+func TestExportCSVUsesDeclaredColumns(t *testing.T) {
+	ts := time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+	rows := exportCSV(t, export.SensorColumns,
+		bson.M{"device_id": "esp32-01", "timestamp": ts, "payload": `{"t":21}`},
+		bson.M{"device_id": "esp32-02", "tenant_id": "acme", "message_id": "m-2", "timestamp": ts, "extra": "dropped"},
+	)
+
+	require.Len(t, rows, 3)
+	assert.Equal(t, export.SensorColumns, rows[0])
+	assert.Equal(t, []string{"", "esp32-01", "", "", "2025-01-01T08:00:00Z", `{"t":21}`}, rows[1])
+	assert.Equal(t, []string{"", "esp32-02", "acme", "m-2", "2025-01-01T08:00:00Z", ""}, rows[2])
+}
+
+func TestExportCSVHeaderWithoutDocuments(t *testing.T) {
+	rows := exportCSV(t, export.KPIColumns)
+	assert.Equal(t, [][]string{export.KPIColumns}, rows)
+}
+
+func TestExportCSVNeutralisesFormulas(t *testing.T) {
+	rows := exportCSV(t, []string{"device_id", "payload", "value"},
+		bson.M{"device_id": "=HYPERLINK(\"http://x\")", "payload": "@SUM(A1)", "value": -3.5},
+		bson.M{"device_id": "+1", "payload": "-cmd", "value": 2},
+	)
+
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"'=HYPERLINK(\"http://x\")", "'@SUM(A1)", "-3.5"}, rows[1])
+	assert.Equal(t, []string{"'+1", "'-cmd", "2"}, rows[2])
+}
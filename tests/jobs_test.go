@@ -0,0 +1,39 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/jobs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobRunFinishesAfterStop(t *testing.T) {
+	started := make(chan struct{})
+	finished := make(chan error, 1)
+	jobs.Register(jobs.Job{
+		Name:     "test-slow-write",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-ctx.Done():
+				finished <- ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+				finished <- nil
+			}
+			return nil
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.Start(ctx)
+	<-started
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer waitCancel()
+	assert.NoError(t, jobs.Wait(waitCtx))
+	assert.NoError(t, <-finished, "the run in progress must not be cancelled by the stop signal")
+}
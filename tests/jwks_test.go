@@ -0,0 +1,76 @@
+package handlers_test
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rednexx46/esp32-backend-api/internal/jwtkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, dir, kid, alg string) {
+	key, err := jwtkeys.Generate(alg)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), key, 0o600))
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "2025-01", "RS256")
+
+	old, err := jwtkeys.NewSet(dir, "", "", false)
+	require.NoError(t, err)
+	claims := jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+	oldToken, err := old.Sign(claims)
+	require.NoError(t, err)
+
+	// Adding a newer key makes it the signing key while the old one still verifies
+	writeKey(t, dir, "2025-06", "EdDSA")
+	keys, err := jwtkeys.NewSet(dir, "", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, "2025-06", keys.SigningKeyID())
+
+	newToken, err := keys.Sign(claims)
+	require.NoError(t, err)
+	for _, token := range []string{oldToken, newToken} {
+		var parsed jwt.RegisteredClaims
+		require.NoError(t, keys.Parse(token, &parsed))
+		assert.Equal(t, "alice", parsed.Subject)
+	}
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "RSA", jwks.Keys[0].Kty)
+	assert.Equal(t, "RS256", jwks.Keys[0].Alg)
+	assert.Equal(t, "OKP", jwks.Keys[1].Kty)
+	assert.Equal(t, "Ed25519", jwks.Keys[1].Crv)
+
+	// HS256 signed with the public key of an RSA kid (algorithm confusion) is rejected
+	data, err := os.ReadFile(filepath.Join(dir, "2025-01.pem"))
+	require.NoError(t, err)
+	block, _ := pem.Decode(data)
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	public, err := x509.MarshalPKIXPublicKey(private.(crypto.Signer).Public())
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "2025-01"
+	forgedToken, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	require.NoError(t, err)
+	assert.Error(t, keys.Parse(forgedToken, &jwt.RegisteredClaims{}))
+
+	// Legacy HS256 tokens verify only while explicitly accepted
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("old-secret"))
+	require.NoError(t, err)
+	assert.Error(t, keys.Parse(legacy, &jwt.RegisteredClaims{}))
+	migrating, err := jwtkeys.NewSet(dir, "", "old-secret", true)
+	require.NoError(t, err)
+	assert.NoError(t, migrating.Parse(legacy, &jwt.RegisteredClaims{}))
+}
@@ -0,0 +1,107 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/kpi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKPIDefinitions(t *testing.T) {
+	defs, err := kpi.ParseDefinitions("uptime, mean:temperature,p95:humidity")
+	assert.NoError(t, err)
+	assert.Len(t, defs, 3)
+	assert.Equal(t, "mean_temperature", defs[1].Name)
+	assert.Equal(t, kpi.KindPercentile, defs[2].Kind)
+	assert.Equal(t, 95.0, defs[2].Percentile)
+
+	_, err = kpi.ParseDefinitions("mean")
+	assert.Error(t, err)
+	_, err = kpi.ParseDefinitions("p150:temperature")
+	assert.Error(t, err)
+}
+
+func TestKPIUptimeAndRate(t *testing.T) {
+	start := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	w := kpi.Window{Start: start, End: start.Add(10 * time.Minute)}
+
+	var samples []kpi.Sample
+	for i := 0; i < 5; i++ {
+		samples = append(samples, kpi.Sample{Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	assert.InDelta(t, 50.0, kpi.Uptime(samples, w, time.Minute), 0.001)
+	assert.InDelta(t, 0.5, kpi.MessageRate(samples, w), 0.001)
+}
+
+func TestKPIPacketLoss(t *testing.T) {
+	seqs := []int64{1, 2, 4, 5, 1, 2, 3}
+	var samples []kpi.Sample
+	for _, s := range seqs {
+		samples = append(samples, kpi.Sample{Seq: s, HasSeq: true})
+	}
+
+	loss, ok := kpi.PacketLoss(samples)
+	assert.True(t, ok)
+	assert.InDelta(t, 12.5, loss, 0.001)
+}
+
+func TestKPIPercentile(t *testing.T) {
+	values := []float64{10, 20, 30, 40, 50}
+	assert.Equal(t, 30.0, kpi.Percentile(values, 50))
+	assert.InDelta(t, 48.0, kpi.Percentile(values, 95), 0.001)
+}
+
+func TestKPICompletedWindows(t *testing.T) {
+	now := time.Date(2025, 1, 1, 10, 17, 0, 0, time.UTC)
+	windows := kpi.CompletedWindows(now, time.Hour, 2)
+
+	assert.Len(t, windows, 2)
+	assert.Equal(t, time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC), windows[0].Start)
+	assert.Equal(t, time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC), windows[1].End)
+}
+
+func TestKPIFormula(t *testing.T) {
+	f, err := kpi.ParseFormula("(100 - packet_loss) * uptime / 100")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"packet_loss", "uptime"}, f.References())
+
+	v, err := f.Eval(map[string]float64{"packet_loss": 10, "uptime": 50})
+	assert.NoError(t, err)
+	assert.InDelta(t, 45.0, v, 0.001)
+
+	_, err = f.Eval(map[string]float64{"uptime": 50})
+	assert.ErrorIs(t, err, kpi.ErrMissingReference)
+
+	_, err = kpi.ParseFormula("uptime * (2")
+	assert.Error(t, err)
+}
+
+func TestKPIFormulaReferences(t *testing.T) {
+	uptime := kpi.Definition{Name: "uptime", Kind: kpi.KindUptime}
+	a, _ := kpi.ParseFormula("uptime + b")
+	b, _ := kpi.ParseFormula("a * 2")
+	defA := kpi.Definition{Name: "a", Kind: kpi.KindFormula, Formula: a}
+	defB := kpi.Definition{Name: "b", Kind: kpi.KindFormula, Formula: b}
+
+	assert.Error(t, kpi.ValidateReferences(defA, []kpi.Definition{uptime}))
+	assert.Error(t, kpi.ValidateReferences(defA, []kpi.Definition{uptime, defB}))
+
+	c, _ := kpi.ParseFormula("uptime / 2")
+	defC := kpi.Definition{Name: "c", Kind: kpi.KindFormula, Formula: c}
+	assert.NoError(t, kpi.ValidateReferences(defC, []kpi.Definition{uptime}))
+}
+
+func TestKPIDependents(t *testing.T) {
+	uptime := kpi.Definition{Name: "uptime", Kind: kpi.KindUptime}
+	a, _ := kpi.ParseFormula("uptime * 2")
+	b, _ := kpi.ParseFormula("a + uptime")
+	defA := kpi.Definition{Name: "a", Kind: kpi.KindFormula, Formula: a}
+	defB := kpi.Definition{Name: "b", Kind: kpi.KindFormula, Formula: b}
+	all := []kpi.Definition{uptime, defA, defB}
+
+	assert.Equal(t, []string{"a", "b"}, kpi.Dependents("uptime", all))
+	assert.Equal(t, []string{"b"}, kpi.Dependents("a", all))
+	assert.Empty(t, kpi.Dependents("b", all))
+}
@@ -0,0 +1,65 @@
+package handlers_test
+
+import (
+	"testing"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLocationParentKind(t *testing.T) {
+	parent, ok := models.ParentKind(models.LocationSite)
+	assert.True(t, ok)
+	assert.Empty(t, parent)
+
+	parent, ok = models.ParentKind(models.LocationRoom)
+	assert.True(t, ok)
+	assert.Equal(t, models.LocationBuilding, parent)
+
+	_, ok = models.ParentKind("floor")
+	assert.False(t, ok)
+}
+
+func TestLocationTree(t *testing.T) {
+	site := models.Location{ID: primitive.NewObjectID(), Kind: models.LocationSite, Name: "Lisbon"}
+	hallB := models.Location{ID: primitive.NewObjectID(), Kind: models.LocationBuilding, Name: "Hall B",
+		ParentID: &site.ID, Ancestors: []primitive.ObjectID{site.ID}}
+	hallA := models.Location{ID: primitive.NewObjectID(), Kind: models.LocationBuilding, Name: "Hall A",
+		ParentID: &site.ID, Ancestors: []primitive.ObjectID{site.ID}}
+	room := models.Location{ID: primitive.NewObjectID(), Kind: models.LocationRoom, Name: "Boiler room",
+		ParentID: &hallA.ID, Ancestors: []primitive.ObjectID{site.ID, hallA.ID}}
+	devices := []models.Device{
+		{DeviceID: "esp32-02", LocationID: &room.ID},
+		{DeviceID: "esp32-01", LocationID: &room.ID},
+		{DeviceID: "esp32-03"},
+	}
+
+	tree := models.LocationTree([]models.Location{room, hallB, site, hallA}, devices)
+	require.Len(t, tree, 1)
+	assert.Equal(t, "Lisbon", tree[0].Name)
+	require.Len(t, tree[0].Children, 2)
+	assert.Equal(t, "Hall A", tree[0].Children[0].Name)
+	assert.Equal(t, "Hall B", tree[0].Children[1].Name)
+	require.Len(t, tree[0].Children[0].Children, 1)
+	assert.Equal(t, []string{"esp32-01", "esp32-02"}, tree[0].Children[0].Children[0].DeviceIDs)
+	assert.Empty(t, tree[0].DeviceIDs)
+
+	byID := map[primitive.ObjectID]models.Location{site.ID: site, hallA.ID: hallA, room.ID: room}
+	assert.Equal(t, "Lisbon / Hall A / Boiler room", models.LocationLabel(room.Path(), byID))
+}
+
+func TestScopeNarrow(t *testing.T) {
+	all := rbac.Scope{All: true}
+	assert.Equal(t, []string{"esp32-01", "esp32-02"}, all.Narrow([]string{"esp32-01", "esp32-02"}).DeviceIDs)
+
+	limited := rbac.Scope{DeviceIDs: []string{"esp32-01"}, TenantID: "acme"}
+	narrowed := limited.Narrow([]string{"esp32-01", "esp32-02"})
+	assert.False(t, narrowed.All)
+	assert.Equal(t, []string{"esp32-01"}, narrowed.DeviceIDs)
+	assert.Equal(t, "acme", narrowed.TenantID)
+
+	assert.False(t, limited.Narrow(nil).Allows("esp32-01"))
+}
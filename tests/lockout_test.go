@@ -0,0 +1,101 @@
+package handlers_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/lockout"
+	"github.com/rednexx46/esp32-backend-api/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limiter := ratelimit.PerMinute(60, 2)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	ok, _ := limiter.Allow("ip", now)
+	assert.True(t, ok)
+	ok, _ = limiter.Allow("ip", now)
+	assert.True(t, ok)
+	ok, wait := limiter.Allow("ip", now)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	ok, _ = limiter.Allow("other", now)
+	assert.True(t, ok, "buckets are per key")
+
+	ok, _ = limiter.Allow("ip", now.Add(time.Second))
+	assert.True(t, ok, "one token refills per second")
+}
+
+func TestProgressiveLockout(t *testing.T) {
+	policy := lockout.Policy{MaxFailures: 3, Window: 15 * time.Minute, BaseLockout: 5 * time.Minute, MaxLockout: time.Hour}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	a := policy.RecordFailure(nil, "alice", now)
+	a = policy.RecordFailure(&a, "alice", now.Add(time.Minute))
+	_, locked := lockout.LockedUntil(&a, now.Add(time.Minute))
+	assert.False(t, locked)
+
+	a = policy.RecordFailure(&a, "alice", now.Add(2*time.Minute))
+	until, locked := lockout.LockedUntil(&a, now.Add(2*time.Minute))
+	assert.True(t, locked)
+	assert.Equal(t, now.Add(7*time.Minute), until)
+
+	// After the first lockout expires, the next one lasts twice as long
+	later := now.Add(10 * time.Minute)
+	for i := 0; i < 3; i++ {
+		a = policy.RecordFailure(&a, "alice", later)
+	}
+	until, _ = lockout.LockedUntil(&a, later)
+	assert.Equal(t, later.Add(10*time.Minute), until)
+
+	// Failures spread beyond the window do not add up
+	b := policy.RecordFailure(nil, "bob", now)
+	b = policy.RecordFailure(&b, "bob", now.Add(20*time.Minute))
+	b = policy.RecordFailure(&b, "bob", now.Add(40*time.Minute))
+	_, locked = lockout.LockedUntil(&b, now.Add(40*time.Minute))
+	assert.False(t, locked)
+
+	assert.Equal(t, time.Hour, policy.LockoutDuration(10))
+}
+
+func TestConcurrentLoginFailuresLockOut(t *testing.T) {
+	policy := lockout.Policy{MaxFailures: 5, Window: 15 * time.Minute, BaseLockout: 5 * time.Minute, MaxLockout: time.Hour}
+	ctx := context.Background()
+	db.DeleteLoginAttempt(ctx, "lockout-race")
+	t.Cleanup(func() { db.DeleteLoginAttempt(ctx, "lockout-race") })
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < policy.MaxFailures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.RecordLoginFailure(ctx, "lockout-race", policy.FailureUpdate(now))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	a, err := db.GetLoginAttempt(ctx, "lockout-race")
+	require.NoError(t, err)
+	until, locked := lockout.LockedUntil(a, now)
+	assert.True(t, locked, "no failure may be lost")
+	assert.Equal(t, now.Add(5*time.Minute), until.UTC())
+	assert.Equal(t, 1, a.Lockouts)
+	assert.Equal(t, 0, a.Failures)
+
+	// The next lockout lasts twice as long, as with RecordFailure
+	later := now.Add(10 * time.Minute)
+	for i := 0; i < policy.MaxFailures; i++ {
+		a, err = db.RecordLoginFailure(ctx, "lockout-race", policy.FailureUpdate(later))
+		require.NoError(t, err)
+	}
+	until, _ = lockout.LockedUntil(a, later)
+	assert.Equal(t, later.Add(10*time.Minute), until.UTC())
+}
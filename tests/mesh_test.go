@@ -0,0 +1,216 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/mesh"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryMeshStore is a mesh.Store kept in memory.
+type memoryMeshStore struct {
+	nodes  map[string]models.MeshNode
+	events []models.MeshEvent
+}
+
+func (s *memoryMeshStore) ListNodes(context.Context) ([]models.MeshNode, error) {
+	nodes := []models.MeshNode{}
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (s *memoryMeshStore) GetNode(_ context.Context, deviceID string) (*models.MeshNode, error) {
+	n := s.nodes[deviceID]
+	return &n, nil
+}
+
+func (s *memoryMeshStore) ApplyNode(_ context.Context, node models.MeshNode, prev *models.MeshNode) (bool, error) {
+	current, exists := s.nodes[node.DeviceID]
+	if (prev == nil && exists) || (prev != nil && !current.SameLink(*prev)) {
+		return false, nil
+	}
+	s.nodes[node.DeviceID] = node
+	return true, nil
+}
+
+func (s *memoryMeshStore) TouchNode(_ context.Context, deviceID string, rssi int, at time.Time) error {
+	n := s.nodes[deviceID]
+	n.RSSI, n.LastSeen = rssi, at
+	s.nodes[deviceID] = n
+	return nil
+}
+
+func (s *memoryMeshStore) SetOrphaned(_ context.Context, deviceID string, at *time.Time) (bool, error) {
+	n := s.nodes[deviceID]
+	if (at == nil) == (n.OrphanedAt == nil) {
+		return false, nil
+	}
+	n.OrphanedAt = at
+	s.nodes[deviceID] = n
+	return true, nil
+}
+
+func (s *memoryMeshStore) RecordEvent(_ context.Context, event models.MeshEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memoryMeshStore) eventTypes() []string {
+	types := []string{}
+	for _, e := range s.events {
+		types = append(types, e.Type+" "+e.DeviceID)
+	}
+	return types
+}
+
+func TestMeshParseReport(t *testing.T) {
+	at := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	report, ok := mesh.ParseReport("esp32-07",
+		[]byte(`{"device_id":"esp32-07","temperature":21.5,"mesh":{"parent":"esp32-02","layer":3,"rssi":-67}}`), at)
+	require.True(t, ok)
+	assert.Equal(t, models.MeshReport{DeviceID: "esp32-07", ParentID: "esp32-02", Layer: 3, RSSI: -67, Time: at}, report)
+
+	report, ok = mesh.ParseReport("esp32-01", []byte(`{"mesh":{"parent":"aa:bb:cc:dd:ee:ff","layer":1,"rssi":-50}}`), at)
+	require.True(t, ok)
+	assert.Empty(t, report.ParentID, "the root's parent is the router")
+
+	_, ok = mesh.ParseReport("esp32-07", []byte(`{"temperature":21.5}`), at)
+	assert.False(t, ok)
+	_, ok = mesh.ParseReport("", []byte(`{"mesh":{"layer":2}}`), at)
+	assert.False(t, ok)
+}
+
+func TestMeshBuild(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	recent, stale := now.Add(-time.Minute), now.Add(-time.Hour)
+	nodes := []models.MeshNode{
+		{DeviceID: "root", Layer: 1, LastSeen: recent},
+		{DeviceID: "a", ParentID: "root", Layer: 2, RSSI: -60, LastSeen: recent},
+		{DeviceID: "b", ParentID: "a", Layer: 3, RSSI: -70, LastSeen: recent},
+		{DeviceID: "dead", ParentID: "root", Layer: 2, LastSeen: stale},
+		{DeviceID: "c", ParentID: "dead", Layer: 3, LastSeen: recent},
+		{DeviceID: "lost", ParentID: "unknown", Layer: 4, LastSeen: recent},
+		{DeviceID: "x", ParentID: "y", Layer: 2, LastSeen: recent},
+		{DeviceID: "y", ParentID: "x", Layer: 3, LastSeen: recent},
+	}
+
+	topo := mesh.Build(nodes, now, 5*time.Minute)
+	statuses := map[string]string{}
+	for _, n := range topo.Nodes {
+		statuses[n.DeviceID] = n.Status
+	}
+	assert.Equal(t, map[string]string{
+		"root": models.MeshOnline, "a": models.MeshOnline, "b": models.MeshOnline,
+		"dead": models.MeshOffline, "c": models.MeshOrphaned, "lost": models.MeshOrphaned,
+		"x": models.MeshOrphaned, "y": models.MeshOrphaned,
+	}, statuses)
+	assert.Equal(t, []string{"root"}, topo.Roots)
+	assert.Equal(t, "root", topo.Nodes[0].DeviceID)
+	assert.Contains(t, topo.Edges, models.MeshEdge{Source: "b", Target: "a", RSSI: -70})
+	assert.Len(t, topo.Edges, 6, "lost's parent is unknown and the root has no edge")
+}
+
+func TestMeshTrackerEvents(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryMeshStore{nodes: map[string]models.MeshNode{}}
+	tracker := mesh.NewTracker(store)
+	start := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-01", Layer: 1, Time: start})
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-02", ParentID: "esp32-01", Layer: 2, RSSI: -60, Time: start})
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-02", ParentID: "esp32-01", Layer: 2, RSSI: -65, Time: start.Add(time.Second)})
+	assert.Equal(t, []string{"node.joined esp32-01", "root.change esp32-01", "node.joined esp32-02"}, store.eventTypes())
+	assert.Equal(t, -60, store.nodes["esp32-02"].RSSI, "unchanged links are written at most once per interval")
+
+	// The root fails and esp32-02 takes over
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-02", Layer: 1, Time: start.Add(2 * time.Minute)})
+	require.Len(t, store.events, 5)
+	assert.Equal(t, models.MeshParentChange, store.events[3].Type)
+	assert.Equal(t, "esp32-01", store.events[3].PreviousParentID)
+	assert.Equal(t, models.MeshRootChange, store.events[4].Type)
+	assert.Equal(t, "esp32-01", store.events[4].PreviousRoot)
+
+	// A node still reporting the dead root as parent is orphaned, and reported once
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-03", ParentID: "esp32-01", Layer: 2, Time: start.Add(9 * time.Minute)})
+	now := start.Add(10 * time.Minute)
+	require.NoError(t, tracker.Sweep(ctx, now, 5*time.Minute))
+	require.NoError(t, tracker.Sweep(ctx, now, 5*time.Minute))
+	assert.Equal(t, "node.orphaned esp32-03", store.eventTypes()[len(store.events)-1])
+	assert.NotNil(t, store.nodes["esp32-03"].OrphanedAt)
+	assert.Len(t, store.events, 7)
+
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-03", ParentID: "esp32-02", Layer: 2, Time: now})
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-02", Layer: 1, Time: now})
+	require.NoError(t, tracker.Sweep(ctx, now, 5*time.Minute))
+	assert.Nil(t, store.nodes["esp32-03"].OrphanedAt)
+}
+
+// slowMeshStore blocks ApplyNode of one device until released, and can fail loading.
+type slowMeshStore struct {
+	*memoryMeshStore
+	blocked  string
+	entered  chan struct{}
+	release  chan struct{}
+	loadErr  error
+	loadRuns int
+}
+
+func (s *slowMeshStore) ListNodes(ctx context.Context) ([]models.MeshNode, error) {
+	s.loadRuns++
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.memoryMeshStore.ListNodes(ctx)
+}
+
+func (s *slowMeshStore) ApplyNode(ctx context.Context, node models.MeshNode, prev *models.MeshNode) (bool, error) {
+	if node.DeviceID == s.blocked {
+		close(s.entered)
+		<-s.release
+	}
+	return s.memoryMeshStore.ApplyNode(ctx, node, prev)
+}
+
+func TestMeshTrackerDoesNotSerializeStoreCalls(t *testing.T) {
+	ctx := context.Background()
+	store := &slowMeshStore{memoryMeshStore: &memoryMeshStore{nodes: map[string]models.MeshNode{}},
+		blocked: "esp32-01", entered: make(chan struct{}), release: make(chan struct{})}
+	tracker := mesh.NewTracker(store)
+	start := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-00", Layer: 1, Time: start}) // Loads the topology
+
+	done := make(chan struct{})
+	go func() {
+		tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-01", ParentID: "esp32-00", Layer: 2, Time: start})
+		close(done)
+	}()
+	<-store.entered
+
+	// Another node's report goes through while the first one waits on the store
+	tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-02", ParentID: "esp32-00", Layer: 2, Time: start})
+	assert.Contains(t, store.nodes, "esp32-02")
+
+	close(store.release)
+	<-done
+	assert.Contains(t, store.nodes, "esp32-01")
+}
+
+func TestMeshTrackerBacksOffAfterLoadFailure(t *testing.T) {
+	ctx := context.Background()
+	store := &slowMeshStore{memoryMeshStore: &memoryMeshStore{nodes: map[string]models.MeshNode{}},
+		loadErr: errors.New("unavailable")}
+	tracker := mesh.NewTracker(store)
+
+	for i := 0; i < 3; i++ {
+		tracker.Observe(ctx, models.MeshReport{DeviceID: "esp32-01", Layer: 1, Time: time.Now()})
+	}
+	assert.Equal(t, 1, store.loadRuns)
+	assert.Empty(t, store.nodes)
+}
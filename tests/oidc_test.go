@@ -0,0 +1,134 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rednexx46/esp32-backend-api/internal/sso"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint that
+// checks the PKCE verifier against the challenge sent to the authorization endpoint.
+type mockIdP struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	groups    []string
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := &mockIdP{key: key, groups: []string{"plant-ops"}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "good-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		claims := jwt.MapClaims{
+			"iss": idp.server.URL, "sub": "user-123", "aud": "esp32-api",
+			"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(),
+			"nonce": idp.nonce, "preferred_username": "alice", "email": "alice@example.com",
+			"groups": idp.groups,
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, _ := token.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "id_token": signed})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func TestOIDCAuthorizationCodeWithPKCE(t *testing.T) {
+	idp := newMockIdP(t)
+	cfg := sso.Config{
+		Enabled:       true,
+		IssuerURL:     idp.server.URL,
+		ClientID:      "esp32-api",
+		RedirectURL:   "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+		RoleMappings:  []sso.RoleMapping{{Group: "plant-admins", Role: "admin"}, {Group: "plant-ops", Role: "user"}},
+	}
+	client := sso.NewClient(cfg)
+	ctx := context.Background()
+
+	flow := sso.NewFlow(time.Now())
+	authURL, err := client.AuthCodeURL(ctx, flow.State, flow.Nonce, flow.Verifier)
+	require.NoError(t, err)
+	u, _ := url.Parse(authURL)
+	assert.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	assert.Equal(t, flow.State, u.Query().Get("state"))
+	idp.challenge = u.Query().Get("code_challenge")
+	idp.nonce = u.Query().Get("nonce")
+
+	identity, err := client.Exchange(ctx, "good-code", flow.Verifier, flow.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.Username)
+	assert.Equal(t, "user-123", identity.Subject)
+	role, err := cfg.Role(identity.Groups)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", role)
+
+	_, err = client.Exchange(ctx, "good-code", "wrong-verifier", flow.Nonce)
+	assert.Error(t, err, "PKCE verifier must match")
+	_, err = client.Exchange(ctx, "good-code", flow.Verifier, "other-nonce")
+	assert.Error(t, err, "nonce must match")
+
+	_, err = cfg.Role([]string{"visitors"})
+	assert.ErrorIs(t, err, sso.ErrNoRole)
+}
+
+func TestOIDCFlowCookie(t *testing.T) {
+	secret := []byte("secret")
+	flow := sso.NewFlow(time.Now())
+	sealed, err := flow.Seal(secret)
+	require.NoError(t, err)
+
+	opened, err := sso.OpenFlow(sealed, flow.State, secret)
+	require.NoError(t, err)
+	assert.Equal(t, flow.Verifier, opened.Verifier)
+
+	_, err = sso.OpenFlow(sealed, "other-state", secret)
+	assert.Error(t, err)
+	_, err = sso.OpenFlow(sealed, flow.State, []byte("other-secret"))
+	assert.Error(t, err)
+}
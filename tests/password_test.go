@@ -0,0 +1,30 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := utils.PasswordPolicy{MinLength: 10, MinClasses: 3}
+
+	assert.NoError(t, policy.Check("alice", "Tr0ubadour-horse"))
+	assert.ErrorContains(t, policy.Check("alice", "Sh0rt!"), "at least 10 characters")
+	assert.ErrorContains(t, policy.Check("alice", "alllowercaseletters"), "at least 3 of")
+	assert.ErrorContains(t, policy.Check("alice", "Alice-2024-spring"), "username")
+	assert.ErrorContains(t, policy.Check("alice", "Aa1"+string(make([]byte, 80))), "72 bytes")
+}
+
+func TestSessionActive(t *testing.T) {
+	now := time.Now()
+	session := models.Session{ExpiresAt: now.Add(time.Minute)}
+	assert.True(t, session.Active(now))
+	assert.False(t, session.Active(now.Add(2*time.Minute)))
+
+	session.RevokedAt = &now
+	assert.False(t, session.Active(now))
+}
@@ -0,0 +1,81 @@
+package handlers_test
+
+import (
+	"encoding/base64"
+	"regexp"
+	"testing"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/provision"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisioningClaimCode(t *testing.T) {
+	code, hash := provision.GenerateClaimCode()
+	assert.Regexp(t, regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{5}-[0-9A-HJKMNP-TV-Z]{5}$`), code)
+	assert.True(t, provision.VerifyClaimCode(code, hash))
+
+	// Codes are typed from a label, so case, spaces and dashes do not matter
+	assert.True(t, provision.VerifyClaimCode(" "+code[:5]+" "+code[6:], hash))
+	other, _ := provision.GenerateClaimCode()
+	assert.False(t, provision.VerifyClaimCode(other, hash))
+}
+
+func TestProvisioningMQTTPassword(t *testing.T) {
+	// Hashed with Python's hashlib.pbkdf2_hmac, as mosquitto-go-auth would store it
+	hash := "PBKDF2$sha512$100000$MDEyMzQ1Njc4OWFiY2RlZg==$" +
+		"SS3hZzKf9SwtsVQ5qx/IQuWS4cLrXSq0qI9zbRbkIRSQW8ZMsa+NGJ7BVJgbcVhU4GPIRbf4pkkDtzRLhojc0A=="
+	assert.True(t, provision.VerifyMQTTPassword("device-secret", hash))
+	assert.False(t, provision.VerifyMQTTPassword("wrong", hash))
+
+	creds, err := provision.GenerateCredentials()
+	require.NoError(t, err)
+	assert.True(t, provision.VerifyMQTTPassword(creds.Password, creds.PasswordHash))
+	assert.Len(t, creds.EncryptionKey, 32)
+}
+
+func TestProvisioningSealKey(t *testing.T) {
+	kek := make([]byte, 32)
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	sealed, err := provision.SealKey(kek, key)
+	require.NoError(t, err)
+	opened, err := provision.OpenKey(kek, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, key, opened)
+
+	otherKEK := make([]byte, 32)
+	otherKEK[0] = 1
+	_, err = provision.OpenKey(otherKEK, sealed)
+	assert.Error(t, err)
+}
+
+func TestProvisioningConfig(t *testing.T) {
+	t.Setenv("PROVISIONING_KEY_ENCRYPTION_KEY", "")
+	_, err := provision.LoadConfig()
+	assert.ErrorIs(t, err, provision.ErrNotConfigured)
+
+	t.Setenv("PROVISIONING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString([]byte("short")))
+	_, err = provision.LoadConfig()
+	assert.Error(t, err)
+
+	t.Setenv("PROVISIONING_KEY_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	t.Setenv("PROVISIONING_MQTT_URL", "mqtts://gateway.local:8883")
+	cfg, err := provision.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "mqtts://gateway.local:8883", cfg.BrokerURL)
+}
+
+func TestProvisioningDataTopic(t *testing.T) {
+	assert.Equal(t, "mesh/data/esp32-07", provision.DataTopic(nil, "mesh/data/", "esp32-07"))
+	org := &models.Organization{ID: "acme", TopicPrefixes: []string{"sites/acme/", "sites/acme-old/"}}
+	assert.Equal(t, "sites/acme/esp32-07", provision.DataTopic(org, "mesh/data/", "esp32-07"))
+	assert.Equal(t, []models.MQTTACL{{Topic: "sites/acme/esp32-07", Acc: models.MQTTWrite}},
+		provision.ACLs("sites/acme/esp32-07"))
+
+	assert.True(t, provision.ValidDeviceID("esp32-07"))
+	assert.False(t, provision.ValidDeviceID("esp32/07"))
+	assert.False(t, provision.ValidDeviceID("esp32-#"))
+	assert.False(t, provision.ValidDeviceID(""))
+}
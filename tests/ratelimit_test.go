@@ -0,0 +1,41 @@
+package handlers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	rule, err := ratelimit.ParseRule("30/1m")
+	assert.NoError(t, err)
+	assert.Equal(t, ratelimit.Rule{N: 30, Window: time.Minute}, rule)
+
+	for _, bad := range []string{"30", "0/1m", "x/1m", "30/forever", "30/-1m"} {
+		_, err := ratelimit.ParseRule(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	res, _ := store.Take(ctx, "user:alice", 2, time.Minute, now)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 30*time.Second, res.Reset)
+
+	store.Take(ctx, "user:alice", 2, time.Minute, now)
+	res, _ = store.Take(ctx, "user:alice", 2, time.Minute, now)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 30*time.Second, res.RetryAfter)
+
+	res, _ = store.Take(ctx, "user:bob", 2, time.Minute, now)
+	assert.True(t, res.Allowed)
+}
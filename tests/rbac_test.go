@@ -0,0 +1,54 @@
+package handlers_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuiltinRoles(t *testing.T) {
+	assert.True(t, rbac.Has("admin", rbac.UsersWrite))
+	assert.True(t, rbac.Has("user", rbac.DataRead))
+	assert.True(t, rbac.Has("user", rbac.KPIsRead))
+	assert.False(t, rbac.Has("user", rbac.DataExport))
+	assert.False(t, rbac.Has("user", rbac.DevicesAll))
+	assert.False(t, rbac.Has("unknown", rbac.DataRead))
+}
+
+func TestRolePermissions(t *testing.T) {
+	assert.Equal(t, []rbac.Permission{rbac.DataRead, rbac.DevicesRead, rbac.KPIsRead}, rbac.Permissions("user"))
+	assert.Contains(t, rbac.Permissions("admin"), rbac.UsersWrite)
+	assert.Empty(t, rbac.Permissions("unknown"))
+}
+
+func TestScopeApply(t *testing.T) {
+	all := rbac.Scope{All: true}
+	assert.Equal(t, bson.M{}, all.Apply(bson.M{}))
+	assert.True(t, all.Allows("esp32-99"))
+
+	scope := rbac.Scope{DeviceIDs: []string{"esp32-01", "esp32-02"}}
+	assert.True(t, scope.Allows("esp32-01"))
+	assert.False(t, scope.Allows("esp32-03"))
+
+	assert.Equal(t, bson.M{"device_id": bson.M{"$in": bson.A{"esp32-01", "esp32-02"}}}, scope.Apply(bson.M{}))
+	assert.Equal(t, bson.M{"device_id": "esp32-02"}, scope.Apply(bson.M{"device_id": "esp32-02"}))
+	assert.Equal(t, bson.M{"device_id": bson.M{"$in": bson.A{}}}, scope.Apply(bson.M{"device_id": "esp32-03"}))
+}
+
+func TestAPIKeyFormat(t *testing.T) {
+	key, id, hash := utils.GenerateAPIKey()
+	assert.True(t, strings.HasPrefix(key, utils.APIKeyPrefix))
+
+	parsed, ok := utils.ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, id, parsed)
+	assert.True(t, utils.VerifyAPIKey(key, hash))
+	assert.False(t, utils.VerifyAPIKey(key+"x", hash))
+
+	_, ok = utils.ParseAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.False(t, ok)
+}
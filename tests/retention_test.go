@@ -0,0 +1,51 @@
+package handlers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/retention"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMetricStatsAdd(t *testing.T) {
+	var stats models.MetricStats
+	stats.Add(models.MetricStats{Count: 2, Sum: 10, Min: 4, Max: 6})
+	stats.Add(models.MetricStats{Count: 1, Sum: 2, Min: 2, Max: 2})
+
+	assert.Equal(t, 3, stats.Count)
+	assert.Equal(t, 2.0, stats.Min)
+	assert.Equal(t, 6.0, stats.Max)
+	assert.InDelta(t, 4.0, stats.Mean, 0.001)
+}
+
+func TestChooseResolution(t *testing.T) {
+	cfg := retention.Config{Raw: 30 * 24 * time.Hour, Hourly: 365 * 24 * time.Hour}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, models.ResolutionMinute, retention.ChooseResolution(cfg, now.Add(-2*time.Hour), now, now))
+	assert.Equal(t, models.ResolutionHour, retention.ChooseResolution(cfg, now.Add(-7*24*time.Hour), now, now))
+	assert.Equal(t, models.ResolutionHour, retention.ChooseResolution(cfg, now.Add(-40*24*time.Hour), now.Add(-39*24*time.Hour), now))
+	assert.Equal(t, models.ResolutionDay, retention.ChooseResolution(cfg, now.Add(-90*24*time.Hour), now, now))
+	assert.Equal(t, models.ResolutionDay, retention.ChooseResolution(cfg, now.Add(-400*24*time.Hour), now.Add(-399*24*time.Hour), now))
+}
+
+func TestTouchedHours(t *testing.T) {
+	at := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+	touched := retention.TouchedHours{}
+	for _, doc := range []bson.M{
+		{"device_id": "esp32-02", "timestamp": at.Add(10 * time.Minute)},
+		{"device_id": "esp32-01", "timestamp": at.Add(5 * time.Minute)},
+		{"device_id": "esp32-01", "timestamp": at.Add(50 * time.Minute)},
+		{"device_id": "esp32-01", "timestamp": at.Add(-time.Minute)}, // Late reading for the hour before
+		{"timestamp": at},
+	} {
+		touched.Add(doc)
+	}
+
+	assert.Equal(t, []time.Time{at.Add(-time.Hour), at}, touched.Hours())
+	assert.Equal(t, []string{"esp32-01", "esp32-02"}, touched.Devices(at))
+	assert.Equal(t, []string{"esp32-01"}, touched.Devices(at.Add(-time.Hour)))
+}
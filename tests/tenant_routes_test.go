@@ -0,0 +1,160 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rednexx46/esp32-backend-api/internal/db"
+	"github.com/rednexx46/esp32-backend-api/internal/handlers"
+	"github.com/rednexx46/esp32-backend-api/internal/middleware"
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Devices iso-acme-01 (group line-1) and iso-acme-02 (group line-2) belong to iso-acme,
+// iso-globex-01 to iso-globex. Each has one reading and one KPI.
+var isolationDevices = []models.Device{
+	{DeviceID: "iso-acme-01", TenantID: "iso-acme", Groups: []string{"line-1"}},
+	{DeviceID: "iso-acme-02", TenantID: "iso-acme", Groups: []string{"line-2"}},
+	{DeviceID: "iso-globex-01", TenantID: "iso-globex", Groups: []string{"line-1"}},
+}
+
+func seedTenantIsolation(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	database := db.GetMongoClient().Database(db.DBName)
+	devices := db.DevicesCollection()
+	users := database.Collection(db.CollectionName("MONGO_USERS_COLLECTION", "users"))
+
+	ids := bson.A{}
+	for _, d := range isolationDevices {
+		ids = append(ids, d.DeviceID)
+	}
+	names := bson.A{"iso-acme-admin", "iso-acme-line1"}
+	cleanup := func() {
+		devices.DeleteMany(ctx, bson.M{"device_id": bson.M{"$in": ids}})
+		db.SensorsCollection().DeleteMany(ctx, bson.M{"device_id": bson.M{"$in": ids}})
+		db.KPIsCollection().DeleteMany(ctx, bson.M{"device_id": bson.M{"$in": ids}})
+		users.DeleteMany(ctx, bson.M{"username": bson.M{"$in": names}})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	now := time.Now().UTC()
+	for _, d := range isolationDevices {
+		_, err := devices.InsertOne(ctx, d)
+		require.NoError(t, err)
+		_, err = db.SensorsCollection().InsertOne(ctx, bson.M{
+			"device_id": d.DeviceID, "tenant_id": d.TenantID, "timestamp": now, "payload": `{"temperature":21}`,
+		})
+		require.NoError(t, err)
+		_, err = db.KPIsCollection().InsertOne(ctx, models.KPI{
+			DeviceID: d.DeviceID, TenantID: d.TenantID, Name: "uptime", Value: 100, Timestamp: now, ComputedAt: now,
+		})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, db.CreateUser(models.User{Username: "iso-acme-admin", Role: "admin", TenantID: "iso-acme", CreatedAt: now}))
+	require.NoError(t, db.CreateUser(models.User{Username: "iso-acme-line1", Role: "user", TenantID: "iso-acme",
+		DeviceGroups: []string{"line-1"}, CreatedAt: now}))
+}
+
+func setupTenantRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handlers.InitCollections()
+	r := gin.New()
+
+	can := middleware.RequirePermission
+	scoped := middleware.DeviceScope()
+	selected := middleware.SelectDevices()
+	api := r.Group("/api", middleware.JWTMiddleware())
+	api.GET("/data/:device_id", can(rbac.DataRead), scoped, handlers.GetSensorDataByDevice)
+	api.GET("/devices/:device_id", can(rbac.DevicesRead), scoped, handlers.GetDevice)
+	api.GET("/kpis", can(rbac.KPIsRead), scoped, selected, handlers.GetAllKPIs)
+	return r
+}
+
+func tenantRequest(t *testing.T, r *gin.Engine, username, role, tenantID, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	token, err := utils.GenerateSessionToken(username, role, tenantID, "", time.Minute)
+	require.NoError(t, err)
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func kpiDevices(t *testing.T, resp *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var kpis []models.KPI
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &kpis))
+	var ids []string
+	for _, k := range kpis {
+		if k.Name == "uptime" && strings.HasPrefix(k.DeviceID, "iso-") {
+			ids = append(ids, k.DeviceID)
+		}
+	}
+	return ids
+}
+
+func TestTenantCannotReadAnotherTenantsDevices(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret")
+	seedTenantIsolation(t)
+	r := setupTenantRouter()
+
+	resp := tenantRequest(t, r, "iso-acme-admin", "admin", "iso-acme", "/api/data/iso-globex-01")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = tenantRequest(t, r, "iso-acme-admin", "admin", "iso-acme", "/api/devices/iso-globex-01")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// The tenant's own devices stay visible
+	resp = tenantRequest(t, r, "iso-acme-admin", "admin", "iso-acme", "/api/data/iso-acme-02")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "iso-acme-02")
+
+	resp = tenantRequest(t, r, "iso-acme-admin", "admin", "iso-acme", "/api/kpis?limit=500")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.ElementsMatch(t, []string{"iso-acme-01", "iso-acme-02"}, kpiDevices(t, resp))
+}
+
+func TestGroupLimitedUserOnlyReadsTheirGroups(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret")
+	seedTenantIsolation(t)
+	r := setupTenantRouter()
+
+	// Same tenant, other group
+	resp := tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/data/iso-acme-02")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/devices/iso-acme-02")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Same group name, other tenant
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/data/iso-globex-01")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/devices/iso-globex-01")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/devices/iso-acme-01")
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/kpis?limit=500")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, []string{"iso-acme-01"}, kpiDevices(t, resp))
+
+	// Selectors cannot widen the scope
+	resp = tenantRequest(t, r, "iso-acme-line1", "user", "iso-acme", "/api/kpis?limit=500&group=line-2")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, kpiDevices(t, resp))
+}
@@ -0,0 +1,132 @@
+package handlers_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rednexx46/esp32-backend-api/internal/models"
+	"github.com/rednexx46/esp32-backend-api/internal/rbac"
+	"github.com/rednexx46/esp32-backend-api/internal/sso"
+	"github.com/rednexx46/esp32-backend-api/internal/tenant"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTenantScopeApply(t *testing.T) {
+	scope := rbac.Scope{DeviceIDs: []string{"esp32-01"}, TenantID: "acme"}
+	assert.Equal(t, bson.M{
+		"device_id": bson.M{"$in": bson.A{"esp32-01"}},
+		"tenant_id": "acme",
+	}, scope.Apply(bson.M{}))
+	assert.Equal(t, bson.M{"device_id": bson.M{"$in": bson.A{}}, "tenant_id": "acme"},
+		scope.Apply(bson.M{"device_id": "esp32-02"}))
+}
+
+func TestTenantTopicMatch(t *testing.T) {
+	orgs := []models.Organization{
+		{ID: "acme", TopicPrefixes: []string{"sites/acme/"}},
+		{ID: "acme-lab", TopicPrefixes: []string{"sites/acme/lab/"}},
+	}
+	assert.Equal(t, "acme", tenant.Match(orgs, "sites/acme/esp32-01/data"))
+	assert.Equal(t, "acme-lab", tenant.Match(orgs, "sites/acme/lab/esp32-02/data"))
+	assert.Equal(t, "", tenant.Match(orgs, "sites/globex/esp32-03/data"))
+
+	assert.Equal(t, "", tenant.Match(orgs, "sites/acme-lab/esp32-04/data"))
+
+	assert.Equal(t, "acme", tenant.PrefixOwner(orgs, "sites/acme/", "globex"))
+	assert.Equal(t, "acme", tenant.PrefixOwner(orgs, "sites/", "globex"))
+	assert.Equal(t, "acme", tenant.PrefixOwner(orgs, "sites/acme/plant-2/", "globex"))
+	assert.Equal(t, "", tenant.PrefixOwner(orgs, "sites/acme-lab/", "globex"))
+	assert.Equal(t, "acme-lab", tenant.PrefixOwner(orgs, "sites/acme/", "acme"))
+
+	legacy := []models.Organization{{ID: "acme", TopicPrefixes: []string{"sites/acme"}}}
+	assert.Equal(t, "acme", tenant.Match(legacy, "sites/acme/esp32-01/data"))
+	assert.Equal(t, "", tenant.Match(legacy, "sites/acmeco/esp32-01/data"))
+
+	assert.Equal(t, "esp32-01", tenant.DeviceLevel(orgs, "sites/acme/esp32-01/data"))
+	assert.Equal(t, "esp32-02", tenant.DeviceLevel(orgs, "sites/acme/lab/esp32-02/data"))
+	assert.Equal(t, "esp32-01", tenant.DeviceLevel(legacy, "sites/acme/esp32-01"))
+	assert.Equal(t, "", tenant.DeviceLevel(orgs, "sites/globex/esp32-03/data"))
+
+	assert.True(t, tenant.ValidPrefix("sites/acme/"))
+	assert.False(t, tenant.ValidPrefix("sites/acme"))
+	assert.False(t, tenant.ValidPrefix("sites/+/"))
+	assert.False(t, tenant.ValidPrefix("/"))
+
+	assert.True(t, tenant.ValidID("acme-2"))
+	assert.False(t, tenant.ValidID("Acme/../x"))
+}
+
+func TestTenantRouterClaimsDevicesOnce(t *testing.T) {
+	owners := map[string]string{"esp32-09": "globex"}
+	claims := 0
+	router := tenant.NewRouter(
+		func(context.Context) ([]models.Organization, error) {
+			return []models.Organization{{ID: "acme", TopicPrefixes: []string{"sites/acme/"}}}, nil
+		},
+		func(_ context.Context, deviceID, tenantID string) (string, error) {
+			claims++
+			if owner, ok := owners[deviceID]; ok {
+				return owner, nil
+			}
+			owners[deviceID] = tenantID
+			return tenantID, nil
+		},
+	)
+
+	ctx := context.Background()
+	assert.Equal(t, "acme", router.TenantForTopic(ctx, "sites/acme/esp32-01"))
+	router.ClaimDevice(ctx, "sites/acme/esp32-01", "esp32-01", "acme")
+	router.ClaimDevice(ctx, "sites/acme/esp32-01", "esp32-01", "acme")
+	assert.Equal(t, 1, claims)
+	assert.Equal(t, "acme", owners["esp32-01"])
+
+	// A device of another tenant is not moved by publishing under this tenant's prefix
+	router.ClaimDevice(ctx, "sites/acme/esp32-09", "esp32-09", "acme")
+	assert.Equal(t, "globex", owners["esp32-09"])
+
+	// A device_id in the payload that is not the topic's device level is not claimed
+	router.ClaimDevice(ctx, "sites/acme/esp32-01", "esp32-07", "acme")
+	assert.Equal(t, 2, claims)
+	assert.NotContains(t, owners, "esp32-07")
+}
+
+func TestTenantClaimInToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret")
+
+	token, err := utils.GenerateSessionToken("alice", "admin", "acme", "", time.Minute)
+	require.NoError(t, err)
+	claims, err := utils.ParseToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", claims.TenantID)
+
+	// Platform users carry no tenant claim at all
+	token, err = utils.GenerateToken("root", "admin", time.Minute)
+	require.NoError(t, err)
+	claims, err = utils.ParseToken(token)
+	require.NoError(t, err)
+	assert.Empty(t, claims.TenantID)
+}
+
+func TestOIDCTenant(t *testing.T) {
+	open := sso.Config{}
+	tenantID, err := open.Tenant("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "", tenantID) // No tenant claim configured: platform user
+
+	cfg := sso.Config{TenantClaim: "org"}
+	tenantID, err = cfg.Tenant("acme")
+	assert.NoError(t, err)
+	assert.Equal(t, "acme", tenantID)
+	_, err = cfg.Tenant("")
+	assert.ErrorIs(t, err, sso.ErrNoTenant)
+
+	cfg.DefaultTenant = "globex"
+	tenantID, err = cfg.Tenant("")
+	assert.NoError(t, err)
+	assert.Equal(t, "globex", tenantID)
+}
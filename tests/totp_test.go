@@ -0,0 +1,65 @@
+package handlers_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rednexx46/esp32-backend-api/internal/totp"
+	"github.com/rednexx46/esp32-backend-api/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 appendix B vectors for the SHA-1 secret "12345678901234567890", truncated to 6 digits.
+func TestTOTPVectors(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		got, err := totp.Code(secret, totp.Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "t=%d", unix)
+	}
+
+	now := time.Unix(1111111109, 0)
+	step, ok := totp.Validate(secret, "081804", now.Add(totp.Period))
+	assert.True(t, ok, "previous step is accepted for clock drift")
+	assert.Equal(t, totp.Step(now), step)
+	_, ok = totp.Validate(secret, "081804", now.Add(3*totp.Period))
+	assert.False(t, ok)
+
+	uri := totp.URI("ESP32 Backend API", "alice", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/ESP32%20Backend%20API:alice?"))
+	assert.Contains(t, uri, "secret="+secret)
+
+	codes := totp.GenerateRecoveryCodes(10)
+	assert.Len(t, codes, 10)
+	assert.Equal(t, codes[0], totp.NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
+
+func TestMFAChallengeToken(t *testing.T) {
+	os.Setenv("JWT_SECRET", "test-secret")
+
+	challenge, err := utils.GenerateMFAChallenge("alice")
+	require.NoError(t, err)
+	username, err := utils.ParseMFAChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", username)
+
+	// A challenge must not verify as an access token
+	_, err = jwt.Parse(challenge, func(*jwt.Token) (interface{}, error) { return []byte("test-secret"), nil })
+	assert.Error(t, err)
+
+	// Nor an access token as a challenge
+	access, err := utils.GenerateToken("alice", "admin", time.Minute)
+	require.NoError(t, err)
+	_, err = utils.ParseMFAChallenge(access)
+	assert.Error(t, err)
+}